@@ -2,12 +2,12 @@ package main
 
 import (
 	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
 	"fmt"
 	"log"
 	"os"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -45,6 +45,28 @@ func main() {
 		&models.Permission{},
 		&models.UserHasRole{},
 		&models.RoleHasPermission{},
+		&models.RoleHasParent{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.UserRevocation{},
+		&models.UserMFA{},
+		&models.RecoveryCode{},
+		&models.ActionToken{},
+		&models.LoginAttempt{},
+		&models.PasswordHistory{},
+		&models.ExternalIdentity{},
+		&models.OIDCLoginState{},
+		&models.SAMLLoginState{},
+		&models.Passkey{},
+		&models.WebAuthnSession{},
+		&models.OAuthClient{},
+		&models.OAuthAuthorizationCode{},
+		&models.OAuthConsent{},
+		&models.PersonalAccessToken{},
+		&models.ServiceAccount{},
+		&models.ServiceAccountKey{},
+		&models.AuditLog{},
 	)
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
@@ -74,12 +96,27 @@ func seedDatabase(db *gorm.DB) error {
 		{Name: "permission.delete"},
 		{Name: "system.admin"},
 		{Name: "system.manage"},
+		{Name: "session.read"},
+		{Name: "session.revoke"},
+		{Name: "mfa.reset"},
+		{Name: "user.unlock"},
+		{Name: "client.create"},
+		{Name: "client.read"},
+		{Name: "client.delete"},
+		{Name: "service_account.create"},
+		{Name: "service_account.read"},
+		{Name: "service_account.update"},
+		{Name: "audit.read"},
+		{Name: "user.impersonate"},
+		{Name: utils.PermissionWildcard},
 	}
 
-	userPermissions := []models.Permission{
-		{Name: "user.read"},
-		{Name: "role.read"},
-		{Name: "permission.read"},
+	// Users can update their own profile and password only
+	userGrants := []models.RoleHasPermission{
+		{Permission: models.Permission{Name: "user.read"}, Scope: models.GrantScopeGlobal},
+		{Permission: models.Permission{Name: "role.read"}, Scope: models.GrantScopeGlobal},
+		{Permission: models.Permission{Name: "permission.read"}, Scope: models.GrantScopeGlobal},
+		{Permission: models.Permission{Name: "user.update"}, Scope: models.GrantScopeOwn},
 	}
 
 	for i := range permissions {
@@ -101,8 +138,9 @@ func seedDatabase(db *gorm.DB) error {
 	}
 
 	superAdminRole := models.Role{
-		Name:      "super_admin",
-		CreatedAt: time.Now(),
+		Name:       "super_admin",
+		RequireMFA: true,
+		CreatedAt:  time.Now(),
 	}
 
 	userRole := models.Role{
@@ -139,7 +177,11 @@ func seedDatabase(db *gorm.DB) error {
 		fmt.Println("Role already exists: user")
 	}
 
+	// super_admin holds every permission, including ones added later, through *
 	for _, permission := range permissions {
+		if permission.Name != utils.PermissionWildcard {
+			continue
+		}
 		var existingRolePermission models.RoleHasPermission
 		if err := db.Where("role_id = ? AND permission_id = ?", superAdminRole.ID, permission.ID).First(&existingRolePermission).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
@@ -160,29 +202,38 @@ func seedDatabase(db *gorm.DB) error {
 		}
 	}
 
-	for _, usrPermission := range userPermissions {
-		fmt.Print(usrPermission, " UserPermissions")
+	for _, grant := range userGrants {
+		for _, permission := range permissions {
+			if permission.Name == grant.Permission.Name {
+				grant.Permission = permission
+			}
+		}
 		var existingRolePermission models.RoleHasPermission
-		if err := db.Where("role_id = ? AND permission_id = ?", userRole.ID, usrPermission.ID).First(&existingRolePermission).Error; err != nil {
+		if err := db.Where("role_id = ? AND permission_id = ? AND scope = ?", userRole.ID, grant.Permission.ID, grant.Scope).First(&existingRolePermission).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
 				roleHasPermission := models.RoleHasPermission{
 					RoleID:       userRole.ID,
-					PermissionID: usrPermission.ID,
+					PermissionID: grant.Permission.ID,
+					Scope:        grant.Scope,
 					CreatedAt:    time.Now(),
 				}
 				if err := db.Create(&roleHasPermission).Error; err != nil {
-					return fmt.Errorf("failed to assign permission %s to user role: %v", usrPermission.Name, err)
+					return fmt.Errorf("failed to assign permission %s to user role: %v", grant.Permission.Name, err)
 				}
-				fmt.Printf("Assigned permission %s to user role\n", usrPermission.Name)
+				fmt.Printf("Assigned permission %s (%s) to user role\n", grant.Permission.Name, grant.Scope)
 			} else {
 				return fmt.Errorf("error checking role permission assignment: %v", err)
 			}
 		} else {
-			fmt.Printf("Permission %s already assigned to user role\n", usrPermission.Name)
+			fmt.Printf("Permission %s (%s) already assigned to user role\n", grant.Permission.Name, grant.Scope)
 		}
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("SuperAdmin123!"), bcrypt.DefaultCost)
+	hasher, err := utils.Passwords()
+	if err != nil {
+		return fmt.Errorf("failed to configure password hashing: %v", err)
+	}
+	hashedPassword, err := hasher.Hash("SuperAdmin123!")
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %v", err)
 	}
@@ -190,7 +241,7 @@ func seedDatabase(db *gorm.DB) error {
 	superAdminUser := models.User{
 		Name:      "Super Administrator",
 		Email:     "superadmin@example.com",
-		Password:  string(hashedPassword),
+		Password:  hashedPassword,
 		Status:    "active",
 		CreatedAt: time.Now(),
 	}
@@ -247,9 +298,32 @@ func seedDatabase(db *gorm.DB) error {
 				return fmt.Errorf("error checking role %s: %v", additionalRoles[i].Name, err)
 			}
 		} else {
+			additionalRoles[i] = existingAdditionalRole
 			fmt.Printf("Role already exists: %s\n", additionalRoles[i].Name)
 		}
 	}
 
+	// admin inherits everything editor can do, which inherits from viewer
+	for i := 0; i+1 < len(additionalRoles); i++ {
+		roleHasParent := models.RoleHasParent{
+			RoleID:    additionalRoles[i].ID,
+			ParentID:  additionalRoles[i+1].ID,
+			CreatedAt: time.Now(),
+		}
+		var existingRoleParent models.RoleHasParent
+		if err := db.Where("role_id = ? AND parent_id = ?", roleHasParent.RoleID, roleHasParent.ParentID).First(&existingRoleParent).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				if err := db.Create(&roleHasParent).Error; err != nil {
+					return fmt.Errorf("failed to make role %s inherit from %s: %v", additionalRoles[i].Name, additionalRoles[i+1].Name, err)
+				}
+				fmt.Printf("Role %s inherits from %s\n", additionalRoles[i].Name, additionalRoles[i+1].Name)
+			} else {
+				return fmt.Errorf("error checking role parent: %v", err)
+			}
+		} else {
+			fmt.Printf("Role %s already inherits from %s\n", additionalRoles[i].Name, additionalRoles[i+1].Name)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAuditLogs godoc
+// @Summary List audit log entries
+// @Description List changes made through the API, newest first. actor_type tells human users and service accounts apart.
+// @Tags Audit
+// @Produce json
+// @Security BearerAuth
+// @Param actor_type query string false "user or service_account"
+// @Param actor_id query int false "ID of the user or service account"
+// @Param action query string false "Action, e.g. user.delete"
+// @Param limit query int false "Maximum number of entries (default and max 100)"
+// @Success 200 {array} models.AuditLog
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /audit-logs [get]
+func GetAuditLogs(store services.AuditStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter := services.AuditFilter{
+			ActorType: c.Query("actor_type"),
+			Action:    c.Query("action"),
+		}
+		if filter.ActorType != "" && filter.ActorType != models.ActorUser && filter.ActorType != models.ActorServiceAccount {
+			c.JSON(400, gin.H{"error": "actor_type must be user or service_account"})
+			return
+		}
+		if value := c.Query("actor_id"); value != "" {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				c.JSON(400, gin.H{"error": "Invalid actor ID"})
+				return
+			}
+			filter.ActorID = uint(id)
+		}
+		if value := c.Query("limit"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil {
+				c.JSON(400, gin.H{"error": "Invalid limit"})
+				return
+			}
+			filter.Limit = limit
+		}
+
+		entries, err := store.List(filter)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		c.JSON(200, entries)
+	}
+}
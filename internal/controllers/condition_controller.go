@@ -0,0 +1,76 @@
+package controller
+
+import (
+	middleware "Admin-gin/internal/middlewares"
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ConditionDryRunRequest struct {
+	Condition string `json:"condition" binding:"required"`
+	// UserID is the caller the condition sees as principal, by default the
+	// user making the dry run
+	UserID uint `json:"user_id"`
+	// TargetID is the user the condition sees as resource
+	TargetID *uint `json:"target_id"`
+	// IP and Time default to those of the dry run request
+	IP     string     `json:"ip"`
+	Method string     `json:"method"`
+	Path   string     `json:"path"`
+	Time   *time.Time `json:"time"`
+}
+
+// DryRunCondition godoc
+// @Summary Test a grant condition
+// @Description Check the syntax of a CEL grant condition and evaluate it against a user, an optional target user and request attributes, without saving anything. Conditions can read principal (id, type, department, status, roles), resource (id, owner_id, department, status) and request (ip, method, path, time), and call inCIDR(ip, cidr).
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param condition body ConditionDryRunRequest true "Condition and context"
+// @Success 200 {object} services.ConditionResult
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /roles/conditions/dry-run [post]
+func DryRunCondition(c *gin.Context) {
+	var req ConditionDryRunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.UserID == 0 {
+		if principal, ok := middleware.CurrentPrincipal(c); ok {
+			req.UserID = principal.UserID
+		}
+		if req.UserID == 0 {
+			c.JSON(400, gin.H{"error": "user_id is required"})
+			return
+		}
+	}
+	request := utils.RequestInfo{IP: req.IP, Method: req.Method, Path: req.Path, Time: time.Now()}
+	if request.IP == "" {
+		request.IP = c.ClientIP()
+	}
+	if req.Time != nil {
+		request.Time = *req.Time
+	}
+
+	permissionService := services.NewPermissionService()
+	result, err := permissionService.DryRunCondition(req.Condition, req.UserID, req.TargetID, request)
+	if errors.Is(err, services.ErrInvalidCondition) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if errors.Is(err, services.ErrUserNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, result)
+}
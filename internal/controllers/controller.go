@@ -1,11 +1,14 @@
 package controller
 
 import (
+	middleware "Admin-gin/internal/middlewares"
 	"Admin-gin/internal/models"
 	"Admin-gin/internal/services"
 	"Admin-gin/internal/utils"
-	"fmt"
+	"errors"
+	"math"
 	"strconv"
+	"strings"
 
 	// "fmt"
 	"net/http"
@@ -21,10 +24,31 @@ type LoginCred struct {
 type RolePermissionRequest struct {
 	RoleID        uint   `json:"role_id" binding:"required"`
 	PermissionIDs []uint `json:"permission_ids" binding:"required"`
+	// Scope limits the grants to the caller's own record, their department or
+	// the record with ResourceID. Defaults to global.
+	Scope      string `json:"scope" enums:"global,own,group,resource"`
+	ResourceID *uint  `json:"resource_id"`
+	// Effect deny forbids the permissions whatever other grants allow.
+	// Defaults to allow.
+	Effect string `json:"effect" enums:"allow,deny"`
+	// Condition is a CEL expression the request must satisfy for the grant to
+	// apply, see POST /roles/conditions/dry-run
+	Condition string `json:"condition" binding:"omitempty,max=1000"`
 }
 
 type UpdateUserRequest struct {
 	Name string `json:"name" binding:"required"`
+	// Department is left unchanged when omitted. Changing it requires the
+	// global user.update permission.
+	Department *string `json:"department" binding:"omitempty,max=100"`
+}
+
+// RegisterRequest only takes what users may choose themselves; status, roles
+// and department are set by administrators
+type RegisterRequest struct {
+	Name     string `json:"name" binding:"required,max=100"`
+	Email    string `json:"email" binding:"required,email,max=100"`
+	Password string `json:"password" binding:"required"`
 }
 
 type ChangePasswordRequest struct {
@@ -41,6 +65,18 @@ type ResetPasswordRequest struct {
 	Password string `json:"new_password" binding:"required"`
 }
 
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+const (
+	refreshCookieName = "refresh_token"
+	refreshCookiePath = "/api/token"
+	// refreshDeliveryHeader set to "body" asks for the refresh token in the
+	// response body instead of the cookie, for clients without a cookie jar
+	refreshDeliveryHeader = "X-Refresh-Token-Delivery"
+)
+
 // UserListing godoc
 // @Summary Get all users
 // @Description Get a list of all users
@@ -54,8 +90,6 @@ type ResetPasswordRequest struct {
 func UserListing(c *gin.Context) {
 	userService := services.NewUserService()
 	users, err := userService.GetAllUsers()
-	// userId, isUserId := c.Get("userID")
-	// fmt.Println("UserID from token:", userId, isUserId)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -65,34 +99,302 @@ func UserListing(c *gin.Context) {
 
 // LoginHandler godoc
 // @Summary User login
-// @Description Authenticate user with email and password
+// @Description Authenticate user with email and password. The refresh token is set as an HttpOnly cookie, or returned in the body with the X-Refresh-Token-Delivery: body header.
 // @Tags Authentication
 // @Accept json
 // @Produce json
 // @Param credentials body LoginCred true "User credentials"
-// @Success 200 {object} map[string]interface{} "token and user data"
+// @Success 200 {object} map[string]interface{} "access token, refresh token and user data, or an mfa_token when a second factor is needed"
 // @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 403 {object} map[string]interface{} "error"
+// @Failure 429 {object} map[string]interface{} "error and retry_after in seconds"
 // @Failure 500 {object} map[string]interface{} "error"
 // @Router /login [post]
-func LoginHandler(c *gin.Context) {
-	var cred LoginCred
-	if err := c.ShouldBindJSON(&cred); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+func LoginHandler(throttle services.LoginThrottle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var cred LoginCred
+		if err := c.ShouldBindJSON(&cred); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if throttled(c, throttle, cred.Email) {
+			return
+		}
+
+		userService := services.NewUserService()
+		usr, err := userService.UserLogin(cred.Email, cred.Password)
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			if err := throttle.RecordFailure(cred.Email, c.ClientIP()); err != nil {
+				c.JSON(500, gin.H{"error": "Something went wrong"})
+				return
+			}
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		} else if errors.Is(err, services.ErrEmailNotVerified) {
+			c.JSON(403, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		methods := []string{utils.AMRPassword}
+		if requireMFAChallenge(c, usr.ID, methods) {
+			return
+		}
+
+		if err := throttle.RecordSuccess(usr.Email); err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		respondWithTokens(c, usr, methods)
+	}
+}
+
+// throttled rejects the request with 429 while the account or client IP is
+// backing off after failed attempts. It reports whether a response was written.
+func throttled(c *gin.Context, throttle services.LoginThrottle, email string) bool {
+	wait, err := throttle.Check(email, c.ClientIP())
+	if errors.Is(err, services.ErrTooManyAttempts) {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(429, gin.H{"error": err.Error(), "retry_after": retryAfter})
+		return true
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return true
+	}
+	return false
+}
+
+// RefreshToken godoc
+// @Summary Refresh access token
+// @Description Exchange a refresh token (cookie or body) for a new access token; the refresh token is rotated and returned the way it was sent
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param refresh body RefreshTokenRequest false "Refresh token, when not sent as cookie"
+// @Success 200 {object} map[string]interface{} "token and user data"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /token/refresh [post]
+func RefreshToken(c *gin.Context) {
+	refreshToken, fromBody := refreshTokenFromRequest(c)
+	if refreshToken == "" {
+		c.JSON(401, gin.H{"error": "refresh token missing"})
 		return
 	}
-	userService := services.NewUserService()
-	usr, err := userService.UserLogin(cred.Email, cred.Password)
+
+	tokenService := services.NewTokenService()
+	rotated, newRefreshToken, err := tokenService.RotateRefreshToken(refreshToken)
+	if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+		clearRefreshCookie(c)
+		c.JSON(401, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	sessionService := services.NewSessionService()
+	session, err := sessionService.GetSession(rotated.SessionID)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		clearRefreshCookie(c)
+		c.JSON(401, gin.H{"error": services.ErrInvalidRefreshToken.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	token, jti, err := utils.CreateToken(&rotated.User, rotated.SessionID, sessionAuthentication(session))
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	token, err := utils.CreateToken(usr)
+	if err := sessionService.TouchSession(rotated.SessionID, jti, rotated.ExpiresAt); err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	resp := tokenResponse(&rotated.User, token)
+	// A client that sent the token in the body keeps getting it there
+	deliverRefreshToken(c, resp, newRefreshToken, fromBody)
+	c.JSON(http.StatusOK, resp)
+}
+
+// Logout godoc
+// @Summary Logout
+// @Description Revoke the current access token and end its session
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /logout [post]
+func Logout(revocations services.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := middleware.CurrentPrincipal(c)
+		if !ok {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+		if err := revocations.RevokeToken(principal.TokenID, principal.ExpiresAt); err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		sessionService := services.NewSessionService()
+		_, err := sessionService.RevokeSession(principal.UserID, principal.SessionID)
+		if err != nil && !errors.Is(err, services.ErrSessionNotFound) {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		if principal.SessionID != "" {
+			if err := revocations.RevokeSession(principal.SessionID); err != nil {
+				c.JSON(500, gin.H{"error": "Something went wrong"})
+				return
+			}
+		}
+
+		clearRefreshCookie(c)
+		c.JSON(200, gin.H{"message": "Logged out successfully"})
+	}
+}
+
+// RevokeUserSessions godoc
+// @Summary Revoke all sessions of a user
+// @Description Invalidate every access and refresh token issued to the user
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /users/{id}/revoke-sessions [post]
+func RevokeUserSessions(revocations services.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Param("id")
+		id, err := strconv.ParseUint(userID, 10, 32)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		sessionService := services.NewSessionService()
+		if err := sessionService.RevokeUserSessions(uint(id)); err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		if err := revocations.RevokeUser(uint(id)); err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		c.JSON(200, gin.H{"message": "User sessions revoked successfully"})
+	}
+}
+
+// UnlockUser godoc
+// @Summary Unlock a user account
+// @Description Clear failed login attempts and lift a temporary lockout of the user's account
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /users/{id}/unlock [post]
+func UnlockUser(throttle services.LoginThrottle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		userService := services.NewUserService()
+		user, err := userService.GetUserByID(uint(id))
+		if errors.Is(err, services.ErrUserNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		if err := throttle.Unlock(user.Email); err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "User unlocked successfully"})
+	}
+}
+
+// sessionAuthentication returns the auth_time and amr carried by the session's access tokens
+func sessionAuthentication(session *models.Session) utils.Authentication {
+	return utils.Authentication{Time: session.AuthTime, Methods: session.AuthMethodList()}
+}
+
+// respondWithTokens starts a session for an authenticated user and responds
+// with its access and refresh token pair
+func respondWithTokens(c *gin.Context, usr *models.User, methods []string) {
+	if resp, ok := issueTokens(c, usr, methods); ok {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// issueTokens starts a session and returns the login response body. methods are
+// the amr values of the login. On failure the error response has already been written.
+func issueTokens(c *gin.Context, usr *models.User, methods []string) (map[string]interface{}, bool) {
+	sessionService := services.NewSessionService()
+	session, err := sessionService.CreateSession(usr.ID, c.ClientIP(), c.Request.UserAgent(), methods)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
-		return
+		return nil, false
+	}
+
+	token, jti, err := utils.CreateToken(usr, session.ID, sessionAuthentication(session))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	tokenService := services.NewTokenService()
+	refreshToken, err := tokenService.IssueRefreshToken(usr.ID, session.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	if err := sessionService.TouchSession(session.ID, jti, session.ExpiresAt); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	resp := tokenResponse(usr, token)
+	deliverRefreshToken(c, resp, refreshToken, false)
+	return resp, true
+}
+
+// currentUserID returns the ID of the authenticated user set by AuthMiddleware
+func currentUserID(c *gin.Context) (uint, bool) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		return 0, false
 	}
+	return principal.UserID, true
+}
 
+func tokenResponse(usr *models.User, token string) map[string]interface{} {
 	resp := make(map[string]interface{})
 	userData := make(map[string]interface{})
 
@@ -103,9 +405,63 @@ func LoginHandler(c *gin.Context) {
 	userData["created_at"] = usr.CreatedAt
 
 	resp["token"] = token
+	resp["expires_in"] = int(utils.AccessTokenTTL().Seconds())
 	resp["user"] = userData
 
-	c.JSON(http.StatusOK, resp)
+	return resp
+}
+
+// refreshTokenFromRequest reads the refresh token from the cookie or, for
+// clients without cookies, the JSON body, and reports whether it was the body
+func refreshTokenFromRequest(c *gin.Context) (string, bool) {
+	if cookie, err := c.Cookie(refreshCookieName); err == nil && cookie != "" {
+		return cookie, false
+	}
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return "", false
+	}
+	return req.RefreshToken, req.RefreshToken != ""
+}
+
+// deliverRefreshToken sets the refresh cookie, or adds the refresh token to
+// the response body when the client asked for it there. It is never sent
+// both ways, so a browser never exposes it to JavaScript.
+func deliverRefreshToken(c *gin.Context, resp map[string]interface{}, refreshToken string, inBody bool) {
+	if inBody || strings.EqualFold(c.GetHeader(refreshDeliveryHeader), "body") {
+		resp["refresh_token"] = refreshToken
+		return
+	}
+	setRefreshCookie(c, refreshToken)
+}
+
+// The refresh cookie is HttpOnly and scoped to the token endpoints so the SPA
+// never has to keep long lived credentials in JavaScript memory.
+func setRefreshCookie(c *gin.Context, refreshToken string) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(refreshCookieName, refreshToken, int(services.RefreshTokenTTL().Seconds()),
+		refreshCookiePath, "", secureCookies(), true)
+}
+
+func clearRefreshCookie(c *gin.Context) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(refreshCookieName, "", -1, refreshCookiePath, "", secureCookies(), true)
+}
+
+func secureCookies() bool {
+	return utils.GetEnv("APP_ENV", "local") != "local"
+}
+
+// JWKS serves the public keys used to verify tokens issued by this API. It is
+// mounted at /.well-known/jwks.json, outside of the /api base path.
+func JWKS(c *gin.Context) {
+	keys, err := utils.Keys()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keys.JWKS())
 }
 
 // RegisterHandler godoc
@@ -114,20 +470,20 @@ func LoginHandler(c *gin.Context) {
 // @Tags Authentication
 // @Accept json
 // @Produce json
-// @Param user body models.User true "User data"
+// @Param user body RegisterRequest true "User data"
 // @Success 200 {object} map[string]interface{} "message"
 // @Failure 400 {object} map[string]interface{} "error"
+// @Failure 422 {object} map[string]interface{} "error and the violated password rules"
 // @Failure 500 {object} map[string]interface{} "error"
 // @Router /register [post]
 func RegisterHandler(c *gin.Context) {
-	var req models.User
+	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 	userService := services.NewUserService()
 	userData, err := userService.GetUserByEmail(req.Email)
-	fmt.Print(userData, "::userData")
 	if err != nil {
 		c.JSON(500, gin.H{"error": "somethings went wrong"})
 		return
@@ -135,8 +491,10 @@ func RegisterHandler(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "email already exists"})
 		return
 	}
-	user, err := userService.AddUser(&req)
-	if err != nil {
+	user, err := userService.AddUser(&models.User{Name: req.Name, Email: req.Email, Password: req.Password})
+	if passwordRejected(c, err) {
+		return
+	} else if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
@@ -157,24 +515,17 @@ func RegisterHandler(c *gin.Context) {
 // @Produce json
 // @Param token query string true "Verification token"
 // @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
 // @Failure 500 {object} map[string]interface{} "error"
 // @Router /verify [get]
 func VerifyEmail(c *gin.Context) {
 	token := c.Query("token")
-	decryptedEmail, err := utils.Decrypt(token)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "email is not valid"})
-		return
-	}
 	userService := services.NewUserService()
-	user, err := userService.GetUserByEmail(decryptedEmail)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Something went wrong"})
-		return
-	}
-
-	user.Status = "active"
-	if err = userService.UpdateUser(user); err != nil {
+	if err := userService.VerifyEmail(token); err != nil {
+		if errors.Is(err, services.ErrInvalidActionToken) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(500, gin.H{"error": "Something went wrong"})
 		return
 	}
@@ -184,7 +535,7 @@ func VerifyEmail(c *gin.Context) {
 
 // UpdateUser godoc
 // @Summary Update user information
-// @Description Update user data by ID
+// @Description Update user data by ID. Callers whose user.update grant is scoped can only update the users it covers, e.g. themselves, and cannot change departments.
 // @Tags Users
 // @Accept json
 // @Produce json
@@ -193,6 +544,8 @@ func VerifyEmail(c *gin.Context) {
 // @Param user body UpdateUserRequest true "User update data"
 // @Success 200 {object} map[string]interface{} "message"
 // @Failure 400 {object} map[string]interface{} "error"
+// @Failure 403 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
 // @Failure 500 {object} map[string]interface{} "error"
 // @Router /users/{id} [put]
 func UpdateUser(c *gin.Context) {
@@ -209,13 +562,19 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
-	user := models.User{
-		ID:   uint(id),
-		Name: req.Name,
+	// A grant scoped to the caller's own record or department must not move
+	// users between departments
+	if grant, ok := middleware.CurrentGrant(c); req.Department != nil && (!ok || !grant.IsGlobal()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden: changing the department requires the global user.update permission"})
+		return
 	}
 
 	userService := services.NewUserService()
-	if err := userService.UpdateUser(&user); err != nil {
+	err = userService.UpdateUser(uint(id), services.UserUpdate{Name: req.Name, Department: req.Department})
+	if errors.Is(err, services.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
@@ -225,7 +584,7 @@ func UpdateUser(c *gin.Context) {
 
 // CreatePermission godoc
 // @Summary Create a new permission
-// @Description Create a new permission
+// @Description Create a new permission named resource.action, or a pattern granting several: resource.*, *.action or *
 // @Tags Permissions
 // @Accept json
 // @Produce json
@@ -242,7 +601,11 @@ func CreatePermission(c *gin.Context) {
 		return
 	}
 	permissionService := services.NewPermissionService()
-	if err := permissionService.AddPermission(&perm); err != nil {
+	err := permissionService.AddPermission(&perm)
+	if errors.Is(err, services.ErrInvalidPermissionName) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
 		c.JSON(500, gin.H{"error": "Something went wrong"})
 		return
 	}
@@ -286,6 +649,7 @@ func CreateRole(c *gin.Context) {
 // @Param userRole body models.UserHasRole true "User role assignment"
 // @Success 200 {object} map[string]interface{} "message"
 // @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error, error_description and max_age when a role granting * needs a recent re-authentication"
 // @Failure 500 {object} map[string]interface{} "error"
 // @Router /users/{id}/assign-role [post]
 func AssignRoleToUser(c *gin.Context) {
@@ -295,6 +659,22 @@ func AssignRoleToUser(c *gin.Context) {
 		return
 	}
 	roleService := services.NewRoleService()
+	_, err := roleService.GetRole(userRole.RoleID)
+	if errors.Is(err, services.ErrRoleNotFound) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	grants, err := roleService.GetEffectiveGrants([]uint{userRole.RoleID})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	if !checkWildcardReauth(c, grants) {
+		return
+	}
 	if err := roleService.AssignRoleToUser(&userRole); err != nil {
 		c.JSON(500, gin.H{"error": "Something went wrong"})
 		return
@@ -304,7 +684,7 @@ func AssignRoleToUser(c *gin.Context) {
 
 // AssignPermissionsToRole godoc
 // @Summary Assign permissions to role
-// @Description Assign multiple permissions to a specific role
+// @Description Allow or deny multiple permissions to a specific role, on every record or limited to a scope, optionally only when a CEL condition holds. Denies win over allows from any role.
 // @Tags Roles
 // @Accept json
 // @Produce json
@@ -312,6 +692,7 @@ func AssignRoleToUser(c *gin.Context) {
 // @Param rolePermission body RolePermissionRequest true "Role permission assignment"
 // @Success 200 {object} map[string]interface{} "message"
 // @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error, error_description and max_age when allowing * needs a recent re-authentication"
 // @Failure 500 {object} map[string]interface{} "error"
 // @Router /roles/permissions [post]
 func AssignPermissionsToRole(c *gin.Context) {
@@ -320,8 +701,32 @@ func AssignPermissionsToRole(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	if rolePerm.Effect != models.GrantEffectDeny {
+		permissions, err := services.NewPermissionService().GetPermissionsByIDs(rolePerm.PermissionIDs)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		grants := make([]utils.Grant, len(permissions))
+		for i, p := range permissions {
+			grants[i] = utils.Grant{Permission: p.Name}
+		}
+		if !checkWildcardReauth(c, grants) {
+			return
+		}
+	}
 	roleService := services.NewRoleService()
-	if err := roleService.AssignPermissionsToRole(rolePerm.RoleID, rolePerm.PermissionIDs); err != nil {
+	err := roleService.AssignPermissionsToRole(rolePerm.RoleID, rolePerm.PermissionIDs, services.GrantOptions{
+		Effect:     rolePerm.Effect,
+		Scope:      rolePerm.Scope,
+		ResourceID: rolePerm.ResourceID,
+		Condition:  rolePerm.Condition,
+	})
+	if errors.Is(err, services.ErrInvalidGrantScope) || errors.Is(err, services.ErrInvalidGrantEffect) ||
+		errors.Is(err, services.ErrInvalidCondition) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
 		c.JSON(500, gin.H{"error": "Something went wrong"})
 		return
 	}
@@ -461,6 +866,7 @@ func DeleteUser(c *gin.Context) {
 // @Param passwordData body ChangePasswordRequest true "Password change data"
 // @Success 200 {object} map[string]interface{} "message"
 // @Failure 400 {object} map[string]interface{} "error"
+// @Failure 422 {object} map[string]interface{} "error and the violated password rules"
 // @Failure 500 {object} map[string]interface{} "error"
 // @Router /users/{id}/password [put]
 func ChangePassword(c *gin.Context) {
@@ -479,6 +885,9 @@ func ChangePassword(c *gin.Context) {
 
 	userService := services.NewUserService()
 	if err := userService.ChangePassword(uint(id), req.OldPassword, req.NewPassword); err != nil {
+		if passwordRejected(c, err) {
+			return
+		}
 		c.JSON(500, gin.H{"error": "Something went wrong"})
 		return
 	}
@@ -487,7 +896,7 @@ func ChangePassword(c *gin.Context) {
 
 // ForgotPassword godoc
 // @Summary Request password reset
-// @Description Send password reset email to user
+// @Description Send a single-use password reset link; the response is the same whether or not the email is registered
 // @Tags Authentication
 // @Accept json
 // @Produce json
@@ -502,16 +911,12 @@ func ForgotPassword(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	token, err := utils.Encrypt(req.Email)
-	if err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
-	}
-	if err := utils.SendPasswordResetEmail(req.Email, token); err != nil {
+	userService := services.NewUserService()
+	if err := userService.ForgotPassword(req.Email); err != nil {
 		c.JSON(500, gin.H{"error": "Something went wrong"})
 		return
 	}
-	c.JSON(200, gin.H{"message": "Password reset email sent"})
+	c.JSON(200, gin.H{"message": "If an account exists for this email, a password reset link has been sent"})
 }
 
 // ResetPassword godoc
@@ -523,6 +928,7 @@ func ForgotPassword(c *gin.Context) {
 // @Param resetData body ResetPasswordRequest true "Reset password data"
 // @Success 200 {object} map[string]interface{} "message"
 // @Failure 400 {object} map[string]interface{} "error"
+// @Failure 422 {object} map[string]interface{} "error and the violated password rules"
 // @Failure 500 {object} map[string]interface{} "error"
 // @Router /reset-password [post]
 func ResetPassword(c *gin.Context) {
@@ -531,19 +937,31 @@ func ResetPassword(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	email, err := utils.Decrypt(req.Token)
-	if err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
-	}
 	userService := services.NewUserService()
-	if err := userService.ResetPassword(email, req.Password); err != nil {
+	if err := userService.ResetPassword(req.Token, req.Password); err != nil {
+		if errors.Is(err, services.ErrInvalidActionToken) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		} else if passwordRejected(c, err) {
+			return
+		}
 		c.JSON(500, gin.H{"error": "Something went wrong"})
 		return
 	}
 	c.JSON(200, gin.H{"message": "Password reset successfully"})
 }
 
+// passwordRejected responds with 422 and every failed rule when err is a
+// password policy violation. It reports whether a response was written.
+func passwordRejected(c *gin.Context, err error) bool {
+	var policyErr *services.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+	c.JSON(422, gin.H{"error": policyErr.Error(), "violations": policyErr.Violations})
+	return true
+}
+
 // DeleteRole godoc
 // @Summary Delete role
 // @Description Delete a role by ID
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	middleware "Admin-gin/internal/middlewares"
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StartImpersonation godoc
+// @Summary Impersonate a user
+// @Description Issue a short-lived access token that acts as the user, with the caller recorded in its act claim. Users holding permissions the caller lacks cannot be impersonated. The token cannot be refreshed and sensitive actions such as changing passwords are blocked.
+// @Tags Users
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} map[string]interface{} "token, expires_in, user and impersonator_id"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 403 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /users/{id}/impersonate [post]
+func StartImpersonation(c *gin.Context) {
+	actorID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	impersonation, err := services.NewImpersonationService().Start(actorID, uint(id))
+	switch {
+	case errors.Is(err, services.ErrImpersonateSelf):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrImpersonationNotPermitted):
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	usr := impersonation.User
+	c.JSON(200, gin.H{
+		"token":      impersonation.Token,
+		"expires_in": int(utils.ImpersonationTTL().Seconds()),
+		"user": gin.H{
+			"id":         usr.ID,
+			"name":       usr.Name,
+			"email":      usr.Email,
+			"status":     usr.Status,
+			"created_at": usr.CreatedAt,
+		},
+		"impersonator_id": actorID,
+	})
+}
+
+// StopImpersonation godoc
+// @Summary Stop impersonating
+// @Description Revoke the impersonation token used for this request
+// @Tags Users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /impersonation/stop [post]
+func StopImpersonation(revocations services.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := middleware.CurrentPrincipal(c)
+		if !ok {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+		if !principal.IsImpersonating() {
+			c.JSON(400, gin.H{"error": "not impersonating"})
+			return
+		}
+
+		if err := revocations.RevokeToken(principal.TokenID, principal.ExpiresAt); err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		middleware.SetAuditTarget(c, strconv.FormatUint(uint64(principal.UserID), 10))
+		c.JSON(200, gin.H{"message": "Impersonation ended"})
+	}
+}
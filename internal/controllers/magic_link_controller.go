@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	magicLinkCookieName = "magic_nonce"
+	magicLinkCookiePath = "/api/login/magic"
+)
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,max=100"`
+}
+
+type RoleMagicLinkRequest struct {
+	AllowMagicLink bool `json:"allow_magic_link"`
+}
+
+// RequestMagicLink godoc
+// @Summary Request a sign-in link
+// @Description Email a single-use sign-in link to users whose role allows it. The link only works in the browser that requested it, identified by a nonce cookie. The response is the same whether or not a link was sent. Requests are limited per address and per client IP.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body MagicLinkRequest true "Email address"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 429 {object} map[string]interface{} "error and retry_after in seconds"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /login/magic [post]
+func RequestMagicLink(throttle services.LoginThrottle, limiter services.SendLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MagicLinkRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if throttled(c, throttle, req.Email) {
+			return
+		}
+		// Every request would otherwise send a mail and replace the
+		// outstanding link, so they are limited whether or not one is sent
+		wait, err := limiter.Allow(req.Email, c.ClientIP())
+		if errors.Is(err, services.ErrTooManyRequests) {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(429, gin.H{"error": err.Error(), "retry_after": retryAfter})
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		nonce, err := services.NewMagicLinkService().Send(req.Email)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		// Lax, since the link is opened as a top-level navigation from the mail client
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(magicLinkCookieName, nonce, int(services.ActionTokenTTL(models.PurposeMagicLogin).Seconds()),
+			magicLinkCookiePath, "", secureCookies(), true)
+		c.JSON(200, gin.H{"message": "If your account can sign in with a link, we have emailed you one"})
+	}
+}
+
+// MagicLinkLogin godoc
+// @Summary Sign in with an emailed link
+// @Description Target of the sign-in link. Must be opened in the browser that requested the link, and responds like /login.
+// @Tags Authentication
+// @Produce json
+// @Param token query string true "Sign-in link token"
+// @Success 200 {object} map[string]interface{} "access token, refresh token and user data, or an mfa_token when a second factor is needed"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 429 {object} map[string]interface{} "error and retry_after in seconds"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /login/magic/verify [get]
+func MagicLinkLogin(throttle services.LoginThrottle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nonce, _ := c.Cookie(magicLinkCookieName)
+
+		usr, err := services.NewMagicLinkService().Login(c.Query("token"), nonce)
+		if errors.Is(err, services.ErrInvalidActionToken) {
+			c.JSON(401, gin.H{"error": "invalid or expired sign-in link, or it was opened in another browser"})
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(magicLinkCookieName, "", -1, magicLinkCookiePath, "", secureCookies(), true)
+
+		// A locked account stays locked whichever way it signs in
+		if throttled(c, throttle, usr.Email) {
+			return
+		}
+		methods := []string{utils.AMREmail}
+		if requireMFAChallenge(c, usr.ID, methods) {
+			return
+		}
+
+		if err := throttle.RecordSuccess(usr.Email); err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		respondWithTokens(c, usr, methods)
+	}
+}
+
+// SetRoleMagicLink godoc
+// @Summary Allow sign-in links for a role
+// @Description Enable or disable passwordless sign-in by email link for every user holding the role
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Role ID"
+// @Param magicLink body RoleMagicLinkRequest true "Whether sign-in links are allowed"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /roles/{id}/magic-link [put]
+func SetRoleMagicLink(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid role ID"})
+		return
+	}
+	var req RoleMagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	roleService := services.NewRoleService()
+	if err := roleService.SetAllowMagicLink(uint(id), req.AllowMagicLink); err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Role sign-in link setting updated successfully"})
+}
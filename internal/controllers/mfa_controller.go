@@ -0,0 +1,486 @@
+package controller
+
+import (
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
+	"errors"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type MFAChallengeRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type RoleMFARequest struct {
+	RequireMFA bool `json:"require_mfa"`
+}
+
+// requireMFAChallenge answers the login with an mfa_pending challenge token when the
+// user has a second factor (TOTP or a passkey) or one of their roles requires
+// it. methods are the amr values of the first factor. It reports whether a
+// response was written.
+func requireMFAChallenge(c *gin.Context, userID uint, methods []string) bool {
+	mfaService := services.NewMFAService()
+	totp, err := mfaService.IsEnabled(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return true
+	}
+	passkeys, err := services.NewPasskeyService().HasPasskeys(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return true
+	}
+	required, err := mfaService.IsRequired(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return true
+	}
+	enabled := totp || passkeys
+	if !enabled && !required {
+		return false
+	}
+
+	factors := []string{}
+	if totp {
+		factors = append(factors, "totp")
+	}
+	if passkeys {
+		factors = append(factors, "passkey")
+	}
+
+	challenge, err := utils.CreateMFAChallengeToken(userID, methods)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return true
+	}
+
+	c.JSON(200, gin.H{
+		"mfa_required":            true,
+		"mfa_enrollment_required": !enabled,
+		"mfa_methods":             factors,
+		"mfa_token":               challenge,
+		"expires_in":              int(utils.MFAChallengeTTL.Seconds()),
+	})
+	return true
+}
+
+// withSecondFactor adds the amr value of a verified second factor to those of the first
+func withSecondFactor(methods []string, method string) []string {
+	combined := append([]string{}, methods...)
+	for _, m := range []string{method, utils.AMRMultiFactor} {
+		if !slices.Contains(combined, m) {
+			combined = append(combined, m)
+		}
+	}
+	return combined
+}
+
+// VerifyMFALogin godoc
+// @Summary Complete login with a second factor
+// @Description Exchange the mfa_token returned by login and a TOTP or recovery code for the access token. Confirms a pending enrollment started with /login/mfa/enroll, which users with a passkey cannot use.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param mfa body MFALoginRequest true "MFA challenge and code"
+// @Success 200 {object} map[string]interface{} "access token, refresh token and user data"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 429 {object} map[string]interface{} "error and retry_after in seconds"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /login/mfa [post]
+func VerifyMFALogin(throttle services.LoginThrottle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MFALoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		userID, methods, err := utils.ParseMFAChallengeToken(req.MFAToken)
+		if err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+
+		userService := services.NewUserService()
+		usr, err := userService.GetActiveUser(userID)
+		if err != nil {
+			c.JSON(401, gin.H{"error": utils.ErrInvalidMFAChallenge.Error()})
+			return
+		}
+		// Wrong codes count towards the same lockout as wrong passwords
+		if throttled(c, throttle, usr.Email) {
+			return
+		}
+
+		mfaService := services.NewMFAService()
+		enabled, err := mfaService.IsEnabled(userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		var recoveryCodes []string
+		if enabled {
+			err = mfaService.Verify(userID, req.Code)
+		} else if err = mfaService.CheckLoginEnrollment(userID); err == nil {
+			// Users with a passkey must use it, not a TOTP enrolled with their password
+			recoveryCodes, err = mfaService.Confirm(userID, req.Code)
+		}
+		if errors.Is(err, services.ErrInvalidMFACode) || errors.Is(err, services.ErrMFANotEnrolled) ||
+			errors.Is(err, services.ErrMFAEnrollmentNotAllowed) {
+			if err := throttle.RecordFailure(usr.Email, c.ClientIP()); err != nil {
+				c.JSON(500, gin.H{"error": "Something went wrong"})
+				return
+			}
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		if err := throttle.RecordSuccess(usr.Email); err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		resp, ok := issueTokens(c, usr, withSecondFactor(methods, utils.AMROTP))
+		if !ok {
+			return
+		}
+		if recoveryCodes != nil {
+			resp["recovery_codes"] = recoveryCodes
+		}
+		c.JSON(200, resp)
+	}
+}
+
+// EnrollMFALogin godoc
+// @Summary Enroll MFA during login
+// @Description Start TOTP enrollment with an mfa_token when a role requires MFA and the user has no second factor yet, neither TOTP nor a passkey
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param mfa body MFAChallengeRequest true "MFA challenge"
+// @Success 200 {object} services.MFAEnrollment
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 403 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /login/mfa/enroll [post]
+func EnrollMFALogin(c *gin.Context) {
+	var req MFAChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	userID, _, err := utils.ParseMFAChallengeToken(req.MFAToken)
+	if err != nil {
+		c.JSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	userService := services.NewUserService()
+	usr, err := userService.GetActiveUser(userID)
+	if err != nil {
+		c.JSON(401, gin.H{"error": utils.ErrInvalidMFAChallenge.Error()})
+		return
+	}
+
+	mfaService := services.NewMFAService()
+	if err := mfaService.CheckLoginEnrollment(usr.ID); errors.Is(err, services.ErrMFAEnrollmentNotAllowed) {
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	enrollment, err := mfaService.Enroll(usr)
+	if errors.Is(err, services.ErrMFAAlreadyEnabled) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, enrollment)
+}
+
+// GetMyMFA godoc
+// @Summary Get my MFA status
+// @Description Whether two-factor authentication is enabled or required for the authenticated user
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "enabled and required flags"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/mfa [get]
+func GetMyMFA(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	mfaService := services.NewMFAService()
+	enabled, err := mfaService.IsEnabled(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	required, err := mfaService.IsRequired(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"enabled": enabled, "required": required})
+}
+
+// EnrollMyMFA godoc
+// @Summary Start MFA enrollment
+// @Description Generate a TOTP secret and otpauth:// URI; enrollment is active after /me/mfa/confirm
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} services.MFAEnrollment
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/mfa/enroll [post]
+func EnrollMyMFA(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userService := services.NewUserService()
+	usr, err := userService.GetActiveUser(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	mfaService := services.NewMFAService()
+	enrollment, err := mfaService.Enroll(usr)
+	if errors.Is(err, services.ErrMFAAlreadyEnabled) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, enrollment)
+}
+
+// ConfirmMyMFA godoc
+// @Summary Confirm MFA enrollment
+// @Description Activate the pending enrollment with a first TOTP code and receive one-time recovery codes
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param code body MFACodeRequest true "TOTP code"
+// @Success 200 {object} map[string]interface{} "recovery_codes"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/mfa/confirm [post]
+func ConfirmMyMFA(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	mfaService := services.NewMFAService()
+	codes, err := mfaService.Confirm(userID, req.Code)
+	if errors.Is(err, services.ErrInvalidMFACode) || errors.Is(err, services.ErrMFANotEnrolled) ||
+		errors.Is(err, services.ErrMFAAlreadyEnabled) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"recovery_codes": codes})
+}
+
+// RegenerateMyRecoveryCodes godoc
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes, requires a valid TOTP code
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param code body MFACodeRequest true "TOTP code"
+// @Success 200 {object} map[string]interface{} "recovery_codes"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/mfa/recovery-codes [post]
+func RegenerateMyRecoveryCodes(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	mfaService := services.NewMFAService()
+	if err := mfaService.Verify(userID, req.Code); err != nil {
+		if errors.Is(err, services.ErrInvalidMFACode) || errors.Is(err, services.ErrMFANotEnrolled) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	codes, err := mfaService.RegenerateRecoveryCodes(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"recovery_codes": codes})
+}
+
+// DisableMyMFA godoc
+// @Summary Disable MFA
+// @Description Remove the TOTP enrollment, not allowed when one of the user's roles requires MFA and no passkey is registered
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param code body MFACodeRequest true "TOTP or recovery code"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 403 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/mfa [delete]
+func DisableMyMFA(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	mfaService := services.NewMFAService()
+	required, err := mfaService.IsRequired(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	// A registered passkey keeps satisfying the requirement
+	passkeys, err := services.NewPasskeyService().HasPasskeys(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	if required && !passkeys {
+		c.JSON(403, gin.H{"error": services.ErrMFARequiredByRole.Error()})
+		return
+	}
+	if err := mfaService.Verify(userID, req.Code); err != nil {
+		if errors.Is(err, services.ErrInvalidMFACode) || errors.Is(err, services.ErrMFANotEnrolled) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	if err := mfaService.Disable(userID); err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// ResetUserMFA godoc
+// @Summary Reset MFA of a user
+// @Description Remove the TOTP enrollment and recovery codes of a user, e.g. after a lost device
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /users/{id}/mfa [delete]
+func ResetUserMFA(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	mfaService := services.NewMFAService()
+	if err := mfaService.Disable(uint(id)); err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Two-factor authentication reset successfully"})
+}
+
+// SetRoleMFA godoc
+// @Summary Require MFA for a role
+// @Description Enable or disable mandatory two-factor authentication for every user holding the role
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Role ID"
+// @Param mfa body RoleMFARequest true "MFA requirement"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /roles/{id}/mfa [put]
+func SetRoleMFA(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid role ID"})
+		return
+	}
+	var req RoleMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	roleService := services.NewRoleService()
+	if err := roleService.SetRequireMFA(uint(id), req.RequireMFA); err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Role MFA requirement updated successfully"})
+}
@@ -0,0 +1,416 @@
+package controller
+
+import (
+	middleware "Admin-gin/internal/middlewares"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
+	"errors"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OAuthConsentRequest struct {
+	services.OAuthAuthorizeRequest
+	Approve bool `json:"approve"`
+}
+
+// respondOAuthError writes an RFC 6749 error response. It reports whether err
+// was an OAuth error.
+func respondOAuthError(c *gin.Context, err error) bool {
+	var oauthErr *services.OAuthError
+	if !errors.As(err, &oauthErr) {
+		return false
+	}
+
+	status := 400
+	switch oauthErr.Code {
+	case "invalid_client":
+		status = 401
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	case "insufficient_scope":
+		status = 403
+	}
+	c.JSON(status, gin.H{"error": oauthErr.Code, "error_description": oauthErr.Description})
+	return true
+}
+
+// authenticateOAuthClient reads client credentials from HTTP Basic auth or the
+// form body, as allowed by RFC 6749 section 2.3.1
+func authenticateOAuthClient(c *gin.Context) (*models.OAuthClient, bool) {
+	clientID, secret, ok := c.Request.BasicAuth()
+	if ok {
+		clientID, _ = neturl.QueryUnescape(clientID)
+		secret, _ = neturl.QueryUnescape(secret)
+	} else {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	client, err := services.NewOAuthClientService().AuthenticateClient(clientID, secret)
+	if err != nil {
+		if !respondOAuthError(c, err) {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+		}
+		return nil, false
+	}
+	return client, true
+}
+
+// OpenIDConfiguration serves the OpenID Connect discovery document of the
+// authorization server. It is mounted at /.well-known/openid-configuration,
+// outside of the /api base path.
+func OpenIDConfiguration(c *gin.Context) {
+	keys, err := utils.Keys()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	// ID tokens are only issued with asymmetric keys, so HS256 is never
+	// advertised and openid is left out without such a key
+	scopes := []string{services.ScopeProfile, services.ScopeEmail}
+	if keys.CanSignIDTokens() {
+		scopes = append([]string{services.ScopeOpenID}, scopes...)
+	}
+
+	issuer := utils.OAuthIssuer()
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                         issuer,
+		"authorization_endpoint":                         services.OAuthConsentURL(),
+		"token_endpoint":                                 issuer + "/api/oauth/token",
+		"userinfo_endpoint":                              issuer + "/api/oauth/userinfo",
+		"introspection_endpoint":                         issuer + "/api/oauth/introspect",
+		"revocation_endpoint":                            issuer + "/api/oauth/revoke",
+		"jwks_uri":                                       issuer + "/.well-known/jwks.json",
+		"response_types_supported":                       []string{"code"},
+		"grant_types_supported":                          []string{"authorization_code", "client_credentials"},
+		"subject_types_supported":                        []string{"public"},
+		"id_token_signing_alg_values_supported":          keys.PublicAlgorithms(),
+		"scopes_supported":                               scopes,
+		"claims_supported":                               []string{"sub", "name", "email", "email_verified", "auth_time", "nonce"},
+		"code_challenge_methods_supported":               []string{"S256"},
+		"token_endpoint_auth_methods_supported":          []string{"client_secret_basic", "client_secret_post", "none"},
+		"authorization_response_iss_parameter_supported": true,
+	})
+}
+
+// GetOAuthAuthorization godoc
+// @Summary Validate an authorization request
+// @Description Called by the consent screen with the query of the authorization request. Returns the client and the scopes that would be granted, and whether the user has to consent.
+// @Tags OAuth
+// @Produce json
+// @Security BearerAuth
+// @Param response_type query string true "Must be code"
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string false "Registered redirect URI"
+// @Param scope query string true "Space separated scopes"
+// @Param state query string false "Opaque client state"
+// @Param nonce query string false "OpenID Connect nonce"
+// @Param code_challenge query string false "PKCE challenge, required for public clients"
+// @Param code_challenge_method query string false "Must be S256"
+// @Success 200 {object} services.OAuthConsentPrompt
+// @Failure 400 {object} map[string]interface{} "error and error_description"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /oauth/authorize [get]
+func GetOAuthAuthorization(revocations services.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req services.OAuthAuthorizeRequest
+		if err := c.ShouldBindQuery(&req); err != nil {
+			c.JSON(400, gin.H{"error": "invalid_request", "error_description": err.Error()})
+			return
+		}
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		prompt, err := services.NewOAuthService(revocations).PrepareAuthorization(userID, &req)
+		if respondOAuthError(c, err) {
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		c.JSON(200, prompt)
+	}
+}
+
+// PostOAuthAuthorization godoc
+// @Summary Approve or deny an authorization request
+// @Description Records the user's decision. The consent screen must send the browser to redirect_to, which carries the authorization code or an access_denied error back to the client.
+// @Tags OAuth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param consent body OAuthConsentRequest true "Authorization request parameters and the user's decision"
+// @Success 200 {object} map[string]interface{} "redirect_to"
+// @Failure 400 {object} map[string]interface{} "error and error_description"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /oauth/authorize [post]
+func PostOAuthAuthorization(revocations services.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req OAuthConsentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "invalid_request", "error_description": err.Error()})
+			return
+		}
+		principal, ok := middleware.CurrentPrincipal(c)
+		if !ok {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		oauthService := services.NewOAuthService(revocations)
+		var redirectTo string
+		var err error
+		if req.Approve {
+			var authTime time.Time
+			if principal.Claims != nil && principal.Claims.AuthTime != nil {
+				authTime = principal.Claims.AuthTime.Time
+			}
+			redirectTo, err = oauthService.Authorize(principal.UserID, authTime, &req.OAuthAuthorizeRequest)
+		} else {
+			redirectTo, err = oauthService.Deny(&req.OAuthAuthorizeRequest)
+		}
+		if respondOAuthError(c, err) {
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		c.JSON(200, gin.H{"redirect_to": redirectTo})
+	}
+}
+
+// OAuthToken godoc
+// @Summary OAuth2 token endpoint
+// @Description Redeems an authorization code (with PKCE) or issues a token with the client credentials grant. Clients authenticate with HTTP Basic or client_id/client_secret form fields; public clients send client_id only.
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "authorization_code or client_credentials"
+// @Param code formData string false "Authorization code"
+// @Param redirect_uri formData string false "Redirect URI of the authorization request, required for authorization_code"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param scope formData string false "Requested scopes for client_credentials"
+// @Param client_id formData string false "Client ID when not using HTTP Basic"
+// @Param client_secret formData string false "Client secret when not using HTTP Basic"
+// @Success 200 {object} services.OAuthTokenResponse
+// @Failure 400 {object} map[string]interface{} "error and error_description"
+// @Failure 401 {object} map[string]interface{} "error and error_description"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /oauth/token [post]
+func OAuthToken(revocations services.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		c.Header("Pragma", "no-cache")
+
+		client, ok := authenticateOAuthClient(c)
+		if !ok {
+			return
+		}
+
+		oauthService := services.NewOAuthService(revocations)
+		var response *services.OAuthTokenResponse
+		var err error
+		switch c.PostForm("grant_type") {
+		case "authorization_code":
+			response, err = oauthService.ExchangeCode(client,
+				c.PostForm("code"), c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+		case "client_credentials":
+			response, err = oauthService.ClientCredentials(client, c.PostForm("scope"))
+		default:
+			c.JSON(400, gin.H{"error": "unsupported_grant_type", "error_description": "unsupported grant_type"})
+			return
+		}
+		if respondOAuthError(c, err) {
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		c.JSON(200, response)
+	}
+}
+
+// OAuthIntrospect godoc
+// @Summary Token introspection (RFC 7662)
+// @Description Reports whether an access token issued to the calling client is active, with its scope, client and subject. Only confidential clients can introspect; clients listed in OAUTH_INTROSPECTION_CLIENTS can introspect tokens of any client.
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Access token"
+// @Param token_type_hint formData string false "access_token"
+// @Success 200 {object} services.OAuthIntrospection
+// @Failure 401 {object} map[string]interface{} "error and error_description"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /oauth/introspect [post]
+func OAuthIntrospect(revocations services.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, ok := authenticateOAuthClient(c)
+		if !ok {
+			return
+		}
+
+		introspection, err := services.NewOAuthService(revocations).Introspect(client, c.PostForm("token"))
+		if respondOAuthError(c, err) {
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		c.JSON(200, introspection)
+	}
+}
+
+// OAuthRevoke godoc
+// @Summary Token revocation (RFC 7009)
+// @Description Revokes an access token issued to the calling client. Unknown tokens are ignored.
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Param token formData string true "Access token"
+// @Param token_type_hint formData string false "access_token"
+// @Success 200 "Token revoked or unknown"
+// @Failure 400 {object} map[string]interface{} "error and error_description"
+// @Failure 401 {object} map[string]interface{} "error and error_description"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /oauth/revoke [post]
+func OAuthRevoke(revocations services.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, ok := authenticateOAuthClient(c)
+		if !ok {
+			return
+		}
+
+		err := services.NewOAuthService(revocations).Revoke(client, c.PostForm("token"))
+		if respondOAuthError(c, err) {
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		c.Status(200)
+	}
+}
+
+// OAuthUserInfo godoc
+// @Summary OpenID Connect userinfo
+// @Description Returns the claims of the user an OAuth access token with the openid scope was issued for
+// @Tags OAuth
+// @Produce json
+// @Param Authorization header string true "Bearer access token issued by the token endpoint"
+// @Success 200 {object} map[string]interface{} "sub, name, email, email_verified"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 403 {object} map[string]interface{} "error and error_description"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /oauth/userinfo [get]
+func OAuthUserInfo(revocations services.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || token == "" {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(401, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		info, err := services.NewOAuthService(revocations).UserInfo(token)
+		if errors.Is(err, utils.ErrInvalidToken) {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(401, gin.H{"error": "invalid_token"})
+			return
+		} else if respondOAuthError(c, err) {
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		c.JSON(200, info)
+	}
+}
+
+// CreateOAuthClient godoc
+// @Summary Register an OAuth client
+// @Description Register a confidential or public client. The client_secret of confidential clients is only returned once.
+// @Tags OAuth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param client body services.OAuthClientInput true "Client metadata"
+// @Success 201 {object} map[string]interface{} "client and client_secret"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /oauth/clients [post]
+func CreateOAuthClient(c *gin.Context) {
+	var req services.OAuthClientInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, secret, err := services.NewOAuthClientService().RegisterClient(&req)
+	if respondOAuthError(c, err) {
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	response := gin.H{"client": client}
+	if secret != "" {
+		response["client_secret"] = secret
+	}
+	c.JSON(201, response)
+}
+
+// GetOAuthClients godoc
+// @Summary List OAuth clients
+// @Tags OAuth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} services.OAuthClientResponse
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /oauth/clients [get]
+func GetOAuthClients(c *gin.Context) {
+	clients, err := services.NewOAuthClientService().GetClients()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, clients)
+}
+
+// DeleteOAuthClient godoc
+// @Summary Delete an OAuth client
+// @Description Delete a client. Tokens already issued to it stay valid until they expire.
+// @Tags OAuth
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Client record ID"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /oauth/clients/{id} [delete]
+func DeleteOAuthClient(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid client ID"})
+		return
+	}
+
+	err = services.NewOAuthClientService().DeleteClient(uint(id))
+	if errors.Is(err, services.ErrOAuthClientNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Client deleted successfully"})
+}
@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	oidcStateCookieName = "oidc_state"
+	oidcStateCookiePath = "/api/auth"
+)
+
+// OIDCStart godoc
+// @Summary Start an external login
+// @Description Redirect to the identity provider using the authorization code flow with PKCE
+// @Tags Authentication
+// @Param provider path string true "Provider name as configured in OIDC_PROVIDERS"
+// @Success 302 "Redirect to the identity provider"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 502 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /auth/{provider}/start [get]
+func OIDCStart(c *gin.Context) {
+	oidcService := services.NewOIDCService()
+	login, err := oidcService.Start(c.Request.Context(), c.Param("provider"))
+	if errors.Is(err, services.ErrUnknownOIDCProvider) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	} else if errors.Is(err, services.ErrOIDCProviderError) {
+		c.JSON(502, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	// Lax, since the callback is a top-level navigation coming from the provider
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookieName, login.State, int(services.OIDCLoginStateTTL.Seconds()),
+		oidcStateCookiePath, "", secureCookies(), true)
+	c.Redirect(http.StatusFound, login.AuthURL)
+}
+
+// OIDCCallback godoc
+// @Summary Finish an external login
+// @Description Redirect target of the identity provider. Links the external identity to the account with the same verified email, or provisions a new account, and responds like /login.
+// @Tags Authentication
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param code query string false "Authorization code"
+// @Param state query string true "State of the authorization request"
+// @Param error query string false "Error returned by the provider"
+// @Success 200 {object} map[string]interface{} "access token, refresh token and user data, or an mfa_token when a second factor is needed"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 403 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 409 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /auth/{provider}/callback [get]
+func OIDCCallback(c *gin.Context) {
+	cookieState, _ := c.Cookie(oidcStateCookieName)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookieName, "", -1, oidcStateCookiePath, "", secureCookies(), true)
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(400, gin.H{"error": providerErr, "error_description": c.Query("error_description")})
+		return
+	}
+
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		c.JSON(400, gin.H{"error": "state and code are required"})
+		return
+	}
+	// The state must come back to the browser that started the login
+	if subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		c.JSON(400, gin.H{"error": services.ErrInvalidOIDCState.Error()})
+		return
+	}
+
+	oidcService := services.NewOIDCService()
+	usr, err := oidcService.Callback(c.Request.Context(), c.Param("provider"), state, code)
+	switch {
+	case errors.Is(err, services.ErrUnknownOIDCProvider):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrInvalidOIDCState):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrOIDCLoginFailed):
+		c.JSON(401, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrOIDCEmailNotVerified):
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrOIDCAccountNotVerified):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	methods := []string{utils.AMRFederated}
+	if requireMFAChallenge(c, usr.ID, methods) {
+		return
+	}
+	respondWithTokens(c, usr, methods)
+}
@@ -0,0 +1,358 @@
+package controller
+
+import (
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PasskeyRegistrationRequest struct {
+	Session string `json:"session" binding:"required"`
+	// Name tells the user's passkeys apart, defaults to "Passkey"
+	Name string `json:"name"`
+	// Credential is the PublicKeyCredential returned by navigator.credentials.create
+	Credential json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+}
+
+type PasskeyLoginRequest struct {
+	Session string `json:"session" binding:"required"`
+	// Credential is the PublicKeyCredential returned by navigator.credentials.get
+	Credential json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+}
+
+type PasskeyMFARequest struct {
+	MFAToken   string          `json:"mfa_token" binding:"required"`
+	Session    string          `json:"session" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+}
+
+type PasskeyRenameRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// GetMyPasskeys godoc
+// @Summary List my passkeys
+// @Tags Passkeys
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "passkeys"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/passkeys [get]
+func GetMyPasskeys(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	passkeys, err := services.NewPasskeyService().GetPasskeys(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"passkeys": passkeys})
+}
+
+// BeginPasskeyRegistration godoc
+// @Summary Start registering a passkey
+// @Description Returns the options for navigator.credentials.create and a session to send back to /me/passkeys/register/finish
+// @Tags Passkeys
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} services.PasskeyChallenge
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/passkeys/register/begin [post]
+func BeginPasskeyRegistration(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	challenge, err := services.NewPasskeyService().BeginRegistration(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, challenge)
+}
+
+// FinishPasskeyRegistration godoc
+// @Summary Finish registering a passkey
+// @Description Verify the authenticator's response and attach the passkey to the account
+// @Tags Passkeys
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param passkey body PasskeyRegistrationRequest true "Session, name and credential"
+// @Success 201 {object} map[string]interface{} "passkey"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/passkeys/register/finish [post]
+func FinishPasskeyRegistration(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req PasskeyRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	passkey, err := services.NewPasskeyService().FinishRegistration(userID, req.Session, req.Name, req.Credential)
+	if errors.Is(err, services.ErrInvalidWebAuthnSession) || errors.Is(err, services.ErrPasskeyVerificationFailed) ||
+		errors.Is(err, services.ErrInvalidPasskeyName) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(201, gin.H{"passkey": passkey})
+}
+
+// RenameMyPasskey godoc
+// @Summary Rename a passkey
+// @Tags Passkeys
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Passkey ID"
+// @Param passkey body PasskeyRenameRequest true "New name"
+// @Success 200 {object} map[string]interface{} "passkey"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/passkeys/{id} [patch]
+func RenameMyPasskey(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid passkey ID"})
+		return
+	}
+	var req PasskeyRenameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	passkey, err := services.NewPasskeyService().RenamePasskey(userID, uint(id), req.Name)
+	if errors.Is(err, services.ErrInvalidPasskeyName) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if errors.Is(err, services.ErrPasskeyNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"passkey": passkey})
+}
+
+// DeleteMyPasskey godoc
+// @Summary Remove a passkey
+// @Description Not allowed for the last second factor when one of the user's roles requires MFA
+// @Tags Passkeys
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Passkey ID"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 403 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/passkeys/{id} [delete]
+func DeleteMyPasskey(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid passkey ID"})
+		return
+	}
+
+	err = services.NewPasskeyService().DeletePasskey(userID, uint(id))
+	if errors.Is(err, services.ErrMFARequiredByRole) {
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	} else if errors.Is(err, services.ErrPasskeyNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Passkey removed successfully"})
+}
+
+// BeginPasskeyLogin godoc
+// @Summary Start a passkey login
+// @Description Returns the options for navigator.credentials.get and a session to send back to /login/passkey/finish. No username is needed, the passkey identifies the account.
+// @Tags Authentication
+// @Produce json
+// @Success 200 {object} services.PasskeyChallenge
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /login/passkey/begin [post]
+func BeginPasskeyLogin(c *gin.Context) {
+	challenge, err := services.NewPasskeyService().BeginLogin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, challenge)
+}
+
+// FinishPasskeyLogin godoc
+// @Summary Log in with a passkey
+// @Description Verify the authenticator's response and respond like /login. The passkey must verify the user (PIN or biometrics), so no further second factor is asked for.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param passkey body PasskeyLoginRequest true "Session and credential"
+// @Success 200 {object} map[string]interface{} "access token, refresh token and user data"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 429 {object} map[string]interface{} "error and retry_after in seconds"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /login/passkey/finish [post]
+func FinishPasskeyLogin(throttle services.LoginThrottle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req PasskeyLoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		usr, err := services.NewPasskeyService().FinishLogin(req.Session, req.Credential)
+		if errors.Is(err, services.ErrInvalidWebAuthnSession) || errors.Is(err, services.ErrPasskeyVerificationFailed) {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		// A locked account stays locked whichever way it signs in
+		if throttled(c, throttle, usr.Email) {
+			return
+		}
+		if err := throttle.RecordSuccess(usr.Email); err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		// User verification is required, so the passkey counts as two factors
+		respondWithTokens(c, usr, []string{utils.AMRHardwareKey, utils.AMRMultiFactor})
+	}
+}
+
+// BeginPasskeyMFA godoc
+// @Summary Start a passkey second factor
+// @Description Exchange the mfa_token returned by login for the options for navigator.credentials.get, limited to the user's passkeys
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param mfa body MFAChallengeRequest true "MFA challenge"
+// @Success 200 {object} services.PasskeyChallenge
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /login/mfa/passkey/begin [post]
+func BeginPasskeyMFA(c *gin.Context) {
+	var req MFAChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	userID, _, err := utils.ParseMFAChallengeToken(req.MFAToken)
+	if err != nil {
+		c.JSON(401, gin.H{"error": err.Error()})
+		return
+	}
+
+	challenge, err := services.NewPasskeyService().BeginSecondFactor(userID)
+	if errors.Is(err, services.ErrNoPasskeys) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, challenge)
+}
+
+// VerifyPasskeyMFA godoc
+// @Summary Complete login with a passkey as second factor
+// @Description Exchange the mfa_token returned by login and the authenticator's response for the access token
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param mfa body PasskeyMFARequest true "MFA challenge, session and credential"
+// @Success 200 {object} map[string]interface{} "access token, refresh token and user data"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 429 {object} map[string]interface{} "error and retry_after in seconds"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /login/mfa/passkey/finish [post]
+func VerifyPasskeyMFA(throttle services.LoginThrottle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req PasskeyMFARequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		userID, methods, err := utils.ParseMFAChallengeToken(req.MFAToken)
+		if err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+
+		userService := services.NewUserService()
+		usr, err := userService.GetActiveUser(userID)
+		if err != nil {
+			c.JSON(401, gin.H{"error": utils.ErrInvalidMFAChallenge.Error()})
+			return
+		}
+		if throttled(c, throttle, usr.Email) {
+			return
+		}
+
+		err = services.NewPasskeyService().FinishSecondFactor(userID, req.Session, req.Credential)
+		if errors.Is(err, services.ErrInvalidWebAuthnSession) || errors.Is(err, services.ErrPasskeyVerificationFailed) {
+			if err := throttle.RecordFailure(usr.Email, c.ClientIP()); err != nil {
+				c.JSON(500, gin.H{"error": "Something went wrong"})
+				return
+			}
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		if err := throttle.RecordSuccess(usr.Email); err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		respondWithTokens(c, usr, withSecondFactor(methods, utils.AMRHardwareKey))
+	}
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExplainUserPermission godoc
+// @Summary Explain a permission check
+// @Description Evaluate a user's grants for a permission the way permission checks do and report the grant that allowed it, or the deny that blocked it. Without target only grants on every record count; with target, grants scoped to that user's record count too. Conditions see the time and IP address of this request unless ip is set.
+// @Tags Permissions
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param permission query string true "Permission, e.g. user.delete"
+// @Param target query int false "ID of the user acted on"
+// @Param ip query string false "IP address conditions see as request.ip"
+// @Success 200 {object} services.PermissionExplanation
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /users/{id}/permissions/explain [get]
+func ExplainUserPermission(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var targetID *uint
+	if value := c.Query("target"); value != "" {
+		target, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid target ID"})
+			return
+		}
+		t := uint(target)
+		targetID = &t
+	}
+
+	permissionService := services.NewPermissionService()
+	request := utils.RequestInfo{IP: c.DefaultQuery("ip", c.ClientIP()), Time: time.Now()}
+	explanation, err := permissionService.Explain(uint(id), c.Query("permission"), targetID, request)
+	if errors.Is(err, services.ErrInvalidPermissionName) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if errors.Is(err, services.ErrUserNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, explanation)
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"Admin-gin/internal/services"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMyTokens godoc
+// @Summary List my personal access tokens
+// @Description List the active personal access tokens of the authenticated user
+// @Tags Personal Access Tokens
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "tokens"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/tokens [get]
+func GetMyTokens(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	tokens, err := services.NewPersonalAccessTokenService().GetTokens(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"tokens": tokens})
+}
+
+// CreateMyToken godoc
+// @Summary Create a personal access token
+// @Description Create a token for API access, limited to a subset of the caller's permissions. The token is only returned once; send it as a bearer token or in the X-API-Key header.
+// @Tags Personal Access Tokens
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param token body services.PersonalAccessTokenInput true "Name, scopes and expiry"
+// @Success 201 {object} map[string]interface{} "token details and the plaintext token"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 403 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/tokens [post]
+func CreateMyToken(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req services.PersonalAccessTokenInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, plaintext, err := services.NewPersonalAccessTokenService().CreateToken(userID, &req)
+	if errors.Is(err, services.ErrPersonalAccessTokenExpiry) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if errors.Is(err, services.ErrPersonalAccessTokenScope) {
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(201, gin.H{"token": token, "access_token": plaintext})
+}
+
+// DeleteMyToken godoc
+// @Summary Revoke a personal access token
+// @Tags Personal Access Tokens
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Token ID"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/tokens/{id} [delete]
+func DeleteMyToken(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	err = services.NewPersonalAccessTokenService().RevokeToken(userID, uint(id))
+	if errors.Is(err, services.ErrPersonalAccessTokenNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Token revoked successfully"})
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	middleware "Admin-gin/internal/middlewares"
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
+	"encoding/json"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReauthRequest proves the user's identity again with exactly one of a
+// password, a TOTP or recovery code, or a passkey assertion
+type ReauthRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+	// Session and Credential answer the challenge of /reauth/passkey
+	Session    string          `json:"session"`
+	Credential json.RawMessage `json:"credential" swaggertype:"object"`
+}
+
+// BeginPasskeyReauth godoc
+// @Summary Start re-authenticating with a passkey
+// @Description Returns the options for navigator.credentials.get and a session to send to /reauth with the credential
+// @Tags Authentication
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} services.PasskeyChallenge
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /reauth/passkey [post]
+func BeginPasskeyReauth(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	challenge, err := services.NewPasskeyService().BeginSecondFactor(userID)
+	if errors.Is(err, services.ErrNoPasskeys) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, challenge)
+}
+
+// Reauthenticate godoc
+// @Summary Re-authenticate
+// @Description Prove your identity again with the password, a TOTP or recovery code, or a passkey. Returns an access token for the current session with a fresh auth_time, accepted by sensitive operations for REAUTH_WINDOW.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param reauth body ReauthRequest true "Password, code or passkey assertion"
+// @Success 200 {object} map[string]interface{} "token, expires_in and auth_time"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 429 {object} map[string]interface{} "error and retry_after in seconds"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /reauth [post]
+func Reauthenticate(throttle services.LoginThrottle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := middleware.CurrentPrincipal(c)
+		if !ok || principal.SessionID == "" {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+		var req ReauthRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		provided := 0
+		for _, given := range []bool{req.Password != "", req.Code != "", req.Session != "" || len(req.Credential) > 0} {
+			if given {
+				provided++
+			}
+		}
+		if provided != 1 {
+			c.JSON(400, gin.H{"error": "send exactly one of password, code or a passkey session and credential"})
+			return
+		}
+
+		userService := services.NewUserService()
+		usr, err := userService.GetActiveUser(principal.UserID)
+		if err != nil {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+		// Guessing counts towards the same lockout as failed logins
+		if throttled(c, throttle, usr.Email) {
+			return
+		}
+
+		var method string
+		switch {
+		case req.Password != "":
+			method = utils.AMRPassword
+			err = userService.VerifyPassword(usr.ID, req.Password)
+		case req.Code != "":
+			method = utils.AMROTP
+			err = services.NewMFAService().Verify(usr.ID, req.Code)
+		default:
+			method = utils.AMRHardwareKey
+			err = services.NewPasskeyService().FinishSecondFactor(usr.ID, req.Session, req.Credential)
+		}
+		if errors.Is(err, services.ErrInvalidCredentials) || errors.Is(err, services.ErrInvalidMFACode) ||
+			errors.Is(err, services.ErrMFANotEnrolled) || errors.Is(err, services.ErrInvalidWebAuthnSession) ||
+			errors.Is(err, services.ErrPasskeyVerificationFailed) {
+			if err := throttle.RecordFailure(usr.Email, c.ClientIP()); err != nil {
+				c.JSON(500, gin.H{"error": "Something went wrong"})
+				return
+			}
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		sessionService := services.NewSessionService()
+		session, err := sessionService.Reauthenticate(principal.SessionID, []string{method})
+		if errors.Is(err, services.ErrSessionNotFound) {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		token, jti, err := utils.CreateToken(usr, session.ID, sessionAuthentication(session))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		if err := sessionService.TouchSession(session.ID, jti, session.ExpiresAt); err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+		if err := throttle.RecordSuccess(usr.Email); err != nil {
+			c.JSON(500, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"token":      token,
+			"expires_in": int(utils.AccessTokenTTL().Seconds()),
+			"auth_time":  session.AuthTime.Unix(),
+		})
+	}
+}
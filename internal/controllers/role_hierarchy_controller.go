@@ -0,0 +1,105 @@
+package controller
+
+import (
+	middleware "Admin-gin/internal/middlewares"
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoleParentsRequest struct {
+	// ParentIDs replaces the parents of the role, an empty list removes them
+	ParentIDs []uint `json:"parent_ids" binding:"required"`
+}
+
+// checkWildcardReauth asks for a recent re-authentication when grants about to
+// be handed out allow *, which is as sensitive as deleting a user. It reports
+// whether the request may go on.
+func checkWildcardReauth(c *gin.Context, grants []utils.Grant) bool {
+	return !utils.AllowsWildcard(grants) || middleware.CheckRecentAuth(c)
+}
+
+// SetRoleParents godoc
+// @Summary Set the parents of a role
+// @Description Replace the roles a role inherits permissions from. Holders of the role get the permissions of all its ancestors. Rejected when the role would inherit from itself. Parents granting * need a recent re-authentication.
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Role ID"
+// @Param parents body RoleParentsRequest true "Parent role IDs"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error, error_description and max_age when a parent granting * needs a recent re-authentication"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 409 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /roles/{id}/parents [put]
+func SetRoleParents(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid role ID"})
+		return
+	}
+	var req RoleParentsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	roleService := services.NewRoleService()
+	grants, err := roleService.GetEffectiveGrants(req.ParentIDs)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	if !checkWildcardReauth(c, grants) {
+		return
+	}
+	err = roleService.SetParents(uint(id), req.ParentIDs)
+	if errors.Is(err, services.ErrRoleNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	} else if errors.Is(err, services.ErrRoleCycle) {
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Role parents updated successfully"})
+}
+
+// GetRolePermissions godoc
+// @Summary Get the permissions of a role
+// @Description Get the parents of a role, the permissions assigned to it directly and its effective permissions, which include those inherited from all its ancestors
+// @Tags Roles
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Role ID"
+// @Success 200 {object} services.RolePermissions
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /roles/{id}/permissions [get]
+func GetRolePermissions(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	roleService := services.NewRoleService()
+	permissions, err := roleService.GetRolePermissions(uint(id))
+	if errors.Is(err, services.ErrRoleNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(200, permissions)
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	samlStateCookieName = "saml_state"
+	samlStateCookiePath = "/api/saml"
+)
+
+// SAMLMetadata godoc
+// @Summary SAML service provider metadata
+// @Description Metadata to register this service with the SAML identity provider
+// @Tags Authentication
+// @Produce xml
+// @Success 200 {string} string "SAML EntityDescriptor"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /saml/metadata [get]
+func SAMLMetadata(c *gin.Context) {
+	samlService := services.NewSAMLService()
+	metadata, err := samlService.Metadata()
+	if errors.Is(err, services.ErrSAMLNotConfigured) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// SAMLStart godoc
+// @Summary Start a SAML login
+// @Description Redirect to the identity provider with an AuthnRequest
+// @Tags Authentication
+// @Success 302 "Redirect to the identity provider"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /saml/login [get]
+func SAMLStart(c *gin.Context) {
+	samlService := services.NewSAMLService()
+	login, err := samlService.Start()
+	if errors.Is(err, services.ErrSAMLNotConfigured) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	// The identity provider posts the response cross-site, which only
+	// SameSite=None cookies survive, and browsers require those to be Secure
+	c.SetSameSite(http.SameSiteNoneMode)
+	c.SetCookie(samlStateCookieName, login.State, int(services.SAMLLoginStateTTL.Seconds()),
+		samlStateCookiePath, "", true, true)
+	c.Redirect(http.StatusFound, login.RedirectURL)
+}
+
+// SAMLACS godoc
+// @Summary SAML assertion consumer service
+// @Description Receives the identity provider's signed response to an AuthnRequest. Links the NameID to the account with the asserted email, or provisions a new account, syncs mapped roles and responds like /login.
+// @Tags Authentication
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param SAMLResponse formData string true "Base64 encoded SAML response"
+// @Param RelayState formData string true "Relay state of the AuthnRequest"
+// @Success 200 {object} map[string]interface{} "access token, refresh token and user data, or an mfa_token when a second factor is needed"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 403 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 409 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /saml/acs [post]
+func SAMLACS(c *gin.Context) {
+	cookieState, _ := c.Cookie(samlStateCookieName)
+	c.SetSameSite(http.SameSiteNoneMode)
+	c.SetCookie(samlStateCookieName, "", -1, samlStateCookiePath, "", true, true)
+
+	samlResponse, relayState := c.PostForm("SAMLResponse"), c.PostForm("RelayState")
+	if samlResponse == "" || relayState == "" {
+		c.JSON(400, gin.H{"error": "SAMLResponse and RelayState are required"})
+		return
+	}
+	// The RelayState must come back to the browser that started the login
+	if subtle.ConstantTimeCompare([]byte(relayState), []byte(cookieState)) != 1 {
+		c.JSON(400, gin.H{"error": services.ErrInvalidSAMLState.Error()})
+		return
+	}
+
+	samlService := services.NewSAMLService()
+	usr, err := samlService.ACS(samlResponse, relayState)
+	switch {
+	case errors.Is(err, services.ErrSAMLNotConfigured):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrInvalidSAMLState):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrSAMLLoginFailed):
+		c.JSON(401, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrSAMLEmailMissing):
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrSAMLAccountNotVerified):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	methods := []string{utils.AMRFederated}
+	if requireMFAChallenge(c, usr.ID, methods) {
+		return
+	}
+	respondWithTokens(c, usr, methods)
+}
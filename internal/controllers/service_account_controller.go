@@ -0,0 +1,243 @@
+package controller
+
+import (
+	middleware "Admin-gin/internal/middlewares"
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serviceAccountID parses the :id route parameter, answering 400 when it is invalid
+func serviceAccountID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid service account ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// respondServiceAccountError writes the response for errors of the service
+// account service. It reports whether a response was written.
+func respondServiceAccountError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, services.ErrServiceAccountNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrServiceAccountExists):
+		c.JSON(409, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUnknownRole):
+		c.JSON(400, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRolesNotHeld):
+		c.JSON(403, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+	}
+	return true
+}
+
+// checkServiceAccountRoles loads the caller's grants for the service and asks
+// for a recent re-authentication when the roles grant *. It reports whether
+// the request may go on.
+func checkServiceAccountRoles(c *gin.Context, roleIDs []uint) ([]utils.Grant, bool) {
+	grants, ok := middleware.CurrentGrants(c)
+	if !ok {
+		c.JSON(403, gin.H{"error": "forbidden: insufficient permissions"})
+		return nil, false
+	}
+	roleGrants, err := services.NewRoleService().GetEffectiveGrants(roleIDs)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return nil, false
+	}
+	if !checkWildcardReauth(c, roleGrants) {
+		return nil, false
+	}
+	return grants, true
+}
+
+// CreateServiceAccount godoc
+// @Summary Create a service account
+// @Description Create a non-human principal for CI and sync jobs. The API key and client secret are only returned once. The roles cannot grant permissions the caller does not hold everywhere, and granting * requires a recent re-authentication.
+// @Tags Service Accounts
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param account body services.ServiceAccountInput true "Name, description and role IDs"
+// @Success 201 {object} map[string]interface{} "service account and credentials"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 403 {object} map[string]interface{} "error"
+// @Failure 409 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /service-accounts [post]
+func CreateServiceAccount(c *gin.Context) {
+	var req services.ServiceAccountInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	grants, ok := checkServiceAccountRoles(c, req.RoleIDs)
+	if !ok {
+		return
+	}
+	account, credentials, err := services.NewServiceAccountService().CreateAccount(&req, grants)
+	if respondServiceAccountError(c, err) {
+		return
+	}
+	middleware.SetAuditTarget(c, strconv.FormatUint(uint64(account.ID), 10))
+	c.JSON(201, gin.H{"service_account": account, "credentials": credentials})
+}
+
+// GetServiceAccounts godoc
+// @Summary List service accounts
+// @Tags Service Accounts
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} services.ServiceAccountResponse
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /service-accounts [get]
+func GetServiceAccounts(c *gin.Context) {
+	accounts, err := services.NewServiceAccountService().GetAccounts()
+	if respondServiceAccountError(c, err) {
+		return
+	}
+	c.JSON(200, accounts)
+}
+
+// GetServiceAccount godoc
+// @Summary Get a service account
+// @Tags Service Accounts
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Service account ID"
+// @Success 200 {object} services.ServiceAccountResponse
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /service-accounts/{id} [get]
+func GetServiceAccount(c *gin.Context) {
+	id, ok := serviceAccountID(c)
+	if !ok {
+		return
+	}
+	account, err := services.NewServiceAccountService().GetAccount(id)
+	if respondServiceAccountError(c, err) {
+		return
+	}
+	c.JSON(200, account)
+}
+
+// DisableServiceAccount godoc
+// @Summary Disable a service account
+// @Description Reject the API key and tokens of the service account until it is enabled again
+// @Tags Service Accounts
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Service account ID"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /service-accounts/{id}/disable [post]
+func DisableServiceAccount(c *gin.Context) {
+	setServiceAccountDisabled(c, true)
+}
+
+// EnableServiceAccount godoc
+// @Summary Enable a service account
+// @Tags Service Accounts
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Service account ID"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /service-accounts/{id}/enable [post]
+func EnableServiceAccount(c *gin.Context) {
+	setServiceAccountDisabled(c, false)
+}
+
+func setServiceAccountDisabled(c *gin.Context, disabled bool) {
+	id, ok := serviceAccountID(c)
+	if !ok {
+		return
+	}
+	err := services.NewServiceAccountService().SetDisabled(id, disabled)
+	if respondServiceAccountError(c, err) {
+		return
+	}
+	if disabled {
+		c.JSON(200, gin.H{"message": "Service account disabled successfully"})
+	} else {
+		c.JSON(200, gin.H{"message": "Service account enabled successfully"})
+	}
+}
+
+// SetServiceAccountRoles godoc
+// @Summary Set the roles of a service account
+// @Description Replace the roles of the service account. The roles cannot grant permissions the caller does not hold everywhere, and granting * requires a recent re-authentication.
+// @Tags Service Accounts
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Service account ID"
+// @Param roles body services.ServiceAccountRolesInput true "Role IDs"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 403 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /service-accounts/{id}/roles [put]
+func SetServiceAccountRoles(c *gin.Context) {
+	id, ok := serviceAccountID(c)
+	if !ok {
+		return
+	}
+	var req services.ServiceAccountRolesInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	grants, ok := checkServiceAccountRoles(c, req.RoleIDs)
+	if !ok {
+		return
+	}
+	err := services.NewServiceAccountService().SetRoles(id, req.RoleIDs, grants)
+	if respondServiceAccountError(c, err) {
+		return
+	}
+	c.JSON(200, gin.H{"message": "Roles updated successfully"})
+}
+
+// RotateServiceAccountCredentials godoc
+// @Summary Rotate the credentials of a service account
+// @Description Issue a new API key and client secret. The previous key and secret stop working immediately.
+// @Tags Service Accounts
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Service account ID"
+// @Success 200 {object} services.ServiceAccountCredentials
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /service-accounts/{id}/rotate-credentials [post]
+func RotateServiceAccountCredentials(c *gin.Context) {
+	id, ok := serviceAccountID(c)
+	if !ok {
+		return
+	}
+	credentials, err := services.NewServiceAccountService().RotateCredentials(id)
+	if respondServiceAccountError(c, err) {
+		return
+	}
+	c.JSON(200, credentials)
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	middleware "Admin-gin/internal/middlewares"
+	"Admin-gin/internal/services"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMySessions godoc
+// @Summary List my sessions
+// @Description List the active sessions of the authenticated user
+// @Tags Sessions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "sessions"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/sessions [get]
+func GetMySessions(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return
+	}
+	listSessions(c, userID)
+}
+
+// DeleteMySession godoc
+// @Summary Terminate one of my sessions
+// @Description Terminate a session of the authenticated user
+// @Tags Sessions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Session ID"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 401 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /me/sessions/{id} [delete]
+func DeleteMySession(revocations services.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+		revokeSession(c, revocations, userID, c.Param("id"))
+	}
+}
+
+// GetUserSessions godoc
+// @Summary List sessions of a user
+// @Description List the active sessions of a specific user
+// @Tags Sessions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} map[string]interface{} "sessions"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /users/{id}/sessions [get]
+func GetUserSessions(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	listSessions(c, uint(id))
+}
+
+// DeleteUserSession godoc
+// @Summary Terminate a session of a user
+// @Description Terminate a specific session of a specific user
+// @Tags Sessions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param sessionId path string true "Session ID"
+// @Success 200 {object} map[string]interface{} "message"
+// @Failure 400 {object} map[string]interface{} "error"
+// @Failure 404 {object} map[string]interface{} "error"
+// @Failure 500 {object} map[string]interface{} "error"
+// @Router /users/{id}/sessions/{sessionId} [delete]
+func DeleteUserSession(revocations services.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		revokeSession(c, revocations, uint(id), c.Param("sessionId"))
+	}
+}
+
+func listSessions(c *gin.Context, userID uint) {
+	sessionService := services.NewSessionService()
+	sessions, err := sessionService.GetUserSessions(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	resp := gin.H{"sessions": sessions}
+	if principal, ok := middleware.CurrentPrincipal(c); ok {
+		resp["current_session_id"] = principal.SessionID
+	}
+	c.JSON(200, resp)
+}
+
+func revokeSession(c *gin.Context, revocations services.RevocationStore, userID uint, sessionID string) {
+	sessionService := services.NewSessionService()
+	session, err := sessionService.RevokeSession(userID, sessionID)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if err := revocations.RevokeSession(session.ID); err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Session terminated successfully"})
+}
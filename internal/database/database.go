@@ -47,10 +47,36 @@ func New() Service {
 	db, err := gorm.Open(postgres.Open(connStr), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
-	db.AutoMigrate(&models.User{})
 	if err != nil {
 		log.Fatal("failed to connect to database: ", err)
 	}
+	db.AutoMigrate(
+		&models.User{},
+		&models.Role{},
+		&models.RoleHasPermission{},
+		&models.RoleHasParent{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.UserRevocation{},
+		&models.UserMFA{},
+		&models.RecoveryCode{},
+		&models.ActionToken{},
+		&models.LoginAttempt{},
+		&models.PasswordHistory{},
+		&models.ExternalIdentity{},
+		&models.OIDCLoginState{},
+		&models.SAMLLoginState{},
+		&models.Passkey{},
+		&models.WebAuthnSession{},
+		&models.OAuthClient{},
+		&models.OAuthAuthorizationCode{},
+		&models.OAuthConsent{},
+		&models.PersonalAccessToken{},
+		&models.ServiceAccount{},
+		&models.ServiceAccountKey{},
+		&models.AuditLog{},
+	)
 
 	dbInstance = &service{db: db}
 	return dbInstance
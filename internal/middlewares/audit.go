@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/services"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+const auditTargetKey = "audit_target"
+
+// Audit records the action in the audit log once the handler succeeded. The
+// target is the :id route parameter unless the handler sets one with
+// SetAuditTarget, e.g. for a newly created resource.
+func Audit(store services.AuditStore, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		status := c.Writer.Status()
+		if status >= 400 {
+			return
+		}
+		principal, exists := CurrentPrincipal(c)
+		if !exists {
+			return
+		}
+
+		target := c.Param("id")
+		if value, ok := c.Get(auditTargetKey); ok {
+			target, _ = value.(string)
+		}
+		actorType, actorID := principal.Actor()
+		entry := &models.AuditLog{
+			ActorType: actorType,
+			ActorID:   actorID,
+			Action:    action,
+			TargetID:  target,
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			Status:    status,
+			IPAddress: c.ClientIP(),
+		}
+		if principal.IsImpersonating() {
+			impersonated := principal.UserID
+			entry.ImpersonatedUserID = &impersonated
+		}
+		err := store.Record(entry)
+		if err != nil {
+			// The change already happened, so only report the failure
+			log.Printf("failed to record audit log entry %s: %v", action, err)
+		}
+	}
+}
+
+// SetAuditTarget sets the ID of the resource recorded by Audit
+func SetAuditTarget(c *gin.Context, targetID string) {
+	c.Set(auditTargetKey, targetID)
+}
@@ -1,52 +1,317 @@
 package middleware
 
 import (
+	"Admin-gin/internal/services"
 	"Admin-gin/internal/utils"
+	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware authenticates the caller with an access token in the
+// Authorization header, a personal access token or a service account
+// credential. API keys and personal access tokens can be sent as a bearer
+// token or in the X-API-Key header; service accounts may also use an access
+// token from the client credentials grant. Credentials whose service is nil
+// are rejected.
+func AuthMiddleware(revocations services.RevocationStore, tokens services.PersonalAccessTokenService, serviceAccounts services.ServiceAccountService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(401, gin.H{"error": "Authorization header missing"})
+		credential := c.GetHeader("X-API-Key")
+		if credential == "" {
+			authHeader := c.GetHeader("Authorization")
+			if authHeader == "" {
+				c.JSON(401, gin.H{"error": "Authorization header missing"})
+				c.Abort()
+				return
+			}
+
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+				c.JSON(401, gin.H{"error": "unauthorized"})
+				c.Abort()
+				return
+			}
+			credential = parts[1]
+		} else if !services.IsPersonalAccessToken(credential) && !services.IsServiceAccountKey(credential) {
+			c.JSON(401, gin.H{"error": "unauthorized"})
 			c.Abort()
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			c.JSON(401, gin.H{"error": "unauthorized"})
-			c.Abort()
+		if services.IsPersonalAccessToken(credential) {
+			authenticatePersonalAccessToken(c, revocations, tokens, credential)
+			return
+		}
+		if services.IsServiceAccountKey(credential) {
+			authenticateServiceAccountKey(c, serviceAccounts, credential)
 			return
 		}
 
-		tokenString := parts[1]
+		claims, err := utils.ParseAccessToken(credential)
+		if err != nil {
+			authenticateServiceAccountToken(c, revocations, serviceAccounts, credential)
+			return
+		}
+		userID, _ := claims.UserID()
+		actorID, _ := claims.ActorID()
 
-		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-			return utils.SecretKey, nil
+		revoked, err := revocations.IsRevoked(claims.ID, userID, claims.IssuedAt.Time)
+		if err == nil && !revoked && actorID != 0 {
+			// Revoking the impersonator's sessions also ends their impersonation
+			revoked, err = revocations.IsRevoked(claims.ID, actorID, claims.IssuedAt.Time)
+		}
+		if err == nil && !revoked && claims.SessionID != "" {
+			// Ending a session invalidates every access token issued for it
+			revoked, err = revocations.IsSessionRevoked(claims.SessionID)
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "failed to check token revocation"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(401, gin.H{"error": "token has been revoked"})
+			c.Abort()
+			return
+		}
+		// The roles snapshot feeds permission checks and conditions, so a
+		// token issued before the user's roles changed has to be refreshed
+		stale, err := revocations.IsStale(userID, claims.AuthzVersion)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "failed to check token revocation"})
+			c.Abort()
+			return
+		}
+		if stale {
+			c.JSON(401, gin.H{"error": "token is outdated, refresh it"})
+			c.Abort()
+			return
+		}
+
+		setPrincipal(c, &Principal{
+			UserID:    userID,
+			ActorID:   actorID,
+			Roles:     claims.Roles,
+			SessionID: claims.SessionID,
+			TokenID:   claims.ID,
+			ExpiresAt: claims.ExpiresAt.Time,
+			Claims:    claims,
 		})
 
-		if err != nil || !token.Valid {
+		c.Next()
+	}
+}
+
+// ForbidImpersonation blocks sensitive actions, such as changing a password,
+// while an administrator is impersonating the user
+func ForbidImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, exists := CurrentPrincipal(c)
+		if !exists {
 			c.JSON(401, gin.H{"error": "unauthorized"})
 			c.Abort()
 			return
 		}
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(401, gin.H{"error": "invalid claims"})
+		if principal.IsImpersonating() {
+			c.JSON(403, gin.H{"error": "forbidden: not allowed while impersonating"})
 			c.Abort()
 			return
 		}
-		userData := claims["user"].(map[string]any)
+		c.Next()
+	}
+}
+
+func authenticatePersonalAccessToken(c *gin.Context, revocations services.RevocationStore, tokens services.PersonalAccessTokenService, credential string) {
+	if tokens == nil {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		c.Abort()
+		return
+	}
+
+	token, err := tokens.Authenticate(credential, c.ClientIP())
+	if errors.Is(err, services.ErrInvalidPersonalAccessToken) {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		c.Abort()
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "failed to check personal access token"})
+		c.Abort()
+		return
+	}
+	// Revoking the user's sessions also revokes tokens created before that
+	revoked, err := revocations.IsRevoked("", token.UserID, token.CreatedAt)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to check token revocation"})
+		c.Abort()
+		return
+	}
+	if revoked {
+		c.JSON(401, gin.H{"error": "token has been revoked"})
+		c.Abort()
+		return
+	}
+
+	roles := make([]string, len(token.User.Roles))
+	for i, role := range token.User.Roles {
+		roles[i] = role.Name
+	}
+	setPrincipal(c, &Principal{
+		UserID:                token.UserID,
+		Roles:                 roles,
+		ExpiresAt:             token.ExpiresAt,
+		PersonalAccessTokenID: token.ID,
+		Scopes:                append([]string{}, token.ScopeList()...),
+	})
+
+	c.Next()
+}
 
-		c.Set("userID", userData["id"])
-		c.Set("name", userData["name"])
-		c.Set("email", userData["email"])
+func authenticateServiceAccountKey(c *gin.Context, serviceAccounts services.ServiceAccountService, credential string) {
+	if serviceAccounts == nil {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		c.Abort()
+		return
+	}
+
+	account, err := serviceAccounts.AuthenticateKey(credential, c.ClientIP())
+	if errors.Is(err, services.ErrInvalidServiceAccountKey) {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		c.Abort()
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "failed to check service account key"})
+		c.Abort()
+		return
+	}
 
+	roles := make([]string, len(account.Roles))
+	for i, role := range account.Roles {
+		roles[i] = role.Name
+	}
+	setPrincipal(c, &Principal{
+		ServiceAccountID: account.ID,
+		Roles:            roles,
+	})
+
+	c.Next()
+}
+
+// authenticateServiceAccountToken accepts access tokens a service account
+// obtained with the client credentials grant. Tokens of other OAuth clients
+// are never valid at this API.
+func authenticateServiceAccountToken(c *gin.Context, revocations services.RevocationStore, serviceAccounts services.ServiceAccountService, credential string) {
+	claims, err := utils.ParseOAuthAccessToken(credential)
+	if err != nil || serviceAccounts == nil {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		c.Abort()
+		return
+	}
+	accountID, ok := claims.ServiceAccountID()
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		c.Abort()
+		return
+	}
+
+	revoked, err := revocations.IsRevoked(claims.ID, 0, claims.IssuedAt.Time)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to check token revocation"})
+		c.Abort()
+		return
+	}
+	if revoked {
+		c.JSON(401, gin.H{"error": "token has been revoked"})
+		c.Abort()
+		return
+	}
+
+	account, err := serviceAccounts.GetActiveAccount(accountID)
+	if errors.Is(err, services.ErrServiceAccountNotFound) {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		c.Abort()
+		return
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "failed to check service account"})
+		c.Abort()
+		return
+	}
+
+	roles := make([]string, len(account.Roles))
+	for i, role := range account.Roles {
+		roles[i] = role.Name
+	}
+	setPrincipal(c, &Principal{
+		ServiceAccountID: account.ID,
+		Roles:            roles,
+		TokenID:          claims.ID,
+		ExpiresAt:        claims.ExpiresAt.Time,
+		Scopes:           append([]string{}, claims.Scopes()...),
+	})
+
+	c.Next()
+}
+
+// RequireSession rejects callers that did not sign in interactively, such as
+// personal access tokens and service accounts, so they cannot manage the
+// account that issued them
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, exists := CurrentPrincipal(c)
+		if !exists {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			c.Abort()
+			return
+		}
+		if principal.Claims == nil {
+			c.JSON(403, gin.H{"error": "forbidden: this endpoint requires an interactive login"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireRecentAuth guards sensitive operations: the caller must have signed
+// in or re-authenticated with POST /api/reauth within REAUTH_WINDOW
+func RequireRecentAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !CheckRecentAuth(c) {
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
+
+// CheckRecentAuth reports whether the caller re-authenticated recently enough
+// for a sensitive operation. Otherwise it responds 401 with the
+// insufficient_user_authentication error of RFC 9470 and max_age, telling the
+// client to prompt for the password or a second factor, call POST /api/reauth
+// and retry with the new token.
+func CheckRecentAuth(c *gin.Context) bool {
+	principal, exists := CurrentPrincipal(c)
+	if !exists {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+		return false
+	}
+	if principal.Claims == nil {
+		c.JSON(403, gin.H{"error": "forbidden: this endpoint requires an interactive login"})
+		return false
+	}
+
+	window := utils.ReauthWindow()
+	if principal.Claims.AuthenticatedWithin(window) {
+		return true
+	}
+	maxAge := int(window.Seconds())
+	c.Header("WWW-Authenticate", fmt.Sprintf(
+		`Bearer error="insufficient_user_authentication", error_description="re-authentication required", max_age=%d`, maxAge))
+	c.JSON(401, gin.H{
+		"error":             "insufficient_user_authentication",
+		"error_description": "Re-authenticate with POST /api/reauth and retry with the new token",
+		"max_age":           maxAge,
+	})
+	return false
+}
@@ -0,0 +1,471 @@
+package middleware
+
+import (
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newAuthRouter(revocations services.RevocationStore) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", AuthMiddleware(revocations, nil, nil), func(c *gin.Context) {
+		principal, _ := CurrentPrincipal(c)
+		c.JSON(http.StatusOK, gin.H{"user_id": principal.UserID})
+	})
+	return r
+}
+
+func doAuthRequest(r *gin.Engine, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAuthMiddlewareRejectsRevokedUser(t *testing.T) {
+	revocations := services.NewMemoryRevocationStore()
+	r := newAuthRouter(revocations)
+
+	token, _, err := utils.CreateToken(&models.User{ID: 7, Name: "test", Email: "test@example.com"}, "session", utils.Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := doAuthRequest(r, token); rr.Code != http.StatusOK {
+		t.Fatalf("expected valid token to be accepted, got %d", rr.Code)
+	}
+
+	if err := revocations.RevokeUser(7); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := doAuthRequest(r, token); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked token to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestAuthMiddlewareRejectsTokensOfRevokedSession(t *testing.T) {
+	revocations := services.NewMemoryRevocationStore()
+	r := newAuthRouter(revocations)
+
+	user := &models.User{ID: 7, Name: "test", Email: "test@example.com"}
+	first, _, err := utils.CreateToken(user, "session-1", utils.Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, _, err := utils.CreateToken(user, "session-1", utils.Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := utils.CreateToken(user, "session-2", utils.Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := revocations.RevokeSession("session-1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{first, latest} {
+		if rr := doAuthRequest(r, token); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected every token of the revoked session to be rejected, got %d", rr.Code)
+		}
+	}
+	if rr := doAuthRequest(r, other); rr.Code != http.StatusOK {
+		t.Fatalf("expected tokens of other sessions to be accepted, got %d", rr.Code)
+	}
+}
+
+// versionedRevocations reports tokens of user 7 as stale unless they carry
+// the current authz version
+type versionedRevocations struct {
+	services.RevocationStore
+	version int64
+}
+
+func (s *versionedRevocations) IsStale(userID uint, authzVersion int64) (bool, error) {
+	return userID == 7 && authzVersion != s.version, nil
+}
+
+func TestAuthMiddlewareRejectsStaleRoles(t *testing.T) {
+	revocations := &versionedRevocations{RevocationStore: services.NewMemoryRevocationStore(), version: 1}
+	r := newAuthRouter(revocations)
+
+	token, _, err := utils.CreateToken(&models.User{ID: 7, Roles: []models.Role{{Name: "admin"}}, AuthzVersion: 1}, "session", utils.Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := doAuthRequest(r, token); rr.Code != http.StatusOK {
+		t.Fatalf("expected a current token to be accepted, got %d", rr.Code)
+	}
+
+	revocations.version = 2
+	if rr := doAuthRequest(r, token); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a token issued before a role change to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestAuthMiddlewareRejectsMissingHeader(t *testing.T) {
+	r := newAuthRouter(services.NewMemoryRevocationStore())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestAuthMiddlewareRejectsMFAChallenge(t *testing.T) {
+	r := newAuthRouter(services.NewMemoryRevocationStore())
+
+	challenge, err := utils.CreateMFAChallengeToken(7, []string{utils.AMRPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := doAuthRequest(r, challenge); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected MFA challenge to be rejected as access token, got %d", rr.Code)
+	}
+}
+
+type fakePersonalAccessTokens struct {
+	services.PersonalAccessTokenService
+	token *models.PersonalAccessToken
+	plain string
+}
+
+func (f *fakePersonalAccessTokens) Authenticate(token, ipAddress string) (*models.PersonalAccessToken, error) {
+	if token != f.plain {
+		return nil, services.ErrInvalidPersonalAccessToken
+	}
+	f.token.LastUsedIP = ipAddress
+	return f.token, nil
+}
+
+func TestAuthMiddlewareAcceptsPersonalAccessToken(t *testing.T) {
+	tokens := &fakePersonalAccessTokens{
+		token: &models.PersonalAccessToken{ID: 3, UserID: 7, Scopes: "user.read"},
+		plain: services.PersonalAccessTokenPrefix + "secret",
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	var principal *Principal
+	r.GET("/", AuthMiddleware(services.NewMemoryRevocationStore(), tokens, nil), func(c *gin.Context) {
+		principal, _ = CurrentPrincipal(c)
+	})
+	r.GET("/session", AuthMiddleware(services.NewMemoryRevocationStore(), tokens, nil), RequireSession(), func(c *gin.Context) {})
+
+	for _, header := range []string{"Authorization", "X-API-Key"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header == "Authorization" {
+			req.Header.Set(header, "Bearer "+tokens.plain)
+		} else {
+			req.Header.Set(header, tokens.plain)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected token in %s to be accepted, got %d", header, rr.Code)
+		}
+		if principal.UserID != 7 || principal.PersonalAccessTokenID != 3 {
+			t.Fatalf("unexpected principal %+v", principal)
+		}
+		if !principal.HasScope("user.read") || principal.HasScope("user.delete") {
+			t.Fatalf("expected principal to be limited to the token scopes, got %v", principal.Scopes)
+		}
+	}
+
+	if rr := doAuthRequest(r, services.PersonalAccessTokenPrefix+"wrong"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unknown token to be rejected, got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/session", nil)
+	req.Header.Set("X-API-Key", tokens.plain)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected personal access token to be refused on session-only routes, got %d", rr.Code)
+	}
+}
+
+func TestAuthMiddlewareRejectsPersonalAccessTokenOfRevokedUser(t *testing.T) {
+	tokens := &fakePersonalAccessTokens{
+		token: &models.PersonalAccessToken{ID: 3, UserID: 7, Scopes: "user.read", CreatedAt: time.Now().Add(-time.Hour)},
+		plain: services.PersonalAccessTokenPrefix + "secret",
+	}
+	revocations := services.NewMemoryRevocationStore()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", AuthMiddleware(revocations, tokens, nil), func(c *gin.Context) {})
+
+	if rr := doAuthRequest(r, tokens.plain); rr.Code != http.StatusOK {
+		t.Fatalf("expected the token to be accepted, got %d", rr.Code)
+	}
+	if err := revocations.RevokeUser(7); err != nil {
+		t.Fatal(err)
+	}
+	if rr := doAuthRequest(r, tokens.plain); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoking the user's sessions to reject the token, got %d", rr.Code)
+	}
+}
+
+func TestAuthMiddlewareRejectsJWTInAPIKeyHeader(t *testing.T) {
+	r := newAuthRouter(services.NewMemoryRevocationStore())
+
+	token, _, err := utils.CreateToken(&models.User{ID: 7, Name: "test", Email: "test@example.com"}, "session", utils.Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-API-Key", token)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestPrincipalWithoutScopesIsUnrestricted(t *testing.T) {
+	if !(&Principal{UserID: 7}).HasScope("user.delete") {
+		t.Fatal("expected principal without scopes to be unrestricted")
+	}
+	if (&Principal{UserID: 7, Scopes: []string{}}).HasScope("user.read") {
+		t.Fatal("expected empty scopes to allow nothing")
+	}
+	scoped := &Principal{UserID: 7, Scopes: []string{"user.*"}}
+	if !scoped.HasScope("user.delete") || scoped.HasScope("role.read") {
+		t.Fatal("expected a user.* scope to allow user permissions only")
+	}
+}
+
+type fakeServiceAccounts struct {
+	services.ServiceAccountService
+	account *models.ServiceAccount
+	key     string
+}
+
+func (f *fakeServiceAccounts) AuthenticateKey(key, ipAddress string) (*models.ServiceAccount, error) {
+	if key != f.key {
+		return nil, services.ErrInvalidServiceAccountKey
+	}
+	return f.account, nil
+}
+
+func (f *fakeServiceAccounts) GetActiveAccount(id uint) (*models.ServiceAccount, error) {
+	if id != f.account.ID || f.account.Status != models.ServiceAccountActive {
+		return nil, services.ErrServiceAccountNotFound
+	}
+	return f.account, nil
+}
+
+func TestAuthMiddlewareAcceptsServiceAccountCredentials(t *testing.T) {
+	accounts := &fakeServiceAccounts{
+		account: &models.ServiceAccount{ID: 4, Name: "ci", Status: models.ServiceAccountActive, Roles: []models.Role{{Name: "deployer"}}},
+		key:     services.ServiceAccountKeyPrefix + "secret",
+	}
+	revocations := services.NewMemoryRevocationStore()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	var principal *Principal
+	r.GET("/", AuthMiddleware(revocations, nil, accounts), func(c *gin.Context) {
+		principal, _ = CurrentPrincipal(c)
+	})
+
+	if rr := doAuthRequest(r, accounts.key); rr.Code != http.StatusOK {
+		t.Fatalf("expected API key to be accepted, got %d", rr.Code)
+	}
+	if principal.ServiceAccountID != 4 || principal.UserID != 0 || principal.Scopes != nil {
+		t.Fatalf("unexpected principal %+v", principal)
+	}
+	if actorType, actorID := principal.Actor(); actorType != models.ActorServiceAccount || actorID != 4 {
+		t.Fatalf("unexpected actor %s %d", actorType, actorID)
+	}
+
+	token, _, err := utils.CreateOAuthAccessToken(utils.ServiceAccountSubject(4), "client-1", []string{"user.read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := doAuthRequest(r, token); rr.Code != http.StatusOK {
+		t.Fatalf("expected client credentials token to be accepted, got %d", rr.Code)
+	}
+	if principal.ServiceAccountID != 4 || !principal.HasScope("user.read") || principal.HasScope("user.delete") {
+		t.Fatalf("unexpected principal %+v", principal)
+	}
+
+	accounts.account.Status = models.ServiceAccountDisabled
+	if rr := doAuthRequest(r, token); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected token of a disabled account to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestAuthMiddlewareRejectsOtherOAuthClientTokens(t *testing.T) {
+	accounts := &fakeServiceAccounts{account: &models.ServiceAccount{ID: 4, Status: models.ServiceAccountActive}}
+	r := gin.New()
+	r.GET("/", AuthMiddleware(services.NewMemoryRevocationStore(), nil, accounts), func(c *gin.Context) {})
+
+	for _, subject := range []string{"client-1", "42"} {
+		token, _, err := utils.CreateOAuthAccessToken(subject, "client-1", []string{"user.read"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rr := doAuthRequest(r, token); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected OAuth token for %s to be rejected, got %d", subject, rr.Code)
+		}
+	}
+}
+
+func TestAuditRecordsActorType(t *testing.T) {
+	store := services.NewMemoryAuditStore()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	withPrincipal := func(principal *Principal) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			setPrincipal(c, principal)
+			c.Next()
+		}
+	}
+	r.DELETE("/users/:id", withPrincipal(&Principal{UserID: 7}), Audit(store, "user.delete"), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{})
+	})
+	r.POST("/service-accounts", withPrincipal(&Principal{ServiceAccountID: 4}), Audit(store, "service_account.create"), func(c *gin.Context) {
+		SetAuditTarget(c, "12")
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+	r.PUT("/users/:id", withPrincipal(&Principal{UserID: 7}), Audit(store, "user.update"), func(c *gin.Context) {
+		c.JSON(http.StatusBadRequest, gin.H{})
+	})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodDelete, "/users/3", nil),
+		httptest.NewRequest(http.MethodPost, "/service-accounts", nil),
+		httptest.NewRequest(http.MethodPut, "/users/3", nil),
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	entries, err := store.List(services.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected failed requests not to be audited, got %d entries", len(entries))
+	}
+	if e := entries[0]; e.ActorType != models.ActorServiceAccount || e.ActorID != 4 || e.TargetID != "12" {
+		t.Fatalf("unexpected service account entry %+v", e)
+	}
+	if e := entries[1]; e.ActorType != models.ActorUser || e.ActorID != 7 || e.TargetID != "3" || e.Action != "user.delete" {
+		t.Fatalf("unexpected user entry %+v", e)
+	}
+
+	humans, err := store.List(services.AuditFilter{ActorType: models.ActorUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(humans) != 1 {
+		t.Fatalf("expected one human entry, got %d", len(humans))
+	}
+}
+
+func TestAuthMiddlewareExposesImpersonator(t *testing.T) {
+	revocations := services.NewMemoryRevocationStore()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	var principal *Principal
+	r.GET("/", AuthMiddleware(revocations, nil, nil), func(c *gin.Context) {
+		principal, _ = CurrentPrincipal(c)
+	})
+	r.GET("/sensitive", AuthMiddleware(revocations, nil, nil), ForbidImpersonation(), func(c *gin.Context) {})
+
+	token, _, err := utils.CreateImpersonationToken(&models.User{ID: 42}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := doAuthRequest(r, token); rr.Code != http.StatusOK {
+		t.Fatalf("expected impersonation token to be accepted, got %d", rr.Code)
+	}
+	if principal.UserID != 42 || principal.ActorID != 7 || !principal.IsImpersonating() {
+		t.Fatalf("unexpected principal %+v", principal)
+	}
+	if actorType, actorID := principal.Actor(); actorType != models.ActorUser || actorID != 7 {
+		t.Fatalf("expected the impersonator to be the audited actor, got %s %d", actorType, actorID)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/sensitive", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected sensitive route to be forbidden while impersonating, got %d", rr.Code)
+	}
+
+	if err := revocations.RevokeUser(7); err != nil {
+		t.Fatal(err)
+	}
+	if rr := doAuthRequest(r, token); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoking the impersonator to end the impersonation, got %d", rr.Code)
+	}
+}
+
+func TestRequireRecentAuth(t *testing.T) {
+	t.Setenv("REAUTH_WINDOW", "5m")
+	revocations := services.NewMemoryRevocationStore()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", AuthMiddleware(revocations, nil, nil), RequireRecentAuth(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	user := &models.User{ID: 7, Name: "test", Email: "test@example.com"}
+	stale, _, err := utils.CreateToken(user, "session", utils.Authentication{
+		Time:    time.Now().Add(-10 * time.Minute),
+		Methods: []string{utils.AMRPassword},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := doAuthRequest(r, stale)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a stale login to be rejected, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Header().Get("WWW-Authenticate"), `error="insufficient_user_authentication"`) ||
+		!strings.Contains(rr.Body.String(), `"max_age":300`) {
+		t.Fatalf("expected an insufficient_user_authentication challenge, got %q %s", rr.Header().Get("WWW-Authenticate"), rr.Body.String())
+	}
+
+	unknown, _, err := utils.CreateToken(user, "session", utils.Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := doAuthRequest(r, unknown); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a token without auth_time to be rejected, got %d", rr.Code)
+	}
+
+	fresh, _, err := utils.CreateToken(user, "session", utils.Authentication{
+		Time:    time.Now().Add(-time.Minute),
+		Methods: []string{utils.AMRPassword},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := doAuthRequest(r, fresh); rr.Code != http.StatusOK {
+		t.Fatalf("expected a recent login to be accepted, got %d", rr.Code)
+	}
+}
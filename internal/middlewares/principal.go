@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const principalKey = "principal"
+
+// Principal is the authenticated caller, set on the gin.Context by AuthMiddleware.
+// Either UserID or ServiceAccountID is set.
+type Principal struct {
+	UserID           uint
+	ServiceAccountID uint
+	// ActorID is the user impersonating UserID, taken from the act claim
+	ActorID uint
+
+	Roles     []string
+	SessionID string
+	TokenID   string
+	ExpiresAt time.Time
+	Claims    *utils.Claims
+
+	// PersonalAccessTokenID is set when the caller used a personal access token
+	PersonalAccessTokenID uint
+	// Scopes limits the permissions of the caller. Nil means the caller has all
+	// permissions of the user; an empty slice allows nothing.
+	Scopes []string
+}
+
+// HasScope reports whether the caller's scopes allow the permission. The
+// permission must still be held by the user.
+func (p *Principal) HasScope(permission string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	return utils.NewPermissionMatcher(p.Scopes).Allows(permission)
+}
+
+// IsImpersonating reports whether an administrator is acting as UserID
+func (p *Principal) IsImpersonating() bool {
+	return p.ActorID != 0
+}
+
+// Actor returns the actor type and ID recorded in audit log entries. While
+// impersonating, that is the real user rather than the impersonated one.
+func (p *Principal) Actor() (string, uint) {
+	if p.ServiceAccountID != 0 {
+		return models.ActorServiceAccount, p.ServiceAccountID
+	}
+	if p.ActorID != 0 {
+		return models.ActorUser, p.ActorID
+	}
+	return models.ActorUser, p.UserID
+}
+
+// CurrentPrincipal returns the authenticated caller of the request
+func CurrentPrincipal(c *gin.Context) (*Principal, bool) {
+	value, exists := c.Get(principalKey)
+	if !exists {
+		return nil, false
+	}
+	principal, ok := value.(*Principal)
+	return principal, ok
+}
+
+func setPrincipal(c *gin.Context, principal *Principal) {
+	c.Set(principalKey, principal)
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var ErrResourceNotFound = errors.New("resource not found")
+
+// ResourceLoader finds the record targeted by the request for HasPermissionOn.
+// It returns ErrResourceNotFound when there is none.
+type ResourceLoader func(c *gin.Context) (*utils.Resource, error)
+
+// UserResource loads the user named by the :id path parameter, who owns their
+// own record
+func UserResource(db database.Service) ResourceLoader {
+	return func(c *gin.Context) (*utils.Resource, error) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			return nil, ErrResourceNotFound
+		}
+		var user models.User
+		err = db.GetDB().Select("id", "department", "status").First(&user, id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrResourceNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		return &utils.Resource{ID: user.ID, OwnerID: user.ID, Department: user.Department, Status: user.Status}, nil
+	}
+}
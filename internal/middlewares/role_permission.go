@@ -2,45 +2,188 @@ package middleware
 
 import (
 	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/services"
 	"Admin-gin/internal/utils"
+	"errors"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-// HasPermission middleware checks if the user has the required permission
+const (
+	grantKey  = "grant"
+	grantsKey = "grants"
+)
+
+// HasPermission middleware checks if the user has the required permission on
+// every record. While impersonating, the impersonated user's permissions apply
+// and the impersonator must still hold services.ImpersonatePermission.
 func HasPermission(db database.Service, requiredPermission string) gin.HandlerFunc {
+	return HasPermissionOn(db, requiredPermission, nil)
+}
+
+// HasPermissionOn is HasPermission for routes acting on a single record. A
+// global grant of the permission is enough; otherwise load is called to find
+// the record and a grant scoped to the caller's own record, their department
+// or the record's ID must cover it. A nil load only accepts global grants.
+// Grants with a condition only count when it holds for the request. Denies
+// that apply override any allow.
+func HasPermissionOn(db database.Service, requiredPermission string, load ResourceLoader) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get user ID from context (set by AuthMiddleware)
-		userID, exists := c.Get("userID")
+		// Get the caller set by AuthMiddleware
+		principal, exists := CurrentPrincipal(c)
 		if !exists {
 			c.JSON(401, gin.H{"error": "unauthorized"})
 			c.Abort()
 			return
 		}
 
-		// Get user's permissions
-		permissions, err := utils.GetUserPermissions(db.GetDB(), uint(userID.(float64)))
+		// Tokens with scopes only get the intersection of their scopes and the
+		// user's current permissions
+		if !principal.HasScope(requiredPermission) {
+			c.JSON(403, gin.H{"error": "forbidden: insufficient permissions"})
+			c.Abort()
+			return
+		}
+
+		if principal.IsImpersonating() {
+			actorGrants, err := utils.GetUserPermissions(db.GetDB(), principal.ActorID)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "failed to get user permissions"})
+				c.Abort()
+				return
+			}
+			var actorContext *utils.AccessContext
+			if utils.HasScopedGrant(actorGrants, services.ImpersonatePermission) {
+				actor, err := loadUser(db, principal.ActorID)
+				if err != nil {
+					c.JSON(500, gin.H{"error": "failed to get user permissions"})
+					c.Abort()
+					return
+				}
+				actorContext = &utils.AccessContext{Caller: actor, CallerType: models.ActorUser, Request: requestInfo(c)}
+			}
+			if !utils.Evaluate(actorGrants, services.ImpersonatePermission, actorContext).Allowed {
+				c.JSON(403, gin.H{"error": "forbidden: impersonation is no longer allowed"})
+				c.Abort()
+				return
+			}
+		}
+
+		// Get the caller's grants
+		grants, err := principalGrants(db, principal)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "failed to get user permissions"})
 			c.Abort()
 			return
 		}
 
-		// Check if user has the required permission
-		hasPermission := false
-		for _, p := range permissions {
-			if p.Name == requiredPermission {
-				hasPermission = true
-				break
+		// Scoped and conditional allows and denies need the caller and, when
+		// the route has one, the record
+		var ctx *utils.AccessContext
+		if utils.HasScopedGrant(grants, requiredPermission) {
+			ctx, err = accessContext(c, db, principal)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "failed to get user permissions"})
+				c.Abort()
+				return
+			}
+			if load != nil {
+				ctx.Resource, err = load(c)
+				if errors.Is(err, ErrResourceNotFound) {
+					c.JSON(404, gin.H{"error": err.Error()})
+					c.Abort()
+					return
+				} else if err != nil {
+					c.JSON(500, gin.H{"error": "failed to load the resource"})
+					c.Abort()
+					return
+				}
 			}
 		}
 
-		if !hasPermission {
+		decision := utils.Evaluate(grants, requiredPermission, ctx)
+		if !decision.Allowed {
 			c.JSON(403, gin.H{"error": "forbidden: insufficient permissions"})
 			c.Abort()
 			return
 		}
 
+		c.Set(grantKey, decision.Grant)
+		c.Set(grantsKey, grants)
 		c.Next()
 	}
 }
+
+// CurrentGrant returns the grant HasPermission or HasPermissionOn allowed the
+// request with, e.g. to restrict what a scoped grant may change
+func CurrentGrant(c *gin.Context) (*utils.Grant, bool) {
+	value, exists := c.Get(grantKey)
+	if !exists {
+		return nil, false
+	}
+	grant, ok := value.(*utils.Grant)
+	return grant, ok
+}
+
+// CurrentGrants returns all grants of the caller loaded by HasPermission or
+// HasPermissionOn, e.g. to check the caller holds what they hand out
+func CurrentGrants(c *gin.Context) ([]utils.Grant, bool) {
+	value, exists := c.Get(grantsKey)
+	if !exists {
+		return nil, false
+	}
+	grants, ok := value.([]utils.Grant)
+	return grants, ok
+}
+
+// principalGrants returns the grants of the roles of the user or service
+// account behind the principal
+func principalGrants(db database.Service, principal *Principal) ([]utils.Grant, error) {
+	if principal.ServiceAccountID != 0 {
+		return utils.GetServiceAccountPermissions(db.GetDB(), principal.ServiceAccountID)
+	}
+	return utils.GetUserPermissions(db.GetDB(), principal.UserID)
+}
+
+// accessContext describes the caller and the request for scoped grants and
+// conditions. Service accounts own nothing and have no department.
+func accessContext(c *gin.Context, db database.Service, principal *Principal) (*utils.AccessContext, error) {
+	ctx := &utils.AccessContext{Roles: principal.Roles, Request: requestInfo(c)}
+	if principal.ServiceAccountID != 0 {
+		ctx.Caller = &utils.Resource{ID: principal.ServiceAccountID}
+		ctx.CallerType = models.ActorServiceAccount
+		return ctx, nil
+	}
+	caller, err := loadUser(db, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Caller = caller
+	ctx.CallerType = models.ActorUser
+	return ctx, nil
+}
+
+// loadUser describes a user like a resource they own. A deleted user gets an
+// empty description that no scoped grant covers.
+func loadUser(db database.Service, id uint) (*utils.Resource, error) {
+	var user models.User
+	err := db.GetDB().Select("id", "department", "status").First(&user, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &utils.Resource{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &utils.Resource{ID: user.ID, OwnerID: user.ID, Department: user.Department, Status: user.Status}, nil
+}
+
+func requestInfo(c *gin.Context) utils.RequestInfo {
+	return utils.RequestInfo{
+		IP:     c.ClientIP(),
+		Method: c.Request.Method,
+		Path:   c.FullPath(),
+		Time:   time.Now(),
+	}
+}
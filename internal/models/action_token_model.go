@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// Purposes an ActionToken can be issued for. A token is only accepted for its own purpose.
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposePasswordReset     = "password_reset"
+	PurposeMagicLogin        = "magic_login"
+)
+
+// ActionToken is a single-use, expiring token sent by email. Only its hash is stored.
+// BindingHash, when set, is the hash of a nonce held by the browser that asked
+// for the token, which must be presented together with it.
+type ActionToken struct {
+	ID          uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	Purpose     string     `gorm:"size:50;not null;index:idx_action_tokens_user_purpose" json:"purpose"`
+	UserID      uint       `gorm:"not null;index:idx_action_tokens_user_purpose" json:"user_id"`
+	TokenHash   string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	BindingHash string     `gorm:"size:64;not null;default:''" json:"-"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	ConsumedAt  *time.Time `json:"consumed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// Actor types of audit log entries
+const (
+	ActorUser           = "user"
+	ActorServiceAccount = "service_account"
+)
+
+// AuditLog records a change made through the admin API and who made it.
+// ActorType tells whether ActorID is a User or a ServiceAccount. When an
+// administrator acts while impersonating, ActorID is the administrator and
+// ImpersonatedUserID the user they acted as.
+type AuditLog struct {
+	ID                 uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	ActorType          string    `gorm:"size:20;not null;index:idx_audit_logs_actor" json:"actor_type"`
+	ActorID            uint      `gorm:"not null;index:idx_audit_logs_actor" json:"actor_id"`
+	ImpersonatedUserID *uint     `gorm:"index" json:"impersonated_user_id,omitempty"`
+	Action             string    `gorm:"size:100;not null;index" json:"action"`
+	TargetID           string    `gorm:"size:64" json:"target_id"`
+	Method             string    `gorm:"size:10" json:"method"`
+	Path               string    `gorm:"size:255" json:"path"`
+	Status             int       `json:"status"`
+	IPAddress          string    `gorm:"size:64" json:"ip_address"`
+	CreatedAt          time.Time `gorm:"index" json:"created_at"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+)
+
+// ExternalIdentity links a user to an account at an external identity provider,
+// identified by the provider's stable subject identifier
+type ExternalIdentity struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	Provider  string    `gorm:"size:100;not null;uniqueIndex:idx_external_identities_provider_subject" json:"provider"`
+	Subject   string    `gorm:"size:255;not null;uniqueIndex:idx_external_identities_provider_subject" json:"subject"`
+	Email     string    `gorm:"size:100" json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	User      User      `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// OIDCLoginState holds the state, nonce and PKCE verifier of an authorization
+// request between the redirect to the provider and its callback. Only the hash
+// of the state is stored.
+type OIDCLoginState struct {
+	StateHash    string    `gorm:"primaryKey;size:64" json:"-"`
+	Provider     string    `gorm:"size:100;not null" json:"provider"`
+	Nonce        string    `gorm:"size:64;not null" json:"-"`
+	CodeVerifier string    `gorm:"size:128;not null" json:"-"`
+	ExpiresAt    time.Time `gorm:"not null" json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// SAMLLoginState remembers the ID of an AuthnRequest so the assertion answering
+// it is accepted once. StateHash is the hash of the RelayState sent along.
+type SAMLLoginState struct {
+	StateHash string    `gorm:"primaryKey;size:64" json:"-"`
+	RequestID string    `gorm:"size:100;not null" json:"-"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// LoginAttempt counts recent failed logins for a throttling key, such as an
+// account email or a client IP
+type LoginAttempt struct {
+	Key           string    `gorm:"primaryKey;size:255" json:"key"`
+	Failures      int       `gorm:"not null;default:0" json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	BlockedUntil  time.Time `json:"blocked_until"`
+}
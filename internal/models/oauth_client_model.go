@@ -0,0 +1,78 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OAuth client types. Public clients such as SPAs cannot keep a secret and
+// must use PKCE.
+const (
+	OAuthClientConfidential = "confidential"
+	OAuthClientPublic       = "public"
+)
+
+// Grant types a client can be allowed to use
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+)
+
+// OAuthClient is an application allowed to obtain tokens from this service.
+// RedirectURIs, GrantTypes and Scopes are space separated lists; Scopes holds
+// the permission names and OpenID scopes the client may request. Clients of a
+// service account act as that account and get its permissions instead.
+type OAuthClient struct {
+	ID               uint           `gorm:"primaryKey;autoIncrement" json:"id"`
+	ClientID         string         `gorm:"size:64;uniqueIndex;not null" json:"client_id"`
+	SecretHash       string         `gorm:"size:64" json:"-"`
+	Name             string         `gorm:"size:100;not null" json:"name"`
+	Type             string         `gorm:"size:20;not null" json:"type"`
+	RedirectURIs     string         `gorm:"type:text" json:"-"`
+	GrantTypes       string         `gorm:"size:255;not null" json:"-"`
+	Scopes           string         `gorm:"type:text" json:"-"`
+	ServiceAccountID *uint          `gorm:"uniqueIndex" json:"service_account_id,omitempty"`
+	CreatedAt        time.Time      `json:"created_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (c *OAuthClient) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+func (c *OAuthClient) GrantTypeList() []string {
+	return strings.Fields(c.GrantTypes)
+}
+
+func (c *OAuthClient) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+// OAuthAuthorizationCode is a single-use code issued after consent. Only its
+// hash is stored. AccessTokenID is recorded on redemption so the token can be
+// revoked if the code is replayed.
+type OAuthAuthorizationCode struct {
+	CodeHash      string     `gorm:"primaryKey;size:64" json:"-"`
+	ClientID      string     `gorm:"size:64;not null;index" json:"client_id"`
+	UserID        uint       `gorm:"not null" json:"user_id"`
+	RedirectURI   string     `gorm:"type:text;not null" json:"redirect_uri"`
+	Scope         string     `gorm:"type:text" json:"scope"`
+	CodeChallenge string     `gorm:"size:128" json:"-"`
+	Nonce         string     `gorm:"size:255" json:"-"`
+	AuthTime      time.Time  `json:"auth_time"`
+	ExpiresAt     time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt        *time.Time `json:"used_at"`
+	AccessTokenID string     `gorm:"size:36" json:"-"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// OAuthConsent remembers the scopes a user granted to a client
+type OAuthConsent struct {
+	UserID    uint      `gorm:"primaryKey" json:"user_id"`
+	ClientID  string    `gorm:"primaryKey;size:64" json:"client_id"`
+	Scope     string    `gorm:"type:text" json:"scope"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
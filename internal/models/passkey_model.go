@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+// Passkey is a WebAuthn credential registered by a user. Credential holds the
+// JSON encoded public key, flags and signature counter; CredentialID is the
+// base64url encoded credential ID the authenticator sends back.
+type Passkey struct {
+	ID           uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID       uint       `gorm:"not null;index" json:"user_id"`
+	Name         string     `gorm:"size:100;not null" json:"name"`
+	CredentialID string     `gorm:"size:1400;uniqueIndex;not null" json:"-"`
+	Credential   string     `gorm:"type:text;not null" json:"-"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// WebAuthnSession holds the challenge of a registration or login ceremony
+// between its begin and finish requests. Only the hash of the session token
+// handed to the client is stored, and each session is used once.
+type WebAuthnSession struct {
+	TokenHash string    `gorm:"primaryKey;size:64" json:"-"`
+	UserID    uint      `gorm:"not null;default:0" json:"user_id"`
+	Purpose   string    `gorm:"size:20;not null" json:"purpose"`
+	Data      string    `gorm:"type:text;not null" json:"-"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
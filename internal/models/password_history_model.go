@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// PasswordHistory keeps the hashes of a user's previous passwords so they
+// cannot be reused
+type PasswordHistory struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	Hash      string    `gorm:"size:255;not null" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// RevokedToken is a denylisted access token, kept until the token would have expired anyway
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey;size:64" json:"jti"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserRevocation invalidates every token issued to the user before RevokedAt
+type UserRevocation struct {
+	UserID    uint      `gorm:"primaryKey" json:"user_id"`
+	RevokedAt time.Time `gorm:"not null" json:"revoked_at"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// RoleHasParent makes a role inherit the permissions of its parent. The edges
+// form a directed acyclic graph.
+type RoleHasParent struct {
+	RoleID    uint      `gorm:"primaryKey;autoIncrement:false" json:"role_id"`
+	ParentID  uint      `gorm:"primaryKey;autoIncrement:false;index" json:"parent_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
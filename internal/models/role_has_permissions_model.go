@@ -6,10 +6,34 @@ import (
 	"gorm.io/gorm"
 )
 
+// Scopes of a grant, limiting the records the permission applies to
+const (
+	GrantScopeGlobal = "global"
+	// GrantScopeOwn only allows acting on the caller's own record
+	GrantScopeOwn = "own"
+	// GrantScopeGroup allows acting on records of the caller's department
+	GrantScopeGroup = "group"
+	// GrantScopeResource allows acting on the record with ResourceID
+	GrantScopeResource = "resource"
+)
+
+// Effects of a grant. A deny overrides every allow of the same permission,
+// whichever role they come from.
+const (
+	GrantEffectAllow = "allow"
+	GrantEffectDeny  = "deny"
+)
+
 type RoleHasPermission struct {
-	ID           uint `gorm:"primaryKey;autoIncrement" json:"id"`
-	RoleID       uint `gorm:"not null" json:"role_id"`
-	PermissionID uint `gorm:"not null" json:"permission_id"`
+	ID           uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	RoleID       uint   `gorm:"not null" json:"role_id"`
+	PermissionID uint   `gorm:"not null" json:"permission_id"`
+	Effect       string `gorm:"size:10;not null;default:allow" json:"effect"`
+	Scope        string `gorm:"size:20;not null;default:global" json:"scope"`
+	ResourceID   *uint  `json:"resource_id,omitempty"`
+	// Condition is a CEL expression limiting the grant to the requests it
+	// holds for, e.g. resource.status == "in_active"
+	Condition string `gorm:"type:text" json:"condition,omitempty"`
 
 	Role       Role       `gorm:"foreignKey:RoleID" json:"role"`
 	Permission Permission `gorm:"foreignKey:PermissionID" json:"permission"`
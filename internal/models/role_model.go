@@ -7,9 +7,12 @@ import (
 )
 
 type Role struct {
-	ID          uint           `gorm:"primaryKey;autoIncrement" json:"id"`
-	Name        string         `gorm:"size:100;uniqueIndex;not null" json:"name"`
-	Permissions []Permission   `gorm:"many2many:role_has_permissions;" json:"permissions"`
-	CreatedAt   time.Time      `json:"created_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	ID         uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name       string `gorm:"size:100;uniqueIndex;not null" json:"name"`
+	RequireMFA bool   `gorm:"not null;default:false" json:"require_mfa"`
+	// AllowMagicLink lets holders of the role sign in with an emailed link
+	AllowMagicLink bool           `gorm:"not null;default:false" json:"allow_magic_link"`
+	Permissions    []Permission   `gorm:"many2many:role_has_permissions;" json:"permissions"`
+	CreatedAt      time.Time      `json:"created_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
 }
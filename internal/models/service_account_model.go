@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Service account statuses. Disabled accounts keep their credentials but
+// cannot authenticate until enabled again.
+const (
+	ServiceAccountActive   = "active"
+	ServiceAccountDisabled = "disabled"
+)
+
+// ServiceAccount is a non-human principal for CI and sync jobs. It holds roles
+// like a User but has no password or email and only authenticates with an API
+// key or the client credentials grant of its OAuth client.
+type ServiceAccount struct {
+	ID          uint           `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name        string         `gorm:"size:100;uniqueIndex;not null" json:"name"`
+	Description string         `gorm:"size:255" json:"description"`
+	Status      string         `gorm:"size:20;not null;default:active" json:"status"`
+	Roles       []Role         `gorm:"many2many:service_account_has_roles;" json:"roles"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// ServiceAccountKey is an API key of a service account. Only its hash is
+// stored; rotating the credentials revokes the previous keys.
+type ServiceAccountKey struct {
+	ID               uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	ServiceAccountID uint       `gorm:"not null;index" json:"service_account_id"`
+	Prefix           string     `gorm:"size:16;not null" json:"prefix"`
+	KeyHash          string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	LastUsedAt       *time.Time `json:"last_used_at"`
+	LastUsedIP       string     `gorm:"size:64" json:"last_used_ip"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+
+	ServiceAccount ServiceAccount `gorm:"foreignKey:ServiceAccountID" json:"-"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Session is created for every successful login and tracks the device it came from.
+// TokenID is the jti of the most recent access token issued for the session.
+// AuthTime and AuthMethods record the last time the user proved their identity,
+// at login or by re-authenticating, and the space separated amr values used.
+type Session struct {
+	ID          string     `gorm:"primaryKey;size:36" json:"id"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	TokenID     string     `gorm:"size:64;index" json:"token_id"`
+	IPAddress   string     `gorm:"size:64" json:"ip_address"`
+	UserAgent   string     `gorm:"size:512" json:"user_agent"`
+	AuthTime    time.Time  `json:"auth_time"`
+	AuthMethods string     `gorm:"size:100" json:"-"`
+	LastSeenAt  time.Time  `json:"last_seen_at"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (s *Session) AuthMethodList() []string {
+	return strings.Fields(s.AuthMethods)
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// UserMFA holds the TOTP enrollment of a user. The secret is encrypted with
+// APP_SECRET and the enrollment only counts once ConfirmedAt is set.
+type UserMFA struct {
+	UserID       uint       `gorm:"primaryKey" json:"user_id"`
+	Secret       string     `gorm:"size:255;not null" json:"-"`
+	LastUsedStep int64      `gorm:"not null;default:0" json:"-"`
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// RecoveryCode is a one-time code usable in place of a TOTP code
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"size:64;not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -6,13 +6,18 @@ import (
 	"gorm.io/gorm"
 )
 
+// User is an account that can sign in. AuthzVersion is bumped whenever the
+// user's roles or the permissions of those roles change. Department groups
+// users for grants scoped to the caller's group.
 type User struct {
-	ID        uint           `gorm:"primaryKey;autoIncrement" json:"id"`
-	Name      string         `gorm:"size:100;not null" json:"name"`
-	Email     string         `gorm:"size:100;uniqueIndex;not null" json:"email"`
-	Password  string         `gorm:"size:255;not null" json:"password"`
-	Status    string         `gorm:"size:255;default:in_active;not null" json:"status"`
-	Roles     []Role         `gorm:"many2many:user_has_roles;" json:"roles"`
-	CreatedAt time.Time      `json:"created_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID           uint           `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name         string         `gorm:"size:100;not null" json:"name"`
+	Email        string         `gorm:"size:100;uniqueIndex;not null" json:"email"`
+	Password     string         `gorm:"size:255;not null" json:"password"`
+	Status       string         `gorm:"size:255;default:in_active;not null" json:"status"`
+	Department   string         `gorm:"size:100;index" json:"department"`
+	Roles        []Role         `gorm:"many2many:user_has_roles;" json:"roles"`
+	AuthzVersion int64          `gorm:"not null;default:1" json:"-"`
+	CreatedAt    time.Time      `json:"created_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
 }
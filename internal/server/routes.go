@@ -20,22 +20,79 @@ func (s *Server) RegisterRoutes() http.Handler {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowHeaders:     []string{"Accept", "Authorization", "Content-Type"},
+		AllowHeaders:     []string{"Accept", "Authorization", "Content-Type", "X-API-Key", "X-Refresh-Token-Delivery"},
 		AllowCredentials: true,
 	}))
 
 	{
 		api := r.Group("/api")
 		{
-			api.POST("/login", controller.LoginHandler)
+			api.POST("/login", controller.LoginHandler(s.loginThrottle))
+			api.POST("/login/mfa", controller.VerifyMFALogin(s.loginThrottle))
+			api.POST("/login/mfa/enroll", controller.EnrollMFALogin)
+			api.POST("/login/mfa/passkey/begin", controller.BeginPasskeyMFA)
+			api.POST("/login/mfa/passkey/finish", controller.VerifyPasskeyMFA(s.loginThrottle))
+			api.POST("/login/passkey/begin", controller.BeginPasskeyLogin)
+			api.POST("/login/passkey/finish", controller.FinishPasskeyLogin(s.loginThrottle))
+			api.POST("/login/magic", controller.RequestMagicLink(s.loginThrottle, s.magicLinkLimiter))
+			api.GET("/login/magic/verify", controller.MagicLinkLogin(s.loginThrottle))
 			api.POST("/register", controller.RegisterHandler)
 			api.GET("/verify", controller.VerifyEmail)
 			api.POST("/forgot-password", controller.ForgotPassword)
 			api.POST("/reset-password", controller.ResetPassword)
+			api.POST("/token/refresh", controller.RefreshToken)
+			api.GET("/auth/:provider/start", controller.OIDCStart)
+			api.GET("/auth/:provider/callback", controller.OIDCCallback)
+			api.GET("/saml/metadata", controller.SAMLMetadata)
+			api.GET("/saml/login", controller.SAMLStart)
+			api.POST("/saml/acs", controller.SAMLACS)
+			api.POST("/oauth/token", controller.OAuthToken(s.revocations))
+			api.POST("/oauth/introspect", controller.OAuthIntrospect(s.revocations))
+			api.POST("/oauth/revoke", controller.OAuthRevoke(s.revocations))
+			api.GET("/oauth/userinfo", controller.OAuthUserInfo(s.revocations))
+			api.POST("/oauth/userinfo", controller.OAuthUserInfo(s.revocations))
 		}
 		{
 			auth := api.Group("/")
-			auth.Use(middleware.AuthMiddleware())
+			auth.Use(middleware.AuthMiddleware(s.revocations, s.personalAccessTokens, s.serviceAccounts))
+
+			auth.POST("/logout", middleware.RequireSession(), controller.Logout(s.revocations))
+			auth.POST("/reauth",
+				middleware.RequireSession(),
+				middleware.ForbidImpersonation(),
+				middleware.Audit(s.audit, "user.reauthenticate"),
+				controller.Reauthenticate(s.loginThrottle))
+			auth.POST("/reauth/passkey",
+				middleware.RequireSession(),
+				middleware.ForbidImpersonation(),
+				controller.BeginPasskeyReauth)
+			auth.POST("/impersonation/stop",
+				middleware.Audit(s.audit, "user.impersonate.stop"),
+				controller.StopImpersonation(s.revocations))
+			{
+				//Current user
+				meRoute := auth.Group("/me")
+				meRoute.Use(middleware.RequireSession(), middleware.ForbidImpersonation())
+
+				meRoute.GET("/sessions", controller.GetMySessions)
+				meRoute.DELETE("/sessions/:id", controller.DeleteMySession(s.revocations))
+
+				meRoute.GET("/mfa", controller.GetMyMFA)
+				meRoute.DELETE("/mfa", controller.DisableMyMFA)
+				meRoute.POST("/mfa/enroll", controller.EnrollMyMFA)
+				meRoute.POST("/mfa/confirm", controller.ConfirmMyMFA)
+				meRoute.POST("/mfa/recovery-codes", controller.RegenerateMyRecoveryCodes)
+
+				meRoute.GET("/passkeys", controller.GetMyPasskeys)
+				meRoute.POST("/passkeys/register/begin", controller.BeginPasskeyRegistration)
+				meRoute.POST("/passkeys/register/finish", controller.FinishPasskeyRegistration)
+				meRoute.PATCH("/passkeys/:id", controller.RenameMyPasskey)
+				meRoute.DELETE("/passkeys/:id", controller.DeleteMyPasskey)
+
+				meRoute.GET("/tokens", controller.GetMyTokens)
+				meRoute.POST("/tokens", controller.CreateMyToken)
+				meRoute.DELETE("/tokens/:id", controller.DeleteMyToken)
+			}
 			{
 				//Users
 				userRoute := auth.Group("/users")
@@ -50,19 +107,60 @@ func (s *Server) RegisterRoutes() http.Handler {
 
 				userRoute.POST("/:id/assign-role",
 					middleware.HasPermission(s.db, "role.assign"),
+					middleware.Audit(s.audit, "role.assign"),
 					controller.AssignRoleToUser)
 
 				userRoute.PUT("/:id",
-					middleware.HasPermission(s.db, "user.update"),
+					middleware.HasPermissionOn(s.db, "user.update", middleware.UserResource(s.db)),
+					middleware.Audit(s.audit, "user.update"),
 					controller.UpdateUser)
 
 				userRoute.DELETE("/:id",
 					middleware.HasPermission(s.db, "user.delete"),
+					middleware.RequireRecentAuth(),
+					middleware.Audit(s.audit, "user.delete"),
 					controller.DeleteUser)
 
 				userRoute.PUT("/:id/password",
-					middleware.HasPermission(s.db, "user.update"),
+					middleware.ForbidImpersonation(),
+					middleware.HasPermissionOn(s.db, "user.update", middleware.UserResource(s.db)),
+					middleware.Audit(s.audit, "user.password.change"),
 					controller.ChangePassword)
+
+				userRoute.POST("/:id/revoke-sessions",
+					middleware.HasPermission(s.db, "session.revoke"),
+					middleware.Audit(s.audit, "session.revoke"),
+					controller.RevokeUserSessions(s.revocations))
+
+				userRoute.GET("/:id/sessions",
+					middleware.HasPermission(s.db, "session.read"),
+					controller.GetUserSessions)
+
+				userRoute.DELETE("/:id/sessions/:sessionId",
+					middleware.HasPermission(s.db, "session.revoke"),
+					middleware.Audit(s.audit, "session.revoke"),
+					controller.DeleteUserSession(s.revocations))
+
+				userRoute.POST("/:id/unlock",
+					middleware.HasPermission(s.db, "user.unlock"),
+					middleware.Audit(s.audit, "user.unlock"),
+					controller.UnlockUser(s.loginThrottle))
+
+				userRoute.DELETE("/:id/mfa",
+					middleware.HasPermission(s.db, "mfa.reset"),
+					middleware.Audit(s.audit, "mfa.reset"),
+					controller.ResetUserMFA)
+
+				userRoute.GET("/:id/permissions/explain",
+					middleware.HasPermission(s.db, "permission.read"),
+					controller.ExplainUserPermission)
+
+				userRoute.POST("/:id/impersonate",
+					middleware.RequireSession(),
+					middleware.ForbidImpersonation(),
+					middleware.HasPermission(s.db, "user.impersonate"),
+					middleware.Audit(s.audit, "user.impersonate.start"),
+					controller.StartImpersonation)
 			}
 			{
 				//Permissions
@@ -74,10 +172,12 @@ func (s *Server) RegisterRoutes() http.Handler {
 
 				permissionRoute.POST("/",
 					middleware.HasPermission(s.db, "permission.create"),
+					middleware.Audit(s.audit, "permission.create"),
 					controller.CreatePermission)
 
 				permissionRoute.DELETE("/:id",
 					middleware.HasPermission(s.db, "permission.delete"),
+					middleware.Audit(s.audit, "permission.delete"),
 					controller.DeletePermission)
 			}
 			{
@@ -90,16 +190,111 @@ func (s *Server) RegisterRoutes() http.Handler {
 
 				roleRoute.POST("/",
 					middleware.HasPermission(s.db, "role.create"),
+					middleware.Audit(s.audit, "role.create"),
 					controller.CreateRole)
 
 				roleRoute.POST("/permissions",
 					middleware.HasPermission(s.db, "role.update"),
+					middleware.Audit(s.audit, "role.permissions.assign"),
 					controller.AssignPermissionsToRole)
 
+				roleRoute.POST("/conditions/dry-run",
+					middleware.HasPermission(s.db, "role.update"),
+					controller.DryRunCondition)
+
+				roleRoute.GET("/:id/permissions",
+					middleware.HasPermission(s.db, "role.read"),
+					controller.GetRolePermissions)
+
+				roleRoute.PUT("/:id/parents",
+					middleware.HasPermission(s.db, "role.update"),
+					middleware.Audit(s.audit, "role.parents.update"),
+					controller.SetRoleParents)
+
 				roleRoute.DELETE("/:id",
 					middleware.HasPermission(s.db, "role.delete"),
+					middleware.RequireRecentAuth(),
+					middleware.Audit(s.audit, "role.delete"),
 					controller.DeleteRole)
+
+				roleRoute.PUT("/:id/mfa",
+					middleware.HasPermission(s.db, "role.update"),
+					middleware.Audit(s.audit, "role.mfa.update"),
+					controller.SetRoleMFA)
+
+				roleRoute.PUT("/:id/magic-link",
+					middleware.HasPermission(s.db, "role.update"),
+					middleware.Audit(s.audit, "role.magic_link.update"),
+					controller.SetRoleMagicLink)
+			}
+			{
+				//OAuth
+				oauthRoute := auth.Group("/oauth")
+
+				oauthRoute.GET("/authorize",
+					middleware.RequireSession(),
+					middleware.ForbidImpersonation(),
+					controller.GetOAuthAuthorization(s.revocations))
+				oauthRoute.POST("/authorize",
+					middleware.RequireSession(),
+					middleware.ForbidImpersonation(),
+					controller.PostOAuthAuthorization(s.revocations))
+
+				oauthRoute.GET("/clients",
+					middleware.HasPermission(s.db, "client.read"),
+					controller.GetOAuthClients)
+
+				oauthRoute.POST("/clients",
+					middleware.HasPermission(s.db, "client.create"),
+					middleware.Audit(s.audit, "client.create"),
+					controller.CreateOAuthClient)
+
+				oauthRoute.DELETE("/clients/:id",
+					middleware.HasPermission(s.db, "client.delete"),
+					middleware.Audit(s.audit, "client.delete"),
+					controller.DeleteOAuthClient)
 			}
+			{
+				//Service accounts
+				serviceAccountRoute := auth.Group("/service-accounts")
+
+				serviceAccountRoute.GET("/",
+					middleware.HasPermission(s.db, "service_account.read"),
+					controller.GetServiceAccounts)
+
+				serviceAccountRoute.POST("/",
+					middleware.HasPermission(s.db, "service_account.create"),
+					middleware.Audit(s.audit, "service_account.create"),
+					controller.CreateServiceAccount)
+
+				serviceAccountRoute.GET("/:id",
+					middleware.HasPermission(s.db, "service_account.read"),
+					controller.GetServiceAccount)
+
+				serviceAccountRoute.PUT("/:id/roles",
+					middleware.HasPermission(s.db, "service_account.update"),
+					middleware.Audit(s.audit, "service_account.roles.update"),
+					controller.SetServiceAccountRoles)
+
+				serviceAccountRoute.POST("/:id/disable",
+					middleware.HasPermission(s.db, "service_account.update"),
+					middleware.Audit(s.audit, "service_account.disable"),
+					controller.DisableServiceAccount)
+
+				serviceAccountRoute.POST("/:id/enable",
+					middleware.HasPermission(s.db, "service_account.update"),
+					middleware.Audit(s.audit, "service_account.enable"),
+					controller.EnableServiceAccount)
+
+				serviceAccountRoute.POST("/:id/rotate-credentials",
+					middleware.HasPermission(s.db, "service_account.update"),
+					middleware.Audit(s.audit, "service_account.credentials.rotate"),
+					controller.RotateServiceAccountCredentials)
+			}
+
+			auth.GET("/audit-logs",
+				middleware.HasPermission(s.db, "audit.read"),
+				controller.GetAuditLogs(s.audit))
 		}
 		api.GET("/docs", func(c *gin.Context) {
 			c.Redirect(http.StatusFound, "/swagger/index.html")
@@ -109,6 +304,8 @@ func (s *Server) RegisterRoutes() http.Handler {
 	// Swagger endpoint
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	r.GET("/health", s.healthHandler)
+	r.GET("/.well-known/jwks.json", controller.JWKS)
+	r.GET("/.well-known/openid-configuration", controller.OpenIDConfiguration)
 
 	return r
 }
@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -10,19 +11,61 @@ import (
 	_ "github.com/joho/godotenv/autoload"
 
 	"Admin-gin/internal/database"
+	"Admin-gin/internal/services"
+	"Admin-gin/internal/utils"
 )
 
 type Server struct {
 	port int
 
-	db database.Service
+	db               database.Service
+	revocations      services.RevocationStore
+	loginThrottle    services.LoginThrottle
+	magicLinkLimiter services.SendLimiter
+
+	personalAccessTokens services.PersonalAccessTokenService
+	serviceAccounts      services.ServiceAccountService
+	audit                services.AuditStore
 }
 
 func NewServer() *http.Server {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
+	if _, err := utils.Keys(); err != nil {
+		log.Fatal("failed to load JWT signing keys: ", err)
+	}
+	if _, err := utils.Passwords(); err != nil {
+		log.Fatal("failed to configure password hashing: ", err)
+	}
+	if _, err := services.DefaultPasswordPolicy(); err != nil {
+		log.Fatal("failed to load password policy: ", err)
+	}
+	if _, err := services.OIDCProviders(); err != nil {
+		log.Fatal("failed to load OIDC providers: ", err)
+	}
+	if _, err := services.DefaultSAMLProvider(); err != nil {
+		log.Fatal("failed to load SAML configuration: ", err)
+	}
+	if _, err := services.DefaultPasskeyRelyingParty(); err != nil {
+		log.Fatal("failed to configure passkeys: ", err)
+	}
+	if _, err := services.Authenticators(); err != nil {
+		log.Fatal("failed to load authentication backends: ", err)
+	}
+	db := database.New()
+	loginAttempts := services.NewPostgresLoginAttemptStore(db)
 	NewServer := &Server{
-		port: port,
-		db:   database.New(),
+		port:        port,
+		db:          db,
+		revocations: services.NewPostgresRevocationStore(db),
+		loginThrottle: services.NewLoginThrottle(
+			loginAttempts,
+			services.DefaultLockoutPolicy(),
+			services.EmailLockoutNotifier,
+		),
+		magicLinkLimiter:     services.NewSendLimiter(loginAttempts, "magic:", services.DefaultMagicLinkSendLimit()),
+		personalAccessTokens: services.NewPersonalAccessTokenService(),
+		serviceAccounts:      services.NewServiceAccountService(),
+		audit:                services.NewPostgresAuditStore(db),
 	}
 
 	server := &http.Server{
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidActionToken = errors.New("invalid or expired token")
+
+type ActionTokenService interface {
+	Issue(userID uint, purpose string) (string, error)
+	// IssueBound issues a token that is only accepted together with the binding nonce
+	IssueBound(userID uint, purpose, binding string) (string, error)
+	Peek(token, purpose string) (uint, error)
+	Consume(token, purpose string) (uint, error)
+	ConsumeBound(token, purpose, binding string) (uint, error)
+	InvalidateUserTokens(userID uint, purpose string) error
+}
+
+type actionTokenService struct {
+	db database.Service
+}
+
+func NewActionTokenService() ActionTokenService {
+	return &actionTokenService{
+		db: database.New(),
+	}
+}
+
+// ActionTokenTTL returns the lifetime of tokens issued for the purpose
+func ActionTokenTTL(purpose string) time.Duration {
+	switch purpose {
+	case models.PurposePasswordReset:
+		return utils.GetEnvDuration("PASSWORD_RESET_TTL", time.Hour)
+	case models.PurposeEmailVerification:
+		return utils.GetEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	case models.PurposeMagicLogin:
+		return utils.GetEnvDuration("MAGIC_LINK_TTL", 15*time.Minute)
+	default:
+		return 15 * time.Minute
+	}
+}
+
+// Issue creates a new token for the purpose, invalidating the user's previous ones
+func (s *actionTokenService) Issue(userID uint, purpose string) (string, error) {
+	return s.IssueBound(userID, purpose, "")
+}
+
+func (s *actionTokenService) IssueBound(userID uint, purpose, binding string) (string, error) {
+	var token string
+	err := s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		token, err = issueBoundActionToken(tx, userID, purpose, binding)
+		return err
+	})
+	return token, err
+}
+
+// bindingHash returns the stored form of a binding nonce; unbound tokens store nothing
+func bindingHash(binding string) string {
+	if binding == "" {
+		return ""
+	}
+	return utils.HashToken(binding)
+}
+
+// Peek returns the user a valid token was issued to without consuming it, so a
+// request can be validated before the token is spent
+func (s *actionTokenService) Peek(token, purpose string) (uint, error) {
+	var actionToken models.ActionToken
+	err := s.db.GetDB().
+		Where("token_hash = ? AND purpose = ? AND consumed_at IS NULL AND expires_at > ?",
+			utils.HashToken(token), purpose, time.Now()).
+		First(&actionToken).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, ErrInvalidActionToken
+	} else if err != nil {
+		return 0, err
+	}
+	return actionToken.UserID, nil
+}
+
+// Consume marks the token as used and returns the user it was issued to. Each
+// token can be consumed once, and only for the purpose it was issued for.
+func (s *actionTokenService) Consume(token, purpose string) (uint, error) {
+	return s.ConsumeBound(token, purpose, "")
+}
+
+// ConsumeBound is Consume for tokens issued with IssueBound. A token presented
+// with the wrong binding is left untouched, so a forwarded link cannot burn it.
+func (s *actionTokenService) ConsumeBound(token, purpose, binding string) (uint, error) {
+	var actionToken models.ActionToken
+	err := s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND purpose = ? AND binding_hash = ? AND consumed_at IS NULL AND expires_at > ?",
+				utils.HashToken(token), purpose, bindingHash(binding), time.Now()).
+			First(&actionToken).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidActionToken
+		} else if err != nil {
+			return err
+		}
+		return tx.Model(&actionToken).Update("consumed_at", time.Now()).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return actionToken.UserID, nil
+}
+
+// InvalidateUserTokens consumes every outstanding token of the user for the purpose
+func (s *actionTokenService) InvalidateUserTokens(userID uint, purpose string) error {
+	return invalidateActionTokens(s.db.GetDB(), userID, purpose)
+}
+
+// issueActionToken runs inside the caller's transaction so a token is never
+// stored for a user that failed to be created
+func issueActionToken(tx *gorm.DB, userID uint, purpose string) (string, error) {
+	return issueBoundActionToken(tx, userID, purpose, "")
+}
+
+func issueBoundActionToken(tx *gorm.DB, userID uint, purpose, binding string) (string, error) {
+	if err := invalidateActionTokens(tx, userID, purpose); err != nil {
+		return "", err
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	actionToken := models.ActionToken{
+		Purpose:     purpose,
+		UserID:      userID,
+		TokenHash:   utils.HashToken(token),
+		BindingHash: bindingHash(binding),
+		ExpiresAt:   time.Now().Add(ActionTokenTTL(purpose)),
+	}
+	if err := tx.Create(&actionToken).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func invalidateActionTokens(tx *gorm.DB, userID uint, purpose string) error {
+	return tx.Model(&models.ActionToken{}).
+		Where("user_id = ? AND purpose = ? AND consumed_at IS NULL", userID, purpose).
+		Update("consumed_at", time.Now()).Error
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"sync"
+	"time"
+)
+
+// defaultAuditLimit caps the number of entries returned when no limit is given
+const defaultAuditLimit = 100
+
+// AuditFilter narrows down audit log entries. Zero values match everything.
+type AuditFilter struct {
+	ActorType string
+	ActorID   uint
+	Action    string
+	Limit     int
+}
+
+func (f AuditFilter) matches(entry *models.AuditLog) bool {
+	return (f.ActorType == "" || entry.ActorType == f.ActorType) &&
+		(f.ActorID == 0 || entry.ActorID == f.ActorID) &&
+		(f.Action == "" || entry.Action == f.Action)
+}
+
+func (f AuditFilter) limit() int {
+	if f.Limit <= 0 || f.Limit > defaultAuditLimit {
+		return defaultAuditLimit
+	}
+	return f.Limit
+}
+
+// AuditStore persists audit log entries of changes made through the API
+type AuditStore interface {
+	Record(entry *models.AuditLog) error
+	// List returns matching entries, newest first
+	List(filter AuditFilter) ([]models.AuditLog, error)
+}
+
+type postgresAuditStore struct {
+	db database.Service
+}
+
+func NewPostgresAuditStore(db database.Service) AuditStore {
+	return &postgresAuditStore{db: db}
+}
+
+func (s *postgresAuditStore) Record(entry *models.AuditLog) error {
+	return s.db.GetDB().Create(entry).Error
+}
+
+func (s *postgresAuditStore) List(filter AuditFilter) ([]models.AuditLog, error) {
+	query := s.db.GetDB().Model(&models.AuditLog{})
+	if filter.ActorType != "" {
+		query = query.Where("actor_type = ?", filter.ActorType)
+	}
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+
+	var entries []models.AuditLog
+	if err := query.Order("created_at DESC, id DESC").Limit(filter.limit()).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+type memoryAuditStore struct {
+	mu      sync.RWMutex
+	entries []models.AuditLog
+}
+
+// NewMemoryAuditStore returns a process local store, intended for tests
+func NewMemoryAuditStore() AuditStore {
+	return &memoryAuditStore{}
+}
+
+func (s *memoryAuditStore) Record(entry *models.AuditLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = uint(len(s.entries) + 1)
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	s.entries = append(s.entries, *entry)
+	return nil
+}
+
+func (s *memoryAuditStore) List(filter AuditFilter) ([]models.AuditLog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []models.AuditLog{}
+	for i := len(s.entries) - 1; i >= 0 && len(entries) < filter.limit(); i-- {
+		if filter.matches(&s.entries[i]) {
+			entries = append(entries, s.entries[i])
+		}
+	}
+	return entries, nil
+}
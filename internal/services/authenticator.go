@@ -0,0 +1,163 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Authenticator verifies an email and password against one identity backend.
+// It returns ErrInvalidCredentials when the backend does not know the user or
+// the password is wrong, so the next configured backend can be tried.
+type Authenticator interface {
+	Name() string
+	// Authenticate returns the local user, with Roles preloaded
+	Authenticate(email, password string) (*models.User, error)
+}
+
+var (
+	defaultAuthenticators     []Authenticator
+	defaultAuthenticatorsErr  error
+	defaultAuthenticatorsOnce sync.Once
+)
+
+// Authenticators returns the process wide backends used by password logins
+func Authenticators() ([]Authenticator, error) {
+	defaultAuthenticatorsOnce.Do(func() {
+		defaultAuthenticators, defaultAuthenticatorsErr = LoadAuthenticatorsFromEnv()
+	})
+	return defaultAuthenticators, defaultAuthenticatorsErr
+}
+
+// LoadAuthenticatorsFromEnv reads the comma separated backends in
+// AUTH_BACKENDS, tried in order. Supported backends are "db", the local
+// password hashes, and "ldap".
+func LoadAuthenticatorsFromEnv() ([]Authenticator, error) {
+	var authenticators []Authenticator
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(utils.GetEnv("AUTH_BACKENDS", "db"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("authentication backend %q is listed twice", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "db":
+			authenticators = append(authenticators, NewDBAuthenticator())
+		case "ldap":
+			config, err := LoadLDAPConfigFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			authenticators = append(authenticators, NewLDAPAuthenticator(NewLDAPDirectory(config)))
+		default:
+			return nil, fmt.Errorf("unknown authentication backend %q", name)
+		}
+	}
+	if len(authenticators) == 0 {
+		return nil, errors.New("AUTH_BACKENDS must name at least one backend")
+	}
+	return authenticators, nil
+}
+
+// authenticate tries each backend in turn. A backend that fails for another
+// reason than wrong credentials is logged and skipped, so an unreachable
+// directory does not lock out local accounts. Its error is only returned when
+// no backend accepted or rejected the credentials: a rejection always counts
+// as a failed login, so an unreachable directory cannot bypass the throttle.
+func authenticate(authenticators []Authenticator, email, password string) (*models.User, error) {
+	var failure error
+	rejected := false
+	for _, authenticator := range authenticators {
+		user, err := authenticator.Authenticate(email, password)
+		if err == nil {
+			return user, nil
+		}
+		switch {
+		case errors.Is(err, ErrInvalidCredentials):
+			rejected = true
+		case errors.Is(err, ErrEmailNotVerified):
+			return nil, err
+		default:
+			log.Printf("%s authentication failed: %v", authenticator.Name(), err)
+			if failure == nil {
+				failure = err
+			}
+		}
+	}
+	if failure != nil && !rejected {
+		return nil, failure
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// dbAuthenticator checks the password hashes stored with the users
+type dbAuthenticator struct {
+	db database.Service
+}
+
+func NewDBAuthenticator() Authenticator {
+	return &dbAuthenticator{
+		db: database.New(),
+	}
+}
+
+func (a *dbAuthenticator) Name() string {
+	return "db"
+}
+
+func (a *dbAuthenticator) Authenticate(email, password string) (*models.User, error) {
+	var user models.User
+	result := a.db.GetDB().Preload("Roles").Where("email = ? AND deleted_at IS NULL", email).First(&user)
+	if result.Error != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	hasher, err := utils.Passwords()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := hasher.Verify(password, user.Password); err != nil || !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.Status != "active" {
+		return nil, ErrEmailNotVerified
+	}
+
+	// Upgrade hashes made with an older algorithm or weaker parameters while the
+	// plaintext is at hand
+	if hasher.NeedsRehash(user.Password) {
+		a.rehashPassword(&user, hasher, password)
+	}
+
+	return &user, nil
+}
+
+// rehashPassword replaces an outdated hash. A failure is only logged since the
+// login itself succeeded and the next one will try again.
+func (a *dbAuthenticator) rehashPassword(user *models.User, hasher utils.PasswordHasher, password string) {
+	hashed, err := hasher.Hash(password)
+	if err != nil {
+		log.Printf("failed to rehash password of user %d: %v", user.ID, err)
+		return
+	}
+	// Only replace the hash that was verified, in case the password changed meanwhile
+	result := a.db.GetDB().Model(&models.User{}).
+		Where("id = ? AND password = ?", user.ID, user.Password).
+		Update("password", hashed)
+	if result.Error != nil {
+		log.Printf("failed to rehash password of user %d: %v", user.ID, result.Error)
+		return
+	}
+	user.Password = hashed
+}
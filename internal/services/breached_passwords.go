@@ -0,0 +1,85 @@
+package services
+
+import (
+	"Admin-gin/internal/models"
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PasswordRangeSource answers k-anonymity range queries: given the first five
+// hex characters of a SHA-1 password hash it returns the remaining 35
+// characters of every breached hash sharing that prefix. Only the prefix ever
+// leaves the caller, so the source could equally be a remote service.
+type PasswordRangeSource interface {
+	Range(prefix string) ([]string, error)
+}
+
+const hashPrefixLength = 5
+
+type passwordRangeFile struct {
+	ranges map[string][]string
+}
+
+// LoadPasswordRangeFile loads breached password hashes from a local file in the
+// Have I Been Pwned format: one uppercase SHA-1 hash per line, optionally
+// followed by ":<count>". Blank lines and lines starting with # are ignored.
+func LoadPasswordRangeFile(path string) (PasswordRangeSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	source := &passwordRangeFile{ranges: make(map[string][]string)}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(entry, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("line %d: expected a SHA-1 hash", line)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		prefix := hash[:hashPrefixLength]
+		source.ranges[prefix] = append(source.ranges[prefix], hash[hashPrefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+func (f *passwordRangeFile) Range(prefix string) ([]string, error) {
+	return f.ranges[strings.ToUpper(prefix)], nil
+}
+
+// BreachedPasswordRule rejects passwords found in the source
+func BreachedPasswordRule(source PasswordRangeSource) PasswordRule {
+	return func(password string, _ *models.User) (*PasswordViolation, error) {
+		sum := sha1.Sum([]byte(password))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+		suffixes, err := source.Range(hash[:hashPrefixLength])
+		if err != nil {
+			return nil, err
+		}
+		for _, suffix := range suffixes {
+			if suffix == hash[hashPrefixLength:] {
+				return &PasswordViolation{
+					Rule:    "breached",
+					Message: "password has appeared in a data breach, please choose a different one",
+				}, nil
+			}
+		}
+		return nil, nil
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"Admin-gin/internal/models"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// GroupRoles maps lower-cased group names of an external directory to the
+// names of the roles their members get
+type GroupRoles map[string][]string
+
+// ParseGroupRoles reads a semicolon separated list of group=>role pairs from
+// the environment variable named key. A group may be listed more than once.
+func ParseGroupRoles(key, value string) (GroupRoles, error) {
+	groupRoles := make(GroupRoles)
+	for _, pair := range strings.Split(value, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=>")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" || role == "" {
+			return nil, fmt.Errorf("invalid %s entry %q, expected group=>role", key, pair)
+		}
+		group = strings.ToLower(group)
+		groupRoles[group] = append(groupRoles[group], role)
+	}
+	return groupRoles, nil
+}
+
+// Managed returns every role named in the mapping, sorted
+func (g GroupRoles) Managed() []string {
+	unique := make(map[string]bool)
+	for _, roles := range g {
+		for _, role := range roles {
+			unique[role] = true
+		}
+	}
+	return sortedKeys(unique)
+}
+
+// Mapped returns the roles the groups are mapped to, sorted. Groups are
+// matched case-insensitively.
+func (g GroupRoles) Mapped(groups []string) []string {
+	unique := make(map[string]bool)
+	for _, group := range groups {
+		for _, role := range g[strings.ToLower(strings.TrimSpace(group))] {
+			unique[role] = true
+		}
+	}
+	return sortedKeys(unique)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// syncManagedRoles makes the user hold exactly the wanted roles among the
+// managed ones. Roles outside the managed set, e.g. granted by an
+// administrator, are left alone.
+func syncManagedRoles(tx *gorm.DB, userID uint, managed, wanted []string) (bool, error) {
+	if len(managed) == 0 {
+		return false, nil
+	}
+	var roles []models.Role
+	if err := tx.Where("name IN ?", managed).Find(&roles).Error; err != nil {
+		return false, err
+	}
+	if len(roles) != len(managed) {
+		return false, fmt.Errorf("group role mapping names a role that does not exist: %w", ErrUnknownRole)
+	}
+
+	wantedNames := make(map[string]bool, len(wanted))
+	for _, name := range wanted {
+		wantedNames[name] = true
+	}
+	managedIDs := make([]uint, len(roles))
+	for i, role := range roles {
+		managedIDs[i] = role.ID
+	}
+
+	var held []uint
+	err := tx.Table("user_has_roles").
+		Where("user_id = ? AND role_id IN ?", userID, managedIDs).
+		Pluck("role_id", &held).Error
+	if err != nil {
+		return false, err
+	}
+	holds := make(map[uint]bool, len(held))
+	for _, id := range held {
+		holds[id] = true
+	}
+
+	changed := false
+	for _, role := range roles {
+		switch {
+		case wantedNames[role.Name] && !holds[role.ID]:
+			if err := tx.Create(&models.UserHasRole{UserID: userID, RoleID: role.ID}).Error; err != nil {
+				return false, err
+			}
+			changed = true
+		case !wantedNames[role.Name] && holds[role.ID]:
+			err := tx.Where("user_id = ? AND role_id = ?", userID, role.ID).Delete(&models.UserHasRole{}).Error
+			if err != nil {
+				return false, err
+			}
+			changed = true
+		}
+	}
+	return changed, nil
+}
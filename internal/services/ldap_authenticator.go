@@ -0,0 +1,269 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+)
+
+var ErrLDAPEntryIncomplete = errors.New("the directory entry has no email address")
+
+// LDAPConfig configures the directory used by the ldap authentication backend
+type LDAPConfig struct {
+	URL      string
+	StartTLS bool
+	// TLSConfig is used for ldaps:// URLs and StartTLS; nil trusts the system roots
+	TLSConfig    *tls.Config
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds the entry of the user; every %s is replaced with the
+	// escaped login email
+	UserFilter     string
+	EmailAttribute string
+	NameAttribute  string
+	GroupAttribute string
+	// GroupRoles maps group DNs to role names
+	GroupRoles GroupRoles
+	Timeout    time.Duration
+}
+
+// LDAPEntry is the directory entry of an authenticated user
+type LDAPEntry struct {
+	DN     string
+	Email  string
+	Name   string
+	Groups []string
+}
+
+// LoadLDAPConfigFromEnv reads LDAP_URL, LDAP_START_TLS, LDAP_CA_CERT,
+// LDAP_BIND_DN, LDAP_BIND_PASSWORD, LDAP_BASE_DN, LDAP_USER_FILTER,
+// LDAP_EMAIL_ATTRIBUTE, LDAP_NAME_ATTRIBUTE, LDAP_GROUP_ATTRIBUTE,
+// LDAP_GROUP_ROLES and LDAP_TIMEOUT. LDAP_GROUP_ROLES is a semicolon separated
+// list of group DN=>role name pairs.
+func LoadLDAPConfigFromEnv() (*LDAPConfig, error) {
+	config := &LDAPConfig{
+		URL:            os.Getenv("LDAP_URL"),
+		BindDN:         os.Getenv("LDAP_BIND_DN"),
+		BindPassword:   os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:         os.Getenv("LDAP_BASE_DN"),
+		UserFilter:     utils.GetEnv("LDAP_USER_FILTER", "(&(objectClass=person)(mail=%s))"),
+		EmailAttribute: utils.GetEnv("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		NameAttribute:  utils.GetEnv("LDAP_NAME_ATTRIBUTE", "cn"),
+		GroupAttribute: utils.GetEnv("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+		Timeout:        utils.GetEnvDuration("LDAP_TIMEOUT", 10*time.Second),
+	}
+	if config.URL == "" || config.BaseDN == "" {
+		return nil, errors.New("the ldap backend needs LDAP_URL and LDAP_BASE_DN")
+	}
+	if !strings.Contains(config.UserFilter, "%s") {
+		return nil, errors.New("LDAP_USER_FILTER must contain %s for the login email")
+	}
+
+	if value := os.Getenv("LDAP_START_TLS"); value != "" {
+		startTLS, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LDAP_START_TLS: %w", err)
+		}
+		config.StartTLS = startTLS
+	}
+	if path := os.Getenv("LDAP_CA_CERT"); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read LDAP_CA_CERT: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("LDAP_CA_CERT contains no PEM certificates")
+		}
+		config.TLSConfig = &tls.Config{RootCAs: roots}
+	}
+
+	groupRoles, err := ParseGroupRoles("LDAP_GROUP_ROLES", os.Getenv("LDAP_GROUP_ROLES"))
+	if err != nil {
+		return nil, err
+	}
+	config.GroupRoles = groupRoles
+	return config, nil
+}
+
+// LDAPDirectory looks up and verifies users in an LDAP directory
+type LDAPDirectory struct {
+	config *LDAPConfig
+}
+
+func NewLDAPDirectory(config *LDAPConfig) *LDAPDirectory {
+	return &LDAPDirectory{config: config}
+}
+
+func (d *LDAPDirectory) connect() (*ldap.Conn, error) {
+	tlsConfig := d.config.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	// StartTLS and ldaps:// both verify the certificate against the URL host
+	if tlsConfig.ServerName == "" {
+		if host, err := ldapHost(d.config.URL); err == nil {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = host
+		}
+	}
+
+	conn, err := ldap.DialURL(d.config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: d.config.Timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(d.config.Timeout)
+
+	if d.config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func ldapHost(rawURL string) (string, error) {
+	parsed, err := neturl.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Hostname(), nil
+}
+
+// Authenticate finds the entry matching the email with the service account
+// and binds as that entry with the password. An unknown user, an ambiguous
+// filter and a wrong password all return ErrInvalidCredentials.
+func (d *LDAPDirectory) Authenticate(email, password string) (*LDAPEntry, error) {
+	// A simple bind with an empty password is an unauthenticated bind and
+	// would succeed on many servers
+	if email == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d.config.BindDN != "" {
+		if err := conn.Bind(d.config.BindDN, d.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("service bind failed: %w", err)
+		}
+	}
+
+	filter := strings.ReplaceAll(d.config.UserFilter, "%s", ldap.EscapeFilter(email))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(d.config.Timeout.Seconds()), false,
+		filter,
+		[]string{d.config.EmailAttribute, d.config.NameAttribute, d.config.GroupAttribute},
+		nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	found := result.Entries[0]
+
+	if err := conn.Bind(found.DN, password); ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+
+	entry := &LDAPEntry{
+		DN:     found.DN,
+		Email:  strings.TrimSpace(found.GetAttributeValue(d.config.EmailAttribute)),
+		Name:   strings.TrimSpace(found.GetAttributeValue(d.config.NameAttribute)),
+		Groups: found.GetAttributeValues(d.config.GroupAttribute),
+	}
+	if entry.Email == "" {
+		return nil, ErrLDAPEntryIncomplete
+	}
+	return entry, nil
+}
+
+// ldapAuthenticator signs users in with their directory password. The local
+// account is matched by email, or provisioned on the first login, and its
+// mapped roles are re-synced from the directory groups on every login.
+type ldapAuthenticator struct {
+	db        database.Service
+	directory *LDAPDirectory
+}
+
+func NewLDAPAuthenticator(directory *LDAPDirectory) Authenticator {
+	return &ldapAuthenticator{
+		db:        database.New(),
+		directory: directory,
+	}
+}
+
+func (a *ldapAuthenticator) Name() string {
+	return "ldap"
+}
+
+func (a *ldapAuthenticator) Authenticate(email, password string) (*models.User, error) {
+	entry, err := a.directory.Authenticate(email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	groupRoles := a.directory.config.GroupRoles
+	var user models.User
+	err = a.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("LOWER(email) = LOWER(?)", entry.Email).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Roles come from the group mapping below
+			if err := provisionUser(tx, &user, entry.Email, entry.Name, ""); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else if user.DeletedAt.Valid {
+			// Deleted accounts stay deleted even if the directory still has them
+			return ErrInvalidCredentials
+		} else if user.Status != "active" {
+			// An unverified account may have been registered by someone else
+			// with their own password, so it is never taken over
+			return ErrEmailNotVerified
+		}
+
+		changed, err := syncManagedRoles(tx, user.ID, groupRoles.Managed(), groupRoles.Mapped(entry.Groups))
+		if err != nil {
+			return err
+		}
+		if changed {
+			return bumpUserAuthzVersion(tx, user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.db.GetDB().Preload("Roles").First(&user, user.ID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,351 @@
+package services
+
+import (
+	"Admin-gin/internal/models"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jimlambrt/gldap"
+	"github.com/jimlambrt/gldap/testdirectory"
+)
+
+const (
+	mockLDAPBaseDN       = "dc=example,dc=org"
+	mockLDAPBindDN       = "cn=admin-gin,ou=services,dc=example,dc=org"
+	mockLDAPBindPassword = "service-secret"
+)
+
+// mockLDAPServer is an in-process directory that supports simple binds,
+// StartTLS and searches with equality filters joined by an implicit AND
+type mockLDAPServer struct {
+	t         *testing.T
+	server    *gldap.Server
+	url       string
+	serverTLS *tls.Config
+	clientTLS *tls.Config
+
+	// requireTLS rejects binds on connections that did not StartTLS
+	requireTLS bool
+
+	mu        sync.Mutex
+	entries   []*gldap.Entry
+	passwords map[string]string
+	secured   map[int]bool
+	filters   []string
+}
+
+var ldapEqualityTerm = regexp.MustCompile(`\(([^()=&|!]+)=([^()]*)\)`)
+
+func newMockLDAPServer(t *testing.T) *mockLDAPServer {
+	serverTLS, clientTLS := testdirectory.GetTLSConfig(t)
+	m := &mockLDAPServer{
+		t:         t,
+		serverTLS: serverTLS,
+		clientTLS: clientTLS,
+		passwords: map[string]string{mockLDAPBindDN: mockLDAPBindPassword},
+		secured:   make(map[int]bool),
+	}
+
+	server, err := gldap.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux, err := gldap.NewMux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Bind(m.bind)
+	mux.Search(m.search)
+	mux.ExtendedOperation(m.startTLS, gldap.ExtendedOperationStartTLS)
+	server.Router(mux)
+
+	port := testdirectory.FreePort(t)
+	go server.Run(fmt.Sprintf("localhost:%d", port))
+	t.Cleanup(func() { server.Stop() })
+	for !server.Ready() {
+		time.Sleep(time.Millisecond)
+	}
+	m.server = server
+	m.url = fmt.Sprintf("ldap://localhost:%d", port)
+	return m
+}
+
+func (m *mockLDAPServer) addUser(uid, email, password string, groups ...string) string {
+	dn := fmt.Sprintf("uid=%s,ou=people,%s", uid, mockLDAPBaseDN)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, gldap.NewEntry(dn, map[string][]string{
+		"objectClass": {"person"},
+		"uid":         {uid},
+		"cn":          {strings.ToUpper(uid[:1]) + uid[1:]},
+		"mail":        {email},
+		"memberOf":    groups,
+	}))
+	m.passwords[dn] = password
+	return dn
+}
+
+func (m *mockLDAPServer) config() *LDAPConfig {
+	groupRoles, err := ParseGroupRoles("LDAP_GROUP_ROLES",
+		"cn=Admins,ou=groups,dc=example,dc=org=>admin; cn=staff,ou=groups,dc=example,dc=org=>user")
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return &LDAPConfig{
+		URL:            m.url,
+		TLSConfig:      m.clientTLS,
+		BindDN:         mockLDAPBindDN,
+		BindPassword:   mockLDAPBindPassword,
+		BaseDN:         mockLDAPBaseDN,
+		UserFilter:     "(&(objectClass=person)(mail=%s))",
+		EmailAttribute: "mail",
+		NameAttribute:  "cn",
+		GroupAttribute: "memberOf",
+		GroupRoles:     groupRoles,
+		Timeout:        5 * time.Second,
+	}
+}
+
+func (m *mockLDAPServer) bind(w *gldap.ResponseWriter, r *gldap.Request) {
+	resp := r.NewBindResponse(gldap.WithResponseCode(gldap.ResultInvalidCredentials))
+	defer w.Write(resp)
+
+	msg, err := r.GetSimpleBindMessage()
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.requireTLS && !m.secured[r.ConnectionID()] {
+		resp.SetResultCode(gldap.ResultConfidentialityRequired)
+		return
+	}
+	if password, ok := m.passwords[msg.UserName]; ok && password != "" && password == string(msg.Password) {
+		resp.SetResultCode(gldap.ResultSuccess)
+	}
+}
+
+func (m *mockLDAPServer) startTLS(w *gldap.ResponseWriter, r *gldap.Request) {
+	resp := r.NewExtendedResponse(gldap.WithResponseCode(gldap.ResultSuccess))
+	resp.SetResponseName(gldap.ExtendedOperationStartTLS)
+	if err := w.Write(resp); err != nil {
+		return
+	}
+	if err := r.StartTLS(m.serverTLS); err != nil {
+		return
+	}
+	m.mu.Lock()
+	m.secured[r.ConnectionID()] = true
+	m.mu.Unlock()
+}
+
+func (m *mockLDAPServer) search(w *gldap.ResponseWriter, r *gldap.Request) {
+	resp := r.NewSearchDoneResponse(gldap.WithResponseCode(gldap.ResultNoSuchObject))
+	defer w.Write(resp)
+
+	msg, err := r.GetSearchMessage()
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.filters = append(m.filters, msg.Filter)
+
+	terms := ldapEqualityTerm.FindAllStringSubmatch(msg.Filter, -1)
+	for _, entry := range m.entries {
+		if !strings.HasSuffix(strings.ToLower(entry.DN), strings.ToLower(msg.BaseDN)) || !matchesAll(entry, terms) {
+			continue
+		}
+		result := r.NewSearchResponseEntry(entry.DN)
+		for _, attr := range entry.Attributes {
+			result.AddAttribute(attr.Name, attr.Values)
+		}
+		w.Write(result)
+		resp.SetResultCode(gldap.ResultSuccess)
+	}
+}
+
+// matchesAll compares the still escaped filter values with the escaped entry values
+func matchesAll(entry *gldap.Entry, terms [][]string) bool {
+	if len(terms) == 0 {
+		return false
+	}
+	for _, term := range terms {
+		found := false
+		for _, value := range entry.GetAttributeValues(term[1]) {
+			if strings.EqualFold(ldap.EscapeFilter(value), term[2]) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLDAPDirectoryAuthenticate(t *testing.T) {
+	server := newMockLDAPServer(t)
+	dn := server.addUser("alice", "alice@example.com", "alice-secret",
+		"cn=admins,ou=groups,dc=example,dc=org", "cn=other,ou=groups,dc=example,dc=org")
+	directory := NewLDAPDirectory(server.config())
+
+	entry, err := directory.Authenticate("alice@example.com", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.DN != dn || entry.Email != "alice@example.com" || entry.Name != "Alice" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if roles := directory.config.GroupRoles.Mapped(entry.Groups); !reflect.DeepEqual(roles, []string{"admin"}) {
+		t.Fatalf("expected the admins group to map to the admin role, got %v", roles)
+	}
+}
+
+func TestLDAPDirectoryRejectsInvalidCredentials(t *testing.T) {
+	server := newMockLDAPServer(t)
+	server.addUser("alice", "alice@example.com", "alice-secret")
+	directory := NewLDAPDirectory(server.config())
+
+	cases := map[string][2]string{
+		"wrong password": {"alice@example.com", "wrong"},
+		"empty password": {"alice@example.com", ""},
+		"unknown user":   {"bob@example.com", "alice-secret"},
+	}
+	for name, credentials := range cases {
+		if _, err := directory.Authenticate(credentials[0], credentials[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: expected ErrInvalidCredentials, got %v", name, err)
+		}
+	}
+}
+
+func TestLDAPDirectoryEscapesFilter(t *testing.T) {
+	server := newMockLDAPServer(t)
+	server.addUser("alice", "alice@example.com", "alice-secret")
+	directory := NewLDAPDirectory(server.config())
+
+	if _, err := directory.Authenticate("*", "alice-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected a wildcard email to match nothing, got %v", err)
+	}
+	if _, err := directory.Authenticate("alice@example.com)(uid=*", "alice-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected an injected filter to match nothing, got %v", err)
+	}
+	for _, filter := range server.filters {
+		if strings.Contains(filter, "(uid=") {
+			t.Fatalf("login email was not escaped in filter %q", filter)
+		}
+	}
+}
+
+func TestLDAPDirectoryCustomFilter(t *testing.T) {
+	server := newMockLDAPServer(t)
+	server.addUser("alice", "alice@example.com", "alice-secret")
+	config := server.config()
+	config.UserFilter = "(uid=%s)"
+	directory := NewLDAPDirectory(config)
+
+	entry, err := directory.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Email != "alice@example.com" {
+		t.Fatalf("expected the email from the directory, got %q", entry.Email)
+	}
+}
+
+func TestLDAPDirectoryStartTLS(t *testing.T) {
+	server := newMockLDAPServer(t)
+	server.requireTLS = true
+	server.addUser("alice", "alice@example.com", "alice-secret")
+	config := server.config()
+
+	if _, err := NewLDAPDirectory(config).Authenticate("alice@example.com", "alice-secret"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected the bind without StartTLS to fail, got %v", err)
+	}
+
+	config.StartTLS = true
+	if _, err := NewLDAPDirectory(config).Authenticate("alice@example.com", "alice-secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate must be trusted
+	config.TLSConfig = nil
+	if _, err := NewLDAPDirectory(config).Authenticate("alice@example.com", "alice-secret"); err == nil {
+		t.Fatal("expected an untrusted certificate to be rejected")
+	}
+}
+
+func TestGroupRoles(t *testing.T) {
+	groupRoles, err := ParseGroupRoles("LDAP_GROUP_ROLES", "cn=a,dc=x=>editor;cn=b,dc=x=>admin;cn=c,dc=x=>editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles := groupRoles.Managed(); !reflect.DeepEqual(roles, []string{"admin", "editor"}) {
+		t.Fatalf("unexpected managed roles %v", roles)
+	}
+	if roles := groupRoles.Mapped([]string{"CN=C,DC=X", "cn=unmapped,dc=x"}); !reflect.DeepEqual(roles, []string{"editor"}) {
+		t.Fatalf("expected group DNs to match case-insensitively, got %v", roles)
+	}
+	if _, err := ParseGroupRoles("LDAP_GROUP_ROLES", "cn=a,dc=x"); err == nil {
+		t.Fatal("expected an entry without a role to be rejected")
+	}
+}
+
+type stubAuthenticator struct {
+	name string
+	user *models.User
+	err  error
+}
+
+func (a *stubAuthenticator) Name() string { return a.name }
+
+func (a *stubAuthenticator) Authenticate(email, password string) (*models.User, error) {
+	return a.user, a.err
+}
+
+func TestAuthenticateTriesBackendsInOrder(t *testing.T) {
+	user := &models.User{ID: 7}
+	unavailable := errors.New("directory unavailable")
+
+	got, err := authenticate([]Authenticator{
+		&stubAuthenticator{name: "ldap", err: unavailable},
+		&stubAuthenticator{name: "db", user: user},
+	}, "alice@example.com", "secret")
+	if err != nil || got != user {
+		t.Fatalf("expected the second backend to accept the login, got %v, %v", got, err)
+	}
+
+	// A rejected password counts as a failed login whatever the other
+	// backends did, so the throttle records it
+	for _, order := range [][]Authenticator{
+		{&stubAuthenticator{name: "ldap", err: unavailable}, &stubAuthenticator{name: "db", err: ErrInvalidCredentials}},
+		{&stubAuthenticator{name: "db", err: ErrInvalidCredentials}, &stubAuthenticator{name: "ldap", err: unavailable}},
+	} {
+		if _, err = authenticate(order, "alice@example.com", "secret"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected a rejected password to be reported, got %v", err)
+		}
+	}
+
+	_, err = authenticate([]Authenticator{
+		&stubAuthenticator{name: "ldap", err: unavailable},
+	}, "alice@example.com", "secret")
+	if !errors.Is(err, unavailable) {
+		t.Fatalf("expected the backend failure to be reported, got %v", err)
+	}
+
+	_, err = authenticate([]Authenticator{
+		&stubAuthenticator{name: "db", err: ErrEmailNotVerified},
+		&stubAuthenticator{name: "ldap", user: user},
+	}, "alice@example.com", "secret")
+	if !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("expected an unverified account to stop the chain, got %v", err)
+	}
+}
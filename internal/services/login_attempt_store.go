@@ -0,0 +1,125 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginAttemptStore keeps failed login counters. It is shared by every API
+// replica, so the Postgres implementation must be used in production.
+type LoginAttemptStore interface {
+	// Get returns the counter for the key, or nil when there were no recent failures
+	Get(key string) (*models.LoginAttempt, error)
+	// RecordFailure increments the counter, restarting it when the last failure is
+	// older than window, and blocks the key for blockFor(failures)
+	RecordFailure(key string, window time.Duration, blockFor func(failures int) time.Duration) (*models.LoginAttempt, error)
+	// Reset clears the counter and any block
+	Reset(key string) error
+}
+
+type postgresLoginAttemptStore struct {
+	db database.Service
+}
+
+func NewPostgresLoginAttemptStore(db database.Service) LoginAttemptStore {
+	return &postgresLoginAttemptStore{db: db}
+}
+
+func (s *postgresLoginAttemptStore) Get(key string) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	err := s.db.GetDB().Where("key = ?", key).First(&attempt).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func (s *postgresLoginAttemptStore) RecordFailure(key string, window time.Duration, blockFor func(int) time.Duration) (*models.LoginAttempt, error) {
+	now := time.Now()
+	var attempt models.LoginAttempt
+	err := s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		// The upsert serializes concurrent failures on the same key
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures": gorm.Expr("CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END",
+					now.Add(-window)),
+				"last_failure_at": now,
+			}),
+		}).Create(&models.LoginAttempt{Key: key, Failures: 1, LastFailureAt: now}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("key = ?", key).First(&attempt).Error; err != nil {
+			return err
+		}
+		attempt.BlockedUntil = now.Add(blockFor(attempt.Failures))
+		return tx.Model(&attempt).Update("blocked_until", attempt.BlockedUntil).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func (s *postgresLoginAttemptStore) Reset(key string) error {
+	return s.db.GetDB().Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
+
+type memoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]models.LoginAttempt
+}
+
+// NewMemoryLoginAttemptStore returns a process local store, intended for tests
+// and single instance deployments.
+func NewMemoryLoginAttemptStore() LoginAttemptStore {
+	return &memoryLoginAttemptStore{
+		attempts: make(map[string]models.LoginAttempt),
+	}
+}
+
+func (s *memoryLoginAttemptStore) Get(key string) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	return &attempt, nil
+}
+
+func (s *memoryLoginAttemptStore) RecordFailure(key string, window time.Duration, blockFor func(int) time.Duration) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	attempt, ok := s.attempts[key]
+	if !ok || attempt.LastFailureAt.Before(now.Add(-window)) {
+		attempt = models.LoginAttempt{Key: key}
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	attempt.BlockedUntil = now.Add(blockFor(attempt.Failures))
+	s.attempts[key] = attempt
+
+	return &attempt, nil
+}
+
+func (s *memoryLoginAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"Admin-gin/internal/utils"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+var ErrTooManyAttempts = errors.New("too many failed login attempts, please try again later")
+
+// LockoutPolicy configures backoff and lockout of failed logins
+type LockoutPolicy struct {
+	// Window after which an idle counter starts over
+	Window time.Duration
+	// BaseDelay is the wait after the first failure, doubled on every further failure
+	BaseDelay time.Duration
+	// MaxDelay caps the exponential backoff
+	MaxDelay time.Duration
+	// AccountThreshold failures lock the account for LockoutDuration
+	AccountThreshold int
+	// IPThreshold failures block the client IP for LockoutDuration
+	IPThreshold     int
+	LockoutDuration time.Duration
+}
+
+// DefaultLockoutPolicy reads the policy from LOGIN_* environment variables
+func DefaultLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		Window:           utils.GetEnvDuration("LOGIN_ATTEMPT_WINDOW", time.Hour),
+		BaseDelay:        utils.GetEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+		MaxDelay:         utils.GetEnvDuration("LOGIN_BACKOFF_MAX", time.Minute),
+		AccountThreshold: utils.GetEnvInt("LOGIN_LOCKOUT_THRESHOLD", 10),
+		IPThreshold:      utils.GetEnvInt("LOGIN_IP_THRESHOLD", 50),
+		LockoutDuration:  utils.GetEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+	}
+}
+
+// LockoutNotifier is told when an account becomes locked
+type LockoutNotifier func(email string, until time.Time)
+
+// LoginThrottle applies the lockout policy per account and per client IP
+type LoginThrottle interface {
+	// Check returns ErrTooManyAttempts and the remaining wait when either key is blocked
+	Check(email, ip string) (time.Duration, error)
+	RecordFailure(email, ip string) error
+	RecordSuccess(email string) error
+	Unlock(email string) error
+}
+
+type loginThrottle struct {
+	store  LoginAttemptStore
+	policy LockoutPolicy
+	notify LockoutNotifier
+}
+
+func NewLoginThrottle(store LoginAttemptStore, policy LockoutPolicy, notify LockoutNotifier) LoginThrottle {
+	return &loginThrottle{
+		store:  store,
+		policy: policy,
+		notify: notify,
+	}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (t *loginThrottle) Check(email, ip string) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		attempt, err := t.store.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		if attempt == nil {
+			continue
+		}
+		if remaining := time.Until(attempt.BlockedUntil); remaining > wait {
+			wait = remaining
+		}
+	}
+	if wait > 0 {
+		return wait, ErrTooManyAttempts
+	}
+	return 0, nil
+}
+
+func (t *loginThrottle) RecordFailure(email, ip string) error {
+	account, err := t.store.RecordFailure(accountKey(email), t.policy.Window, t.blockFor(t.policy.AccountThreshold))
+	if err != nil {
+		return err
+	}
+	if _, err := t.store.RecordFailure(ipKey(ip), t.policy.Window, t.blockFor(t.policy.IPThreshold)); err != nil {
+		return err
+	}
+
+	// Notify once, when the account crosses the threshold
+	if account.Failures == t.policy.AccountThreshold && t.notify != nil {
+		t.notify(email, account.BlockedUntil)
+	}
+	return nil
+}
+
+func (t *loginThrottle) RecordSuccess(email string) error {
+	return t.store.Reset(accountKey(email))
+}
+
+func (t *loginThrottle) Unlock(email string) error {
+	return t.store.Reset(accountKey(email))
+}
+
+// blockFor is the exponential backoff, replaced by the lockout duration once
+// the threshold is reached
+func (t *loginThrottle) blockFor(threshold int) func(int) time.Duration {
+	return func(failures int) time.Duration {
+		if threshold > 0 && failures >= threshold {
+			return t.policy.LockoutDuration
+		}
+		delay := t.policy.BaseDelay
+		for i := 1; i < failures && delay < t.policy.MaxDelay; i++ {
+			delay *= 2
+		}
+		if delay > t.policy.MaxDelay {
+			delay = t.policy.MaxDelay
+		}
+		return delay
+	}
+}
+
+// EmailLockoutNotifier emails the owner of a locked account, if the account exists
+func EmailLockoutNotifier(email string, until time.Time) {
+	go func() {
+		user, err := NewUserService().GetUserByEmail(email)
+		if err != nil || user.ID == 0 {
+			return
+		}
+		if err := utils.SendAccountLockedEmail(user.Email, until); err != nil {
+			log.Printf("failed to send account locked email to user %d: %v", user.ID, err)
+		}
+	}()
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func testLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		Window:           time.Hour,
+		BaseDelay:        time.Second,
+		MaxDelay:         4 * time.Second,
+		AccountThreshold: 5,
+		IPThreshold:      20,
+		LockoutDuration:  15 * time.Minute,
+	}
+}
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptStore(), testLockoutPolicy(), nil)
+
+	if _, err := throttle.Check("user@example.com", "10.0.0.1"); err != nil {
+		t.Fatalf("expected first attempt to be allowed, got %v", err)
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+	for i, expected := range want {
+		if err := throttle.RecordFailure("user@example.com", "10.0.0.1"); err != nil {
+			t.Fatalf("RecordFailure returned error: %v", err)
+		}
+		wait, err := throttle.Check("USER@example.com", "10.0.0.2")
+		if !errors.Is(err, ErrTooManyAttempts) {
+			t.Fatalf("failure %d: expected ErrTooManyAttempts, got %v", i+1, err)
+		}
+		if wait > expected || wait < expected-time.Second/2 {
+			t.Fatalf("failure %d: expected wait of about %s, got %s", i+1, expected, wait)
+		}
+	}
+}
+
+func TestLoginThrottleLockoutNotifiesOnce(t *testing.T) {
+	var notified []string
+	notify := func(email string, until time.Time) {
+		notified = append(notified, email)
+	}
+	policy := testLockoutPolicy()
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptStore(), policy, notify)
+
+	for i := 0; i < policy.AccountThreshold+2; i++ {
+		if err := throttle.RecordFailure("user@example.com", "10.0.0.1"); err != nil {
+			t.Fatalf("RecordFailure returned error: %v", err)
+		}
+	}
+
+	wait, err := throttle.Check("user@example.com", "10.0.0.2")
+	if !errors.Is(err, ErrTooManyAttempts) || wait < policy.LockoutDuration-time.Minute {
+		t.Fatalf("expected account to be locked, got %s (%v)", wait, err)
+	}
+	if len(notified) != 1 || notified[0] != "user@example.com" {
+		t.Fatalf("expected a single lockout notification, got %v", notified)
+	}
+
+	if err := throttle.Unlock("user@example.com"); err != nil {
+		t.Fatalf("Unlock returned error: %v", err)
+	}
+	if _, err := throttle.Check("user@example.com", "10.0.0.2"); err != nil {
+		t.Fatalf("expected unlocked account to be allowed, got %v", err)
+	}
+}
+
+func TestLoginThrottleBlocksIP(t *testing.T) {
+	policy := testLockoutPolicy()
+	policy.IPThreshold = 3
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptStore(), policy, nil)
+
+	emails := []string{"a@example.com", "b@example.com", "c@example.com"}
+	for _, email := range emails {
+		if err := throttle.RecordFailure(email, "10.0.0.1"); err != nil {
+			t.Fatalf("RecordFailure returned error: %v", err)
+		}
+	}
+
+	wait, err := throttle.Check("d@example.com", "10.0.0.1")
+	if !errors.Is(err, ErrTooManyAttempts) || wait < policy.LockoutDuration-time.Minute {
+		t.Fatalf("expected IP to be blocked, got %s (%v)", wait, err)
+	}
+	if _, err := throttle.Check("d@example.com", "10.0.0.2"); err != nil {
+		t.Fatalf("expected other IPs to be allowed, got %v", err)
+	}
+}
+
+func TestLoginThrottleSuccessResetsAccount(t *testing.T) {
+	throttle := NewLoginThrottle(NewMemoryLoginAttemptStore(), testLockoutPolicy(), nil)
+
+	if err := throttle.RecordFailure("user@example.com", "10.0.0.1"); err != nil {
+		t.Fatalf("RecordFailure returned error: %v", err)
+	}
+	if err := throttle.RecordSuccess("user@example.com"); err != nil {
+		t.Fatalf("RecordSuccess returned error: %v", err)
+	}
+	if _, err := throttle.Check("user@example.com", "10.0.0.2"); err != nil {
+		t.Fatalf("expected account to be reset after success, got %v", err)
+	}
+}
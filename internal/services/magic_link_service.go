@@ -0,0 +1,101 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"errors"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+type MagicLinkService interface {
+	// Send emails a sign-in link bound to the returned nonce, which the caller
+	// stores in the requesting browser. A nonce is returned even when no link
+	// is sent, so callers cannot tell whether the account may use links.
+	Send(email string) (string, error)
+	// Login spends the link and returns the user it signs in
+	Login(token, nonce string) (*models.User, error)
+}
+
+type magicLinkService struct {
+	db database.Service
+}
+
+func NewMagicLinkService() MagicLinkService {
+	return &magicLinkService{
+		db: database.New(),
+	}
+}
+
+// magicLinkAllowed reports whether any role of the user allows sign-in links
+func magicLinkAllowed(db *gorm.DB, userID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.Role{}).
+		Joins("JOIN user_has_roles ON user_has_roles.role_id = roles.id").
+		Where("user_has_roles.user_id = ? AND roles.allow_magic_link = ?", userID, true).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (s *magicLinkService) Send(email string) (string, error) {
+	nonce, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	var user models.User
+	err = s.db.GetDB().Where("email = ? AND status = ?", email, "active").First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nonce, nil
+	} else if err != nil {
+		return "", err
+	}
+	allowed, err := magicLinkAllowed(s.db.GetDB(), user.ID)
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		return nonce, nil
+	}
+
+	token, err := NewActionTokenService().IssueBound(user.ID, models.PurposeMagicLogin, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	go func() {
+		if err := utils.SendMagicLinkEmail(user.Email, token); err != nil {
+			log.Printf("failed to send sign-in link to user %d: %v", user.ID, err)
+		}
+	}()
+	return nonce, nil
+}
+
+func (s *magicLinkService) Login(token, nonce string) (*models.User, error) {
+	if token == "" || nonce == "" {
+		return nil, ErrInvalidActionToken
+	}
+	userID, err := NewActionTokenService().ConsumeBound(token, models.PurposeMagicLogin, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// The account may have been disabled or lost the role since the link was sent
+	var user models.User
+	err = s.db.GetDB().Preload("Roles").Where("id = ? AND status = ?", userID, "active").First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidActionToken
+	} else if err != nil {
+		return nil, err
+	}
+	allowed, err := magicLinkAllowed(s.db.GetDB(), user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrInvalidActionToken
+	}
+	return &user, nil
+}
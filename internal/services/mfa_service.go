@@ -0,0 +1,275 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrMFANotEnrolled    = errors.New("two-factor authentication is not enrolled")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFARequiredByRole = errors.New("two-factor authentication is required by one of your roles")
+	ErrInvalidMFACode    = errors.New("invalid authentication code")
+	// ErrMFAEnrollmentNotAllowed is returned when enrolling with only a password
+	// would let someone who knows it replace the user's second factor
+	ErrMFAEnrollmentNotAllowed = errors.New("two-factor authentication can only be enrolled at login when a role requires it and no second factor is set up")
+)
+
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type MFAService interface {
+	Enroll(user *models.User) (*MFAEnrollment, error)
+	Confirm(userID uint, code string) ([]string, error)
+	Verify(userID uint, code string) error
+	IsEnabled(userID uint) (bool, error)
+	IsRequired(userID uint) (bool, error)
+	HasPendingEnrollment(userID uint) (bool, error)
+	// CheckLoginEnrollment returns ErrMFAEnrollmentNotAllowed unless the user
+	// may enroll TOTP with the mfa_token of a login
+	CheckLoginEnrollment(userID uint) error
+	RegenerateRecoveryCodes(userID uint) ([]string, error)
+	Disable(userID uint) error
+}
+
+type mfaService struct {
+	db database.Service
+}
+
+func NewMFAService() MFAService {
+	return &mfaService{
+		db: database.New(),
+	}
+}
+
+// Enroll starts (or restarts) an unconfirmed TOTP enrollment for the user
+func (s *mfaService) Enroll(user *models.User) (*MFAEnrollment, error) {
+	enabled, err := s.IsEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := utils.Encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa := models.UserMFA{UserID: user.ID, Secret: encrypted}
+	err = s.db.GetDB().
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&mfa).Error
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := utils.GetEnv("MFA_ISSUER", "Admin-gin")
+	return &MFAEnrollment{
+		Secret: secret,
+		URI:    utils.TOTPURI(issuer, user.Email, secret),
+	}, nil
+}
+
+// Confirm activates a pending enrollment with a first valid code and returns
+// a fresh set of recovery codes
+func (s *mfaService) Confirm(userID uint, code string) ([]string, error) {
+	mfa, err := s.getMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if err := s.verifyTOTP(mfa, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(mfa).Update("confirmed_at", time.Now()).Error; err != nil {
+			return err
+		}
+		codes, err = s.replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify accepts either a TOTP code or an unused recovery code
+func (s *mfaService) Verify(userID uint, code string) error {
+	mfa, err := s.getMFA(userID)
+	if err != nil {
+		return err
+	}
+	if mfa.ConfirmedAt == nil {
+		return ErrMFANotEnrolled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == utils.TOTPDigits {
+		return s.verifyTOTP(mfa, code)
+	}
+
+	normalized := strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	result := s.db.GetDB().Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, utils.HashToken(normalized)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (s *mfaService) IsEnabled(userID uint) (bool, error) {
+	var count int64
+	err := s.db.GetDB().Model(&models.UserMFA{}).
+		Where("user_id = ? AND confirmed_at IS NOT NULL", userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// IsRequired reports whether any role of the user enforces MFA
+func (s *mfaService) IsRequired(userID uint) (bool, error) {
+	var count int64
+	err := s.db.GetDB().Model(&models.Role{}).
+		Joins("JOIN user_has_roles ON user_has_roles.role_id = roles.id").
+		Where("user_has_roles.user_id = ? AND roles.require_mfa = ?", userID, true).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (s *mfaService) HasPendingEnrollment(userID uint) (bool, error) {
+	var count int64
+	err := s.db.GetDB().Model(&models.UserMFA{}).
+		Where("user_id = ? AND confirmed_at IS NULL", userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (s *mfaService) CheckLoginEnrollment(userID uint) error {
+	totp, err := s.IsEnabled(userID)
+	if err != nil {
+		return err
+	}
+	var passkeys int64
+	if err := s.db.GetDB().Model(&models.Passkey{}).Where("user_id = ?", userID).Count(&passkeys).Error; err != nil {
+		return err
+	}
+	required, err := s.IsRequired(userID)
+	if err != nil {
+		return err
+	}
+	if !loginEnrollmentAllowed(totp, passkeys > 0, required) {
+		return ErrMFAEnrollmentNotAllowed
+	}
+	return nil
+}
+
+// loginEnrollmentAllowed reports whether a user who only proved their password
+// may enroll: only to satisfy a role requiring MFA, and never next to an
+// existing second factor they would otherwise bypass
+func loginEnrollmentAllowed(totp, passkeys, required bool) bool {
+	return required && !totp && !passkeys
+}
+
+func (s *mfaService) RegenerateRecoveryCodes(userID uint) ([]string, error) {
+	enabled, err := s.IsEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrMFANotEnrolled
+	}
+	return s.replaceRecoveryCodes(s.db.GetDB(), userID)
+}
+
+// Disable removes the enrollment and recovery codes, used for opt-out and admin resets
+func (s *mfaService) Disable(userID uint) error {
+	return s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.UserMFA{}).Error
+	})
+}
+
+func (s *mfaService) getMFA(userID uint) (*models.UserMFA, error) {
+	var mfa models.UserMFA
+	err := s.db.GetDB().Where("user_id = ?", userID).First(&mfa).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMFANotEnrolled
+	} else if err != nil {
+		return nil, err
+	}
+	return &mfa, nil
+}
+
+// verifyTOTP validates the code and records its time step so it cannot be replayed
+func (s *mfaService) verifyTOTP(mfa *models.UserMFA, code string) error {
+	secret, err := utils.Decrypt(mfa.Secret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := utils.ValidateTOTP(secret, strings.TrimSpace(code), time.Now())
+	if !ok || step <= mfa.LastUsedStep {
+		return ErrInvalidMFACode
+	}
+
+	result := s.db.GetDB().Model(&models.UserMFA{}).
+		Where("user_id = ? AND last_used_step < ?", mfa.UserID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (s *mfaService) replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		raw, err := utils.GenerateTOTPSecret()
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(raw[:10])
+		codes[i] = code[:5] + "-" + code[5:]
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: utils.HashToken(code)}
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
@@ -0,0 +1,26 @@
+package services
+
+import "testing"
+
+func TestLoginEnrollmentAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		totp     bool
+		passkeys bool
+		required bool
+		allowed  bool
+	}{
+		{"required without a second factor", false, false, true, true},
+		{"not required", false, false, false, false},
+		{"passkey registered", false, true, true, false},
+		{"passkey without requirement", false, true, false, false},
+		{"totp enabled", true, false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loginEnrollmentAllowed(tt.totp, tt.passkeys, tt.required); got != tt.allowed {
+				t.Fatalf("expected %v, got %v", tt.allowed, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrOAuthClientNotFound = errors.New("client not found")
+
+// OAuthError is an error response defined by RFC 6749, e.g. invalid_grant
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// OAuthClientInput registers a client
+type OAuthClientInput struct {
+	Name         string   `json:"name" binding:"required"`
+	Type         string   `json:"type" binding:"required,oneof=confidential public"`
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types" binding:"required,min=1"`
+	Scopes       []string `json:"scopes" binding:"required,min=1"`
+}
+
+// OAuthClientResponse is a client as shown to administrators
+type OAuthClientResponse struct {
+	ID           uint      `json:"id"`
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type OAuthClientService interface {
+	RegisterClient(input *OAuthClientInput) (*OAuthClientResponse, string, error)
+	GetClients() ([]OAuthClientResponse, error)
+	DeleteClient(id uint) error
+	// AuthenticateClient checks the credentials of a client. Public clients
+	// authenticate with their client ID alone.
+	AuthenticateClient(clientID, secret string) (*models.OAuthClient, error)
+}
+
+type oauthClientService struct {
+	db database.Service
+}
+
+func NewOAuthClientService() OAuthClientService {
+	return &oauthClientService{
+		db: database.New(),
+	}
+}
+
+func newOAuthClientResponse(client *models.OAuthClient) OAuthClientResponse {
+	return OAuthClientResponse{
+		ID:           client.ID,
+		ClientID:     client.ClientID,
+		Name:         client.Name,
+		Type:         client.Type,
+		RedirectURIs: client.RedirectURIList(),
+		GrantTypes:   client.GrantTypeList(),
+		Scopes:       client.ScopeList(),
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// RegisterClient creates the client and returns its secret, which is only
+// shown once. Public clients get no secret.
+func (s *oauthClientService) RegisterClient(input *OAuthClientInput) (*OAuthClientResponse, string, error) {
+	for _, grant := range input.GrantTypes {
+		switch grant {
+		case models.GrantAuthorizationCode:
+			if len(input.RedirectURIs) == 0 {
+				return nil, "", oauthError("invalid_redirect_uri", "authorization_code clients need at least one redirect URI")
+			}
+		case models.GrantClientCredentials:
+			if input.Type != models.OAuthClientConfidential {
+				return nil, "", oauthError("invalid_client_metadata", "client_credentials requires a confidential client")
+			}
+		default:
+			return nil, "", oauthError("invalid_client_metadata", "unsupported grant type "+grant)
+		}
+	}
+	for _, uri := range input.RedirectURIs {
+		if !validRedirectURI(uri) || strings.ContainsAny(uri, " \t\n") {
+			return nil, "", oauthError("invalid_redirect_uri", "invalid redirect URI "+uri)
+		}
+	}
+
+	var permissionScopes []string
+	for _, scope := range input.Scopes {
+		if !IsOIDCScope(scope) {
+			permissionScopes = append(permissionScopes, scope)
+		}
+	}
+	if len(permissionScopes) > 0 {
+		var known int64
+		if err := s.db.GetDB().Model(&models.Permission{}).Where("name IN ?", permissionScopes).Count(&known).Error; err != nil {
+			return nil, "", err
+		}
+		if int(known) != len(stringSet(permissionScopes)) {
+			return nil, "", oauthError("invalid_client_metadata", "scopes must be OpenID scopes or existing permission names")
+		}
+	}
+
+	clientID, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+	client := models.OAuthClient{
+		ClientID:     clientID,
+		Name:         input.Name,
+		Type:         input.Type,
+		RedirectURIs: strings.Join(input.RedirectURIs, " "),
+		GrantTypes:   strings.Join(input.GrantTypes, " "),
+		Scopes:       strings.Join(input.Scopes, " "),
+	}
+
+	var secret string
+	if input.Type == models.OAuthClientConfidential {
+		if secret, err = utils.GenerateRandomToken(32); err != nil {
+			return nil, "", err
+		}
+		client.SecretHash = utils.HashToken(secret)
+	}
+
+	if err := s.db.GetDB().Create(&client).Error; err != nil {
+		return nil, "", err
+	}
+	response := newOAuthClientResponse(&client)
+	return &response, secret, nil
+}
+
+func (s *oauthClientService) GetClients() ([]OAuthClientResponse, error) {
+	var clients []models.OAuthClient
+	if err := s.db.GetDB().Where("service_account_id IS NULL").Order("id").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	responses := make([]OAuthClientResponse, len(clients))
+	for i := range clients {
+		responses[i] = newOAuthClientResponse(&clients[i])
+	}
+	return responses, nil
+}
+
+func (s *oauthClientService) DeleteClient(id uint) error {
+	// Clients of service accounts are managed through the service account
+	result := s.db.GetDB().Where("service_account_id IS NULL").Delete(&models.OAuthClient{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOAuthClientNotFound
+	}
+	return nil
+}
+
+func (s *oauthClientService) AuthenticateClient(clientID, secret string) (*models.OAuthClient, error) {
+	client, err := findOAuthClient(s.db, clientID)
+	if err != nil {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+
+	if client.Type == models.OAuthClientPublic {
+		if secret != "" {
+			return nil, oauthError("invalid_client", "public clients have no secret")
+		}
+		return client, nil
+	}
+	if secret == "" || subtle.ConstantTimeCompare([]byte(utils.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+	return client, nil
+}
+
+func findOAuthClient(db database.Service, clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	err := db.GetDB().Where("client_id = ?", clientID).First(&client).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOAuthClientNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	neturl "net/url"
+	"strings"
+)
+
+// OpenID Connect scopes. Every other scope is the name of a permission.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// IsOIDCScope reports whether the scope is an OpenID Connect scope rather than a permission
+func IsOIDCScope(scope string) bool {
+	return scope == ScopeOpenID || scope == ScopeProfile || scope == ScopeEmail
+}
+
+// grantScopes resolves the scopes of an authorization request. Every requested
+// scope must be allowed for the client, otherwise the request is rejected.
+// Permission scopes the grants do not allow on every record are dropped, so a
+// client never gets more than the user could do: denies count, scoped and
+// conditional allows do not.
+func grantScopes(requested, allowed []string, grants []utils.Grant) ([]string, error) {
+	allowedScopes := utils.NewPermissionMatcher(allowed)
+
+	granted := make([]string, 0, len(requested))
+	seen := make(map[string]bool)
+	for _, scope := range requested {
+		if seen[scope] {
+			continue
+		}
+		seen[scope] = true
+
+		if !allowedScopes.Allows(scope) {
+			return nil, oauthError("invalid_scope", "scope "+scope+" is not allowed for this client")
+		}
+		if IsOIDCScope(scope) || holdsScope(grants, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	if len(granted) == 0 {
+		return nil, oauthError("invalid_scope", "none of the requested scopes can be granted")
+	}
+	return granted, nil
+}
+
+// holdsScope reports whether the grants allow the permission scope, or every
+// permission of a scope pattern such as user.*, on every record
+func holdsScope(grants []utils.Grant, scope string) bool {
+	return utils.CoversGrants(grants, []utils.Grant{{Permission: scope}})
+}
+
+// scopeGrants turns the scopes a client was registered with into the grants
+// it acts with
+func scopeGrants(scopes []string) []utils.Grant {
+	grants := make([]utils.Grant, 0, len(scopes))
+	for _, scope := range scopes {
+		grants = append(grants, utils.Grant{Permission: scope})
+	}
+	return grants
+}
+
+// containsAllScopes reports whether every scope of subset is in set or
+// covered by a pattern of set, e.g. user.read and user.* by user.*
+func containsAllScopes(set, subset []string) bool {
+	have := utils.NewPermissionMatcher(set)
+	for _, scope := range subset {
+		if !have.Allows(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeScopes returns the union of both lists, keeping the order of a
+func mergeScopes(a, b []string) []string {
+	merged := append([]string{}, a...)
+	have := stringSet(a)
+	for _, scope := range b {
+		if !have[scope] {
+			have[scope] = true
+			merged = append(merged, scope)
+		}
+	}
+	return merged
+}
+
+// checkCodeRedirect requires the token request to repeat the redirect URI of
+// the authorization code, as RFC 6749 section 4.1.3 does. The URI is always
+// required, even when the authorization request left it to the only
+// registered one.
+func checkCodeRedirect(code *models.OAuthAuthorizationCode, redirectURI string) error {
+	if code.RedirectURI != "" && redirectURI != code.RedirectURI {
+		return oauthError("invalid_grant", "redirect_uri does not match the authorization request")
+	}
+	return nil
+}
+
+// validRedirectURI accepts absolute URIs without fragments. Plain http is only
+// allowed for loopback addresses used during development.
+func validRedirectURI(uri string) bool {
+	parsed, err := neturl.Parse(uri)
+	if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+		return false
+	}
+	switch parsed.Scheme {
+	case "https":
+		return parsed.Host != ""
+	case "http":
+		host := parsed.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		// Private-use schemes of native apps, e.g. com.example.app:/callback
+		return strings.Contains(parsed.Scheme, ".")
+	}
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
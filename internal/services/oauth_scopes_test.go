@@ -0,0 +1,104 @@
+package services
+
+import (
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGrantScopes(t *testing.T) {
+	allowed := []string{"openid", "email", "user.read", "user.update"}
+
+	granted, err := grantScopes([]string{"openid", "user.read", "user.update", "user.read"}, allowed, scopeGrants([]string{"user.read"}))
+	if err != nil {
+		t.Fatalf("grantScopes returned error: %v", err)
+	}
+	if strings.Join(granted, " ") != "openid user.read" {
+		t.Fatalf("expected permissions the user lacks to be dropped, got %v", granted)
+	}
+
+	var oauthErr *OAuthError
+	if _, err := grantScopes([]string{"role.delete"}, allowed, scopeGrants([]string{"role.delete"})); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_scope" {
+		t.Fatalf("expected scopes not allowed for the client to be rejected, got %v", err)
+	}
+	if _, err := grantScopes([]string{"user.update"}, allowed, nil); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_scope" {
+		t.Fatalf("expected a request without grantable scopes to be rejected, got %v", err)
+	}
+}
+
+func TestGrantScopesWithPermissionPatterns(t *testing.T) {
+	allowed := []string{"openid", "user.*", "role.read"}
+
+	granted, err := grantScopes([]string{"openid", "user.read", "user.update", "role.read"}, allowed, scopeGrants([]string{"*.read", "user.update"}))
+	if err != nil {
+		t.Fatalf("grantScopes returned error: %v", err)
+	}
+	if strings.Join(granted, " ") != "openid user.read user.update role.read" {
+		t.Fatalf("expected scopes covered by patterns to be granted, got %v", granted)
+	}
+
+	if !containsAllScopes([]string{"*"}, []string{"user.*", "role.read"}) || containsAllScopes([]string{"user.*"}, []string{"*"}) {
+		t.Fatal("expected * to cover every grant and nothing else to cover *")
+	}
+}
+
+func TestGrantScopesIgnoresDeniedAndScopedGrants(t *testing.T) {
+	grants := []utils.Grant{
+		{Permission: "user.*"},
+		{Permission: "user.delete", Effect: models.GrantEffectDeny},
+		{Permission: "role.read", Scope: models.GrantScopeOwn},
+		{Permission: "role.update", Condition: "request.ip == '10.0.0.1'"},
+	}
+	allowed := []string{"user.*", "role.*"}
+
+	granted, err := grantScopes([]string{"user.read", "user.delete", "user.*", "role.read", "role.update"}, allowed, grants)
+	if err != nil {
+		t.Fatalf("grantScopes returned error: %v", err)
+	}
+	if strings.Join(granted, " ") != "user.read" {
+		t.Fatalf("expected denied, scoped and conditional permissions to be dropped, got %v", granted)
+	}
+}
+
+func TestCheckCodeRedirect(t *testing.T) {
+	code := &models.OAuthAuthorizationCode{RedirectURI: "https://app.example.com/callback"}
+
+	if err := checkCodeRedirect(code, "https://app.example.com/callback"); err != nil {
+		t.Fatalf("expected the same redirect_uri to be accepted, got %v", err)
+	}
+	var oauthErr *OAuthError
+	for _, uri := range []string{"", "https://app.example.com/other"} {
+		if err := checkCodeRedirect(code, uri); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+			t.Fatalf("expected redirect_uri %q to be rejected, got %v", uri, err)
+		}
+	}
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	cases := map[string]bool{
+		"https://app.example.com/callback":   true,
+		"http://localhost:3000/callback":     true,
+		"http://127.0.0.1/callback":          true,
+		"com.example.app:/oauth/callback":    true,
+		"http://app.example.com/callback":    false,
+		"https://app.example.com/cb#section": false,
+		"/relative/callback":                 false,
+		"javascript:alert(1)":                false,
+	}
+	for uri, want := range cases {
+		if got := validRedirectURI(uri); got != want {
+			t.Errorf("validRedirectURI(%q) = %v, want %v", uri, got, want)
+		}
+	}
+}
+
+func TestRedirectWithParams(t *testing.T) {
+	target := redirectWithParams("https://app.example.com/cb?tenant=1", map[string][]string{"code": {"abc"}}, "xyz")
+	for _, part := range []string{"tenant=1", "code=abc", "state=xyz", "iss="} {
+		if !strings.Contains(target, part) {
+			t.Errorf("expected %q in %s", part, target)
+		}
+	}
+}
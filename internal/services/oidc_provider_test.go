@@ -0,0 +1,354 @@
+package services
+
+import (
+	"Admin-gin/internal/utils"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// mockOIDCProvider is a minimal OpenID provider serving discovery, JWKS, an
+// authorization endpoint that approves every request and a token endpoint
+// that enforces PKCE and client authentication
+type mockOIDCProvider struct {
+	t            *testing.T
+	server       *httptest.Server
+	key          *rsa.PrivateKey
+	clientID     string
+	clientSecret string
+
+	// claims lets a test alter the ID token before it is signed
+	claims func(jwt.MapClaims)
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDCProvider{
+		t:            t,
+		key:          key,
+		clientID:     "admin-gin",
+		clientSecret: "client-secret",
+		codes:        make(map[string]mockAuthorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(utils.JWKSet{Keys: []utils.JWK{{
+			KeyType:   "RSA",
+			KeyID:     "mock-key",
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("client_id") != m.clientID ||
+		query.Get("code_challenge_method") != "S256" || !strings.Contains(query.Get("scope"), "openid") {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := "code-" + query.Get("state")
+	m.mu.Lock()
+	m.codes[code] = mockAuthorization{
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		redirectURI: query.Get("redirect_uri"),
+	}
+	m.mu.Unlock()
+
+	redirect, _ := neturl.Parse(query.Get("redirect_uri"))
+	redirect.RawQuery = neturl.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (m *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != m.clientID || secret != m.clientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	m.mu.Lock()
+	authorization, found := m.codes[r.PostFormValue("code")]
+	delete(m.codes, r.PostFormValue("code"))
+	m.mu.Unlock()
+	if !found || r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != authorization.redirectURI ||
+		PKCEChallenge(r.PostFormValue("code_verifier")) != authorization.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            m.server.URL,
+		"sub":            "mock-subject",
+		"aud":            m.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          authorization.nonce,
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"name":           "Jane Doe",
+	}
+	if m.claims != nil {
+		m.claims(claims)
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "mock-key"
+	signed, err := idToken.SignedString(m.key)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"id_token":     signed,
+		"expires_in":   60,
+	})
+}
+
+func (m *mockOIDCProvider) relyingParty() *OIDCProvider {
+	return NewOIDCProvider(OIDCProviderConfig{
+		Name:         "mock",
+		Issuer:       m.server.URL,
+		ClientID:     m.clientID,
+		ClientSecret: m.clientSecret,
+		RedirectURL:  "http://localhost:5000/api/auth/mock/callback",
+	}, m.server.Client())
+}
+
+// login follows the authorization request to the mock and returns the code
+// and state it redirects back with
+func (m *mockOIDCProvider) login(t *testing.T, authURL string) (string, string) {
+	client := *m.server.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected the provider to redirect back, got %d", resp.StatusCode)
+	}
+
+	location, err := neturl.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	challenge := PKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if challenge != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Fatalf("unexpected challenge %q", challenge)
+	}
+}
+
+func TestOIDCProviderAuthorizationCodeFlow(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := mock.relyingParty()
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-0123456789-0123456789-0123456789")
+	if err != nil {
+		t.Fatalf("AuthCodeURL returned error: %v", err)
+	}
+	code, state := mock.login(t, authURL)
+	if state != "state-1" {
+		t.Fatalf("expected state to round trip, got %q", state)
+	}
+
+	token, err := provider.Exchange(ctx, code, "verifier-0123456789-0123456789-0123456789")
+	if err != nil {
+		t.Fatalf("Exchange returned error: %v", err)
+	}
+	claims, err := provider.VerifyIDToken(ctx, token.IDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken returned error: %v", err)
+	}
+	if claims.Subject != "mock-subject" || claims.Email != "jane@example.com" || !bool(claims.EmailVerified) || claims.Name != "Jane Doe" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+}
+
+func TestOIDCProviderRejectsWrongCodeVerifier(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := mock.relyingParty()
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "the-right-verifier-0123456789-0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := mock.login(t, authURL)
+
+	if _, err := provider.Exchange(ctx, code, "a-stolen-code-without-the-verifier-01234"); err == nil {
+		t.Fatal("expected the exchange to fail without the PKCE verifier")
+	}
+}
+
+func TestOIDCProviderVerifyIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		nonce  string
+		claims func(jwt.MapClaims)
+		valid  bool
+	}{
+		{name: "valid", nonce: "nonce-1", valid: true},
+		{name: "nonce mismatch", nonce: "other-nonce"},
+		{name: "wrong audience", nonce: "nonce-1", claims: func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+		{name: "wrong issuer", nonce: "nonce-1", claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", nonce: "nonce-1", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "missing subject", nonce: "nonce-1", claims: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{name: "foreign azp", nonce: "nonce-1", claims: func(c jwt.MapClaims) {
+			c["aud"] = []string{"admin-gin", "other-client"}
+			c["azp"] = "other-client"
+		}},
+		{name: "string email_verified", nonce: "nonce-1", valid: true, claims: func(c jwt.MapClaims) { c["email_verified"] = "true" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := newMockOIDCProvider(t)
+			mock.claims = tt.claims
+			provider := mock.relyingParty()
+			ctx := context.Background()
+
+			authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-0123456789-0123456789-0123456789")
+			if err != nil {
+				t.Fatal(err)
+			}
+			code, _ := mock.login(t, authURL)
+			token, err := provider.Exchange(ctx, code, "verifier-0123456789-0123456789-0123456789")
+			if err != nil {
+				t.Fatalf("Exchange returned error: %v", err)
+			}
+
+			claims, err := provider.VerifyIDToken(ctx, token.IDToken, tt.nonce)
+			if tt.valid && (err != nil || !bool(claims.EmailVerified)) {
+				t.Fatalf("expected a valid id token, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("expected the id token to be rejected")
+			}
+		})
+	}
+}
+
+func TestOIDCProviderRejectsForgedSignature(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := mock.relyingParty()
+	ctx := context.Background()
+
+	forger, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   mock.server.URL,
+		"sub":   "victim",
+		"aud":   mock.clientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": "nonce-1",
+	})
+	forged.Header["kid"] = "mock-key"
+	signed, err := forged.SignedString(forger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.VerifyIDToken(ctx, signed, "nonce-1"); err == nil {
+		t.Fatal("expected an id token signed with another key to be rejected")
+	}
+}
+
+func TestLoadOIDCProvidersFromEnv(t *testing.T) {
+	t.Setenv("URL", "http://localhost:5000")
+	t.Setenv("OIDC_PROVIDERS", "Google, my-idp")
+	t.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "google-client")
+	t.Setenv("OIDC_MY_IDP_ISSUER", "https://idp.example.com")
+	t.Setenv("OIDC_MY_IDP_CLIENT_ID", "my-client")
+	t.Setenv("OIDC_MY_IDP_SCOPES", "openid email")
+
+	providers, err := LoadOIDCProvidersFromEnv()
+	if err != nil {
+		t.Fatalf("LoadOIDCProvidersFromEnv returned error: %v", err)
+	}
+	idp, ok := providers["my-idp"]
+	if !ok || len(providers) != 2 {
+		t.Fatalf("expected google and my-idp, got %v", providers)
+	}
+	if idp.config.RedirectURL != "http://localhost:5000/api/auth/my-idp/callback" || len(idp.config.Scopes) != 2 {
+		t.Fatalf("unexpected config %+v", idp.config)
+	}
+
+	t.Setenv("OIDC_MY_IDP_CLIENT_ID", "")
+	if _, err := LoadOIDCProvidersFromEnv(); err == nil {
+		t.Fatal("expected a provider without a client ID to be rejected")
+	}
+}
+
+func TestLinkedUserError(t *testing.T) {
+	if err := linkedUserError(gorm.ErrRecordNotFound, ErrOIDCLoginFailed); !errors.Is(err, ErrOIDCLoginFailed) {
+		t.Fatalf("expected an inactive or deleted linked user to fail the login, got %v", err)
+	}
+	if err := linkedUserError(nil, ErrOIDCLoginFailed); err != nil {
+		t.Fatalf("expected an active linked user to be accepted, got %v", err)
+	}
+	unavailable := errors.New("database unavailable")
+	if err := linkedUserError(unavailable, ErrOIDCLoginFailed); !errors.Is(err, unavailable) {
+		t.Fatalf("expected other errors to be kept, got %v", err)
+	}
+}
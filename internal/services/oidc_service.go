@@ -0,0 +1,233 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OIDCLoginStateTTL bounds the time a user may spend at the identity provider
+const OIDCLoginStateTTL = 10 * time.Minute
+
+var (
+	ErrUnknownOIDCProvider    = errors.New("unknown identity provider")
+	ErrInvalidOIDCState       = errors.New("invalid or expired login state")
+	ErrOIDCLoginFailed        = errors.New("identity provider login failed")
+	ErrOIDCEmailNotVerified   = errors.New("the identity provider has not verified this email address")
+	ErrOIDCAccountNotVerified = errors.New("an account with this email exists but is not verified, please verify it first")
+)
+
+// OIDCLogin is an authorization request started by Start. State must be bound
+// to the browser, e.g. in a cookie, and compared on the callback.
+type OIDCLogin struct {
+	AuthURL string
+	State   string
+}
+
+type OIDCService interface {
+	Start(ctx context.Context, provider string) (*OIDCLogin, error)
+	// Callback finishes the login and returns the linked or provisioned user
+	// with Roles preloaded
+	Callback(ctx context.Context, provider, state, code string) (*models.User, error)
+}
+
+type oidcService struct {
+	db        database.Service
+	providers map[string]*OIDCProvider
+}
+
+func NewOIDCService() OIDCService {
+	// Provider configuration is validated when the server starts
+	providers, _ := OIDCProviders()
+	return &oidcService{
+		db:        database.New(),
+		providers: providers,
+	}
+}
+
+// OIDCDefaultRole is the role given to users provisioned on their first
+// external login, configured with OIDC_DEFAULT_ROLE
+func OIDCDefaultRole() string {
+	return utils.GetEnv("OIDC_DEFAULT_ROLE", "user")
+}
+
+func (s *oidcService) Start(ctx context.Context, providerName string) (*OIDCLogin, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+
+	state, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	loginState := models.OIDCLoginState{
+		StateHash:    utils.HashToken(state),
+		Provider:     providerName,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(OIDCLoginStateTTL),
+	}
+	if err := s.db.GetDB().Create(&loginState).Error; err != nil {
+		return nil, err
+	}
+
+	return &OIDCLogin{AuthURL: authURL, State: state}, nil
+}
+
+func (s *oidcService) Callback(ctx context.Context, providerName, state, code string) (*models.User, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+
+	loginState, err := s.consumeState(providerName, state)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := provider.Exchange(ctx, code, loginState.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC code exchange with %s failed: %v", providerName, err)
+		return nil, ErrOIDCLoginFailed
+	}
+	claims, err := provider.VerifyIDToken(ctx, token.IDToken, loginState.Nonce)
+	if err != nil {
+		log.Printf("OIDC id token from %s rejected: %v", providerName, err)
+		return nil, ErrOIDCLoginFailed
+	}
+
+	return s.resolveUser(providerName, claims)
+}
+
+// consumeState deletes the login state so each authorization response is only accepted once
+func (s *oidcService) consumeState(providerName, state string) (*models.OIDCLoginState, error) {
+	var loginState models.OIDCLoginState
+	err := s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("state_hash = ?", utils.HashToken(state)).
+			First(&loginState).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidOIDCState
+		} else if err != nil {
+			return err
+		}
+		return tx.Delete(&loginState).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if loginState.Provider != providerName || time.Now().After(loginState.ExpiresAt) {
+		return nil, ErrInvalidOIDCState
+	}
+	return &loginState, nil
+}
+
+// resolveUser finds the user linked to the external identity. An identity seen
+// for the first time is linked to the account with the same, provider verified,
+// email, or a new active account is provisioned with the default role.
+func (s *oidcService) resolveUser(providerName string, claims *OIDCClaims) (*models.User, error) {
+	var user models.User
+	err := s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var identity models.ExternalIdentity
+		err := tx.Where("provider = ? AND subject = ?", providerName, claims.Subject).First(&identity).Error
+		if err == nil {
+			err := tx.Where("id = ? AND status = ?", identity.UserID, "active").First(&user).Error
+			return linkedUserError(err, ErrOIDCLoginFailed)
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		email := strings.TrimSpace(claims.Email)
+		if email == "" || !bool(claims.EmailVerified) {
+			return ErrOIDCEmailNotVerified
+		}
+
+		err = tx.Where("LOWER(email) = LOWER(?)", email).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := provisionUser(tx, &user, email, claims.Name, OIDCDefaultRole()); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else if user.Status != "active" {
+			// The local password was never proven to belong to the email owner
+			return ErrOIDCAccountNotVerified
+		}
+
+		return tx.Create(&models.ExternalIdentity{
+			UserID:   user.ID,
+			Provider: providerName,
+			Subject:  claims.Subject,
+			Email:    email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.GetDB().Preload("Roles").First(&user, user.ID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// linkedUserError turns a missing linked account, one that was deleted or is
+// no longer active, into the failed login error of the provider
+func linkedUserError(err, failed error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return failed
+	}
+	return err
+}
+
+// provisionUser creates an active account without a usable password holding
+// the given role, if any
+func provisionUser(tx *gorm.DB, user *models.User, email, name, roleName string) error {
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	*user = models.User{
+		Name:   name,
+		Email:  email,
+		Status: "active",
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+
+	if roleName == "" {
+		return nil
+	}
+	var role models.Role
+	if err := tx.Where("name = ?", roleName).First(&role).Error; err != nil {
+		return err
+	}
+	return tx.Create(&models.UserHasRole{UserID: user.ID, RoleID: role.ID}).Error
+}
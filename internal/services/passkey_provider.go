@@ -0,0 +1,234 @@
+package services
+
+import (
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"encoding/binary"
+	"errors"
+	"log"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var ErrPasskeyVerificationFailed = errors.New("passkey verification failed")
+
+// PasskeyUserHandle is the WebAuthn user handle of a user, the big-endian ID.
+// It is stored by the authenticator with discoverable credentials and sent
+// back on login so the account can be found without a username.
+func PasskeyUserHandle(userID uint) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+func passkeyUserID(handle []byte) (uint, bool) {
+	if len(handle) != 8 {
+		return 0, false
+	}
+	id := binary.BigEndian.Uint64(handle)
+	return uint(id), id != 0
+}
+
+// PasskeyUser adapts a user and their registered credentials to webauthn.User
+type PasskeyUser struct {
+	User        *models.User
+	Credentials []webauthn.Credential
+}
+
+func (u *PasskeyUser) WebAuthnID() []byte {
+	return PasskeyUserHandle(u.User.ID)
+}
+
+func (u *PasskeyUser) WebAuthnName() string {
+	return u.User.Email
+}
+
+func (u *PasskeyUser) WebAuthnDisplayName() string {
+	if u.User.Name != "" {
+		return u.User.Name
+	}
+	return u.User.Email
+}
+
+func (u *PasskeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.Credentials
+}
+
+// PasskeyRelyingParty runs the WebAuthn registration and login ceremonies.
+// The SessionData returned by the Begin methods must be kept server side and
+// passed to the matching Finish method.
+type PasskeyRelyingParty struct {
+	webauthn *webauthn.WebAuthn
+}
+
+func NewPasskeyRelyingParty(config *webauthn.Config) (*PasskeyRelyingParty, error) {
+	w, err := webauthn.New(config)
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyRelyingParty{webauthn: w}, nil
+}
+
+// BeginRegistration asks for a discoverable credential, so the passkey can be
+// used to log in without a username, excluding the ones already registered
+func (p *PasskeyRelyingParty) BeginRegistration(user *PasskeyUser) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	return p.webauthn.BeginRegistration(user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(webauthn.Credentials(user.Credentials).CredentialDescriptors()),
+		webauthn.WithConveyancePreference(protocol.PreferNoAttestation),
+	)
+}
+
+// FinishRegistration verifies the authenticator's response to BeginRegistration
+// and returns the new credential
+func (p *PasskeyRelyingParty) FinishRegistration(user *PasskeyUser, session *webauthn.SessionData, response []byte) (*webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, passkeyVerificationFailed(err)
+	}
+	credential, err := p.webauthn.CreateCredential(user, *session, parsed)
+	if err != nil {
+		return nil, passkeyVerificationFailed(err)
+	}
+	return credential, nil
+}
+
+// BeginLogin starts a username-less login. User verification is required, as
+// the passkey replaces both the password and the second factor.
+func (p *PasskeyRelyingParty) BeginLogin() (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	return p.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+}
+
+// FinishLogin verifies the assertion answering BeginLogin. lookup loads the
+// user identified by the returned user handle together with their credentials;
+// errors other than ErrPasskeyVerificationFailed are returned unchanged.
+func (p *PasskeyRelyingParty) FinishLogin(session *webauthn.SessionData, response []byte, lookup func(userID uint) (*PasskeyUser, error)) (*PasskeyUser, *webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, nil, passkeyVerificationFailed(err)
+	}
+
+	var lookupErr error
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, ok := passkeyUserID(userHandle)
+		if !ok {
+			lookupErr = ErrPasskeyVerificationFailed
+			return nil, lookupErr
+		}
+		var user *PasskeyUser
+		user, lookupErr = lookup(userID)
+		if lookupErr != nil {
+			return nil, lookupErr
+		}
+		return user, nil
+	}
+	user, credential, err := p.webauthn.ValidatePasskeyLogin(handler, *session, parsed)
+	if lookupErr != nil && !errors.Is(lookupErr, ErrPasskeyVerificationFailed) {
+		return nil, nil, lookupErr
+	}
+	if err != nil {
+		return nil, nil, passkeyVerificationFailed(err)
+	}
+	if err := checkSignCount(credential); err != nil {
+		return nil, nil, err
+	}
+	return user.(*PasskeyUser), credential, nil
+}
+
+// BeginSecondFactor starts a login restricted to the credentials of a user
+// who has already entered their password
+func (p *PasskeyRelyingParty) BeginSecondFactor(user *PasskeyUser) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	return p.webauthn.BeginLogin(user, webauthn.WithUserVerification(protocol.VerificationPreferred))
+}
+
+// FinishSecondFactor verifies the assertion answering BeginSecondFactor
+func (p *PasskeyRelyingParty) FinishSecondFactor(user *PasskeyUser, session *webauthn.SessionData, response []byte) (*webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, passkeyVerificationFailed(err)
+	}
+	credential, err := p.webauthn.ValidateLogin(user, *session, parsed)
+	if err != nil {
+		return nil, passkeyVerificationFailed(err)
+	}
+	if err := checkSignCount(credential); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// checkSignCount rejects an assertion whose signature counter did not increase,
+// a sign that the credential's private key was cloned
+func checkSignCount(credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		log.Printf("passkey rejected: signature counter went backwards for credential %x", credential.ID)
+		return ErrPasskeyVerificationFailed
+	}
+	return nil
+}
+
+func passkeyVerificationFailed(err error) error {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) && protocolErr.DevInfo != "" {
+		log.Printf("passkey rejected: %v: %s", err, protocolErr.DevInfo)
+	} else {
+		log.Printf("passkey rejected: %v", err)
+	}
+	return ErrPasskeyVerificationFailed
+}
+
+var (
+	passkeyRelyingParty     *PasskeyRelyingParty
+	passkeyRelyingPartyErr  error
+	passkeyRelyingPartyOnce sync.Once
+)
+
+// DefaultPasskeyRelyingParty returns the process wide relying party
+func DefaultPasskeyRelyingParty() (*PasskeyRelyingParty, error) {
+	passkeyRelyingPartyOnce.Do(func() {
+		config, err := LoadWebAuthnConfigFromEnv()
+		if err != nil {
+			passkeyRelyingPartyErr = err
+			return
+		}
+		passkeyRelyingParty, passkeyRelyingPartyErr = NewPasskeyRelyingParty(config)
+	})
+	return passkeyRelyingParty, passkeyRelyingPartyErr
+}
+
+// LoadWebAuthnConfigFromEnv reads the relying party from WEBAUTHN_RP_ID, which
+// defaults to the host of URL, WEBAUTHN_RP_NAME and the comma separated origins
+// the browser may run the ceremonies on from WEBAUTHN_RP_ORIGINS, which default
+// to URL.
+func LoadWebAuthnConfigFromEnv() (*webauthn.Config, error) {
+	baseURL := strings.TrimSuffix(utils.GetEnv("URL", "http://localhost:5000"), "/")
+	parsed, err := neturl.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(utils.GetEnv("WEBAUTHN_RP_ORIGINS", baseURL), ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		return nil, errors.New("WEBAUTHN_RP_ORIGINS must list at least one origin")
+	}
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = parsed.Hostname()
+	}
+	return &webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: utils.GetEnv("WEBAUTHN_RP_NAME", utils.GetEnv("MFA_ISSUER", "Admin-gin")),
+		RPOrigins:     origins,
+	}, nil
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"Admin-gin/internal/models"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/descope/virtualwebauthn"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var testPasskeyRP = virtualwebauthn.RelyingParty{Name: "Admin-gin", ID: "example.com", Origin: "https://app.example.com"}
+
+func newTestPasskeyRelyingParty(t *testing.T) *PasskeyRelyingParty {
+	rp, err := NewPasskeyRelyingParty(&webauthn.Config{
+		RPID:          testPasskeyRP.ID,
+		RPDisplayName: testPasskeyRP.Name,
+		RPOrigins:     []string{testPasskeyRP.Origin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+// softwareAuthenticator holds passkeys for a single user, like a phone or a
+// password manager would
+type softwareAuthenticator struct {
+	t             *testing.T
+	authenticator virtualwebauthn.Authenticator
+	credentials   []virtualwebauthn.Credential
+}
+
+func newSoftwareAuthenticator(t *testing.T, user *models.User, options virtualwebauthn.AuthenticatorOptions) *softwareAuthenticator {
+	options.UserHandle = PasskeyUserHandle(user.ID)
+	return &softwareAuthenticator{t: t, authenticator: virtualwebauthn.NewAuthenticatorWithOptions(options)}
+}
+
+func toJSON(t *testing.T, v interface{}) string {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(encoded)
+}
+
+// register creates a new credential for the registration options and returns
+// the response navigator.credentials.create would
+func (a *softwareAuthenticator) register(options interface{}) []byte {
+	parsed, err := virtualwebauthn.ParseAttestationOptions(toJSON(a.t, options))
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	credential := virtualwebauthn.NewCredential(virtualwebauthn.KeyTypeEC2)
+	for _, existing := range a.credentials {
+		if existing.IsExcludedForAttestation(*parsed) {
+			a.t.Fatal("the authenticator already holds a credential for this account")
+		}
+	}
+	response := virtualwebauthn.CreateAttestationResponse(testPasskeyRP, a.authenticator, credential, *parsed)
+	a.credentials = append(a.credentials, credential)
+	a.authenticator.AddCredential(credential)
+	return []byte(response)
+}
+
+// login signs the assertion options with the first allowed credential, or the
+// latest one for a discoverable login, and bumps its signature counter
+func (a *softwareAuthenticator) login(options interface{}) []byte {
+	parsed, err := virtualwebauthn.ParseAssertionOptions(toJSON(a.t, options))
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	index := len(a.credentials) - 1
+	if len(parsed.AllowCredentials) > 0 {
+		index = -1
+		for i := range a.credentials {
+			if a.credentials[i].IsAllowedForAssertion(*parsed) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			a.t.Fatal("no allowed credential on the authenticator")
+		}
+	}
+	a.credentials[index].Counter++
+	return []byte(virtualwebauthn.CreateAssertionResponse(testPasskeyRP, a.authenticator, a.credentials[index], *parsed))
+}
+
+func registerTestPasskey(t *testing.T, rp *PasskeyRelyingParty, user *PasskeyUser, authenticator *softwareAuthenticator) {
+	options, session, err := rp.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := rp.FinishRegistration(user, session, authenticator.register(options))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Credentials = append(user.Credentials, *credential)
+}
+
+func lookupTestUsers(users ...*PasskeyUser) func(userID uint) (*PasskeyUser, error) {
+	return func(userID uint) (*PasskeyUser, error) {
+		for _, user := range users {
+			if user.User.ID == userID {
+				return user, nil
+			}
+		}
+		return nil, ErrPasskeyVerificationFailed
+	}
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	rp := newTestPasskeyRelyingParty(t)
+	alice := &PasskeyUser{User: &models.User{ID: 7, Email: "alice@example.com", Name: "Alice"}}
+	bob := &PasskeyUser{User: &models.User{ID: 8, Email: "bob@example.com", Name: "Bob"}}
+	authenticator := newSoftwareAuthenticator(t, alice.User, virtualwebauthn.AuthenticatorOptions{})
+	registerTestPasskey(t, rp, alice, authenticator)
+
+	options, session, err := rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, credential, err := rp.FinishLogin(session, authenticator.login(options), lookupTestUsers(bob, alice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.User.ID != alice.User.ID {
+		t.Fatalf("expected to log in as alice, got user %d", user.User.ID)
+	}
+	if !bytes.Equal(credential.ID, alice.Credentials[0].ID) || credential.Authenticator.SignCount != 1 {
+		t.Fatalf("expected the registered credential with sign count 1, got %+v", credential)
+	}
+}
+
+func TestPasskeyRegistrationExcludesExistingCredentials(t *testing.T) {
+	rp := newTestPasskeyRelyingParty(t)
+	alice := &PasskeyUser{User: &models.User{ID: 7, Email: "alice@example.com"}}
+	registerTestPasskey(t, rp, alice, newSoftwareAuthenticator(t, alice.User, virtualwebauthn.AuthenticatorOptions{}))
+	registerTestPasskey(t, rp, alice, newSoftwareAuthenticator(t, alice.User, virtualwebauthn.AuthenticatorOptions{}))
+
+	options, _, err := rp.BeginRegistration(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := virtualwebauthn.ParseAttestationOptions(toJSON(t, options))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.ExcludeCredentials) != 2 {
+		t.Fatalf("expected both registered credentials to be excluded, got %v", parsed.ExcludeCredentials)
+	}
+	if parsed.UserID != string(PasskeyUserHandle(7)) || parsed.RelyingPartyID != testPasskeyRP.ID {
+		t.Fatalf("unexpected registration options %+v", parsed)
+	}
+}
+
+func TestPasskeyLoginRequiresUserVerification(t *testing.T) {
+	rp := newTestPasskeyRelyingParty(t)
+	alice := &PasskeyUser{User: &models.User{ID: 7, Email: "alice@example.com"}}
+	// A security key without a PIN can register, but only as a second factor
+	authenticator := newSoftwareAuthenticator(t, alice.User, virtualwebauthn.AuthenticatorOptions{UserNotVerified: true})
+	registerTestPasskey(t, rp, alice, authenticator)
+
+	options, session, err := rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = rp.FinishLogin(session, authenticator.login(options), lookupTestUsers(alice))
+	if !errors.Is(err, ErrPasskeyVerificationFailed) {
+		t.Fatalf("expected ErrPasskeyVerificationFailed, got %v", err)
+	}
+
+	options, session, err = rp.BeginSecondFactor(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.FinishSecondFactor(alice, session, authenticator.login(options)); err != nil {
+		t.Fatalf("expected the passkey to be accepted as second factor, got %v", err)
+	}
+}
+
+func TestPasskeyLoginRejectsOtherOrigin(t *testing.T) {
+	rp := newTestPasskeyRelyingParty(t)
+	alice := &PasskeyUser{User: &models.User{ID: 7, Email: "alice@example.com"}}
+	authenticator := newSoftwareAuthenticator(t, alice.User, virtualwebauthn.AuthenticatorOptions{})
+	registerTestPasskey(t, rp, alice, authenticator)
+
+	options, session, err := rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := virtualwebauthn.ParseAssertionOptions(toJSON(t, options))
+	if err != nil {
+		t.Fatal(err)
+	}
+	phishing := virtualwebauthn.RelyingParty{Name: testPasskeyRP.Name, ID: testPasskeyRP.ID, Origin: "https://app.example.com.evil.test"}
+	response := virtualwebauthn.CreateAssertionResponse(phishing, authenticator.authenticator, authenticator.credentials[0], *parsed)
+	_, _, err = rp.FinishLogin(session, []byte(response), lookupTestUsers(alice))
+	if !errors.Is(err, ErrPasskeyVerificationFailed) {
+		t.Fatalf("expected ErrPasskeyVerificationFailed, got %v", err)
+	}
+}
+
+func TestPasskeyLoginRejectsOtherChallenge(t *testing.T) {
+	rp := newTestPasskeyRelyingParty(t)
+	alice := &PasskeyUser{User: &models.User{ID: 7, Email: "alice@example.com"}}
+	authenticator := newSoftwareAuthenticator(t, alice.User, virtualwebauthn.AuthenticatorOptions{})
+	registerTestPasskey(t, rp, alice, authenticator)
+
+	options, _, err := rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherSession, err := rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = rp.FinishLogin(otherSession, authenticator.login(options), lookupTestUsers(alice))
+	if !errors.Is(err, ErrPasskeyVerificationFailed) {
+		t.Fatalf("expected ErrPasskeyVerificationFailed, got %v", err)
+	}
+}
+
+func TestPasskeyLoginRejectsClonedAuthenticator(t *testing.T) {
+	rp := newTestPasskeyRelyingParty(t)
+	alice := &PasskeyUser{User: &models.User{ID: 7, Email: "alice@example.com"}}
+	authenticator := newSoftwareAuthenticator(t, alice.User, virtualwebauthn.AuthenticatorOptions{})
+	registerTestPasskey(t, rp, alice, authenticator)
+	authenticator.credentials[0].Counter = 10
+
+	options, session, err := rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, credential, err := rp.FinishLogin(session, authenticator.login(options), lookupTestUsers(alice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice.Credentials[0] = *credential
+
+	// A copy of the key that has signed fewer times than the original
+	authenticator.credentials[0].Counter = 3
+	options, session, err = rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = rp.FinishLogin(session, authenticator.login(options), lookupTestUsers(alice))
+	if !errors.Is(err, ErrPasskeyVerificationFailed) {
+		t.Fatalf("expected ErrPasskeyVerificationFailed, got %v", err)
+	}
+}
+
+func TestPasskeySecondFactorRejectsOtherUsersPasskey(t *testing.T) {
+	rp := newTestPasskeyRelyingParty(t)
+	alice := &PasskeyUser{User: &models.User{ID: 7, Email: "alice@example.com"}}
+	bob := &PasskeyUser{User: &models.User{ID: 8, Email: "bob@example.com"}}
+	registerTestPasskey(t, rp, alice, newSoftwareAuthenticator(t, alice.User, virtualwebauthn.AuthenticatorOptions{}))
+	bobsAuthenticator := newSoftwareAuthenticator(t, bob.User, virtualwebauthn.AuthenticatorOptions{})
+	registerTestPasskey(t, rp, bob, bobsAuthenticator)
+
+	options, session, err := rp.BeginSecondFactor(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := virtualwebauthn.ParseAssertionOptions(toJSON(t, options))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.AllowCredentials) != 1 || parsed.AllowCredentials[0] != base64.RawURLEncoding.EncodeToString(alice.Credentials[0].ID) {
+		t.Fatalf("expected only alice's passkey to be allowed, got %v", parsed.AllowCredentials)
+	}
+
+	response := virtualwebauthn.CreateAssertionResponse(testPasskeyRP, bobsAuthenticator.authenticator, bobsAuthenticator.credentials[0], *parsed)
+	if _, err := rp.FinishSecondFactor(alice, session, []byte(response)); !errors.Is(err, ErrPasskeyVerificationFailed) {
+		t.Fatalf("expected ErrPasskeyVerificationFailed, got %v", err)
+	}
+}
+
+func TestPasskeyLoginReturnsLookupErrors(t *testing.T) {
+	rp := newTestPasskeyRelyingParty(t)
+	alice := &PasskeyUser{User: &models.User{ID: 7, Email: "alice@example.com"}}
+	authenticator := newSoftwareAuthenticator(t, alice.User, virtualwebauthn.AuthenticatorOptions{})
+	registerTestPasskey(t, rp, alice, authenticator)
+
+	options, session, err := rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unavailable := errors.New("database unavailable")
+	_, _, err = rp.FinishLogin(session, authenticator.login(options), func(uint) (*PasskeyUser, error) {
+		return nil, unavailable
+	})
+	if !errors.Is(err, unavailable) {
+		t.Fatalf("expected the lookup error, got %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WebAuthnSessionTTL bounds the time between the begin and finish requests of
+// a passkey ceremony
+const WebAuthnSessionTTL = 5 * time.Minute
+
+const defaultPasskeyName = "Passkey"
+
+const (
+	webauthnPurposeRegister = "register"
+	webauthnPurposeLogin    = "login"
+	webauthnPurposeMFA      = "mfa"
+)
+
+var (
+	ErrPasskeyNotFound        = errors.New("passkey not found")
+	ErrNoPasskeys             = errors.New("no passkeys are registered")
+	ErrInvalidPasskeyName     = errors.New("name must be between 1 and 100 characters")
+	ErrInvalidWebAuthnSession = errors.New("invalid or expired passkey session")
+)
+
+// PasskeyChallenge is returned by the begin step of a ceremony. Options are
+// passed to navigator.credentials.create or .get and Session is sent back with
+// the authenticator's response.
+type PasskeyChallenge struct {
+	Session string      `json:"session"`
+	Options interface{} `json:"options"`
+}
+
+type PasskeyService interface {
+	BeginRegistration(userID uint) (*PasskeyChallenge, error)
+	FinishRegistration(userID uint, session, name string, response []byte) (*models.Passkey, error)
+	// BeginLogin and FinishLogin log in with a discoverable passkey alone
+	BeginLogin() (*PasskeyChallenge, error)
+	FinishLogin(session string, response []byte) (*models.User, error)
+	// BeginSecondFactor and FinishSecondFactor verify a passkey of a user who
+	// has already entered their password
+	BeginSecondFactor(userID uint) (*PasskeyChallenge, error)
+	FinishSecondFactor(userID uint, session string, response []byte) error
+	GetPasskeys(userID uint) ([]models.Passkey, error)
+	HasPasskeys(userID uint) (bool, error)
+	RenamePasskey(userID, id uint, name string) (*models.Passkey, error)
+	DeletePasskey(userID, id uint) error
+}
+
+type passkeyService struct {
+	db           database.Service
+	relyingParty *PasskeyRelyingParty
+	mfa          MFAService
+}
+
+func NewPasskeyService() PasskeyService {
+	// Relying party configuration is validated when the server starts
+	relyingParty, _ := DefaultPasskeyRelyingParty()
+	return &passkeyService{
+		db:           database.New(),
+		relyingParty: relyingParty,
+		mfa:          NewMFAService(),
+	}
+}
+
+func (s *passkeyService) BeginRegistration(userID uint) (*PasskeyChallenge, error) {
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	options, session, err := s.relyingParty.BeginRegistration(user)
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.saveSession(userID, webauthnPurposeRegister, session)
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyChallenge{Session: token, Options: options}, nil
+}
+
+func (s *passkeyService) FinishRegistration(userID uint, session, name string, response []byte) (*models.Passkey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultPasskeyName
+	} else if len([]rune(name)) > 100 {
+		return nil, ErrInvalidPasskeyName
+	}
+
+	data, err := s.consumeSession(session, webauthnPurposeRegister, userID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := s.relyingParty.FinishRegistration(user, data, response)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		return nil, err
+	}
+	passkey := models.Passkey{
+		UserID:       userID,
+		Name:         name,
+		CredentialID: base64.RawURLEncoding.EncodeToString(credential.ID),
+		Credential:   string(encoded),
+	}
+	if err := s.db.GetDB().Create(&passkey).Error; err != nil {
+		return nil, err
+	}
+	return &passkey, nil
+}
+
+func (s *passkeyService) BeginLogin() (*PasskeyChallenge, error) {
+	options, session, err := s.relyingParty.BeginLogin()
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.saveSession(0, webauthnPurposeLogin, session)
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyChallenge{Session: token, Options: options}, nil
+}
+
+func (s *passkeyService) FinishLogin(session string, response []byte) (*models.User, error) {
+	data, err := s.consumeSession(session, webauthnPurposeLogin, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := func(userID uint) (*PasskeyUser, error) {
+		user, err := s.loadUser(userID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPasskeyVerificationFailed
+		}
+		return user, err
+	}
+	user, credential, err := s.relyingParty.FinishLogin(data, response, lookup)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.touchPasskey(user.User.ID, credential); err != nil {
+		return nil, err
+	}
+	return user.User, nil
+}
+
+func (s *passkeyService) BeginSecondFactor(userID uint) (*PasskeyChallenge, error) {
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(user.Credentials) == 0 {
+		return nil, ErrNoPasskeys
+	}
+	options, session, err := s.relyingParty.BeginSecondFactor(user)
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.saveSession(userID, webauthnPurposeMFA, session)
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyChallenge{Session: token, Options: options}, nil
+}
+
+func (s *passkeyService) FinishSecondFactor(userID uint, session string, response []byte) error {
+	data, err := s.consumeSession(session, webauthnPurposeMFA, userID)
+	if err != nil {
+		return err
+	}
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return err
+	}
+	credential, err := s.relyingParty.FinishSecondFactor(user, data, response)
+	if err != nil {
+		return err
+	}
+	return s.touchPasskey(userID, credential)
+}
+
+func (s *passkeyService) GetPasskeys(userID uint) ([]models.Passkey, error) {
+	var passkeys []models.Passkey
+	err := s.db.GetDB().Where("user_id = ?", userID).Order("created_at").Find(&passkeys).Error
+	if err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+func (s *passkeyService) HasPasskeys(userID uint) (bool, error) {
+	var count int64
+	err := s.db.GetDB().Model(&models.Passkey{}).Where("user_id = ?", userID).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *passkeyService) RenamePasskey(userID, id uint, name string) (*models.Passkey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > 100 {
+		return nil, ErrInvalidPasskeyName
+	}
+
+	var passkey models.Passkey
+	err := s.db.GetDB().Where("id = ? AND user_id = ?", id, userID).First(&passkey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPasskeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if err := s.db.GetDB().Model(&passkey).Update("name", name).Error; err != nil {
+		return nil, err
+	}
+	passkey.Name = name
+	return &passkey, nil
+}
+
+// DeletePasskey removes a passkey, unless it is the last second factor of a
+// user whose role requires MFA
+func (s *passkeyService) DeletePasskey(userID, id uint) error {
+	var passkeys []models.Passkey
+	if err := s.db.GetDB().Where("user_id = ?", userID).Find(&passkeys).Error; err != nil {
+		return err
+	}
+	found := false
+	for _, passkey := range passkeys {
+		found = found || passkey.ID == id
+	}
+	if !found {
+		return ErrPasskeyNotFound
+	}
+
+	if len(passkeys) == 1 {
+		totp, err := s.mfa.IsEnabled(userID)
+		if err != nil {
+			return err
+		}
+		required, err := s.mfa.IsRequired(userID)
+		if err != nil {
+			return err
+		}
+		if required && !totp {
+			return ErrMFARequiredByRole
+		}
+	}
+
+	result := s.db.GetDB().Where("id = ? AND user_id = ?", id, userID).Delete(&models.Passkey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// loadUser returns an active user with their registered credentials
+func (s *passkeyService) loadUser(userID uint) (*PasskeyUser, error) {
+	var user models.User
+	err := s.db.GetDB().Preload("Roles").Where("id = ? AND status = ?", userID, "active").First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := s.GetPasskeys(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		var credential webauthn.Credential
+		if err := json.Unmarshal([]byte(passkey.Credential), &credential); err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return &PasskeyUser{User: &user, Credentials: credentials}, nil
+}
+
+// touchPasskey stores the signature counter and flags of a verified assertion
+func (s *passkeyService) touchPasskey(userID uint, credential *webauthn.Credential) error {
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	return s.db.GetDB().Model(&models.Passkey{}).
+		Where("user_id = ? AND credential_id = ?", userID, base64.RawURLEncoding.EncodeToString(credential.ID)).
+		Updates(map[string]interface{}{"credential": string(encoded), "last_used_at": time.Now()}).Error
+}
+
+func (s *passkeyService) saveSession(userID uint, purpose string, data *webauthn.SessionData) (string, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	session := models.WebAuthnSession{
+		TokenHash: utils.HashToken(token),
+		UserID:    userID,
+		Purpose:   purpose,
+		Data:      string(encoded),
+		ExpiresAt: time.Now().Add(WebAuthnSessionTTL),
+	}
+	if err := s.db.GetDB().Create(&session).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeSession deletes the session so each challenge is answered once
+func (s *passkeyService) consumeSession(token, purpose string, userID uint) (*webauthn.SessionData, error) {
+	var session models.WebAuthnSession
+	err := s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", utils.HashToken(token)).
+			First(&session).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidWebAuthnSession
+		} else if err != nil {
+			return err
+		}
+		return tx.Delete(&session).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(session.ExpiresAt) || session.Purpose != purpose || session.UserID != userID {
+		return nil, ErrInvalidWebAuthnSession
+	}
+	var data webauthn.SessionData
+	if err := json.Unmarshal([]byte(session.Data), &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// PasswordViolation is one failed rule of a PasswordPolicy
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule a password failed
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the password policy"
+}
+
+// PasswordRule checks a password for the user it is being set for. It returns a
+// violation when the password fails the rule, and an error only when the check
+// itself could not be performed. The user's ID is zero during registration.
+type PasswordRule func(password string, user *models.User) (*PasswordViolation, error)
+
+// PasswordPolicy validates new passwords on registration, change and reset
+type PasswordPolicy interface {
+	// Validate returns a *PasswordPolicyError when any rule fails
+	Validate(password string, user *models.User) error
+}
+
+type passwordPolicy struct {
+	rules []PasswordRule
+}
+
+// NewPasswordPolicy builds a policy that runs every rule in order
+func NewPasswordPolicy(rules ...PasswordRule) PasswordPolicy {
+	return &passwordPolicy{rules: rules}
+}
+
+func (p *passwordPolicy) Validate(password string, user *models.User) error {
+	var violations []PasswordViolation
+	for _, rule := range p.rules {
+		violation, err := rule(password, user)
+		if err != nil {
+			return err
+		}
+		if violation != nil {
+			violations = append(violations, *violation)
+		}
+	}
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+var (
+	defaultPasswordPolicy     PasswordPolicy
+	defaultPasswordPolicyErr  error
+	defaultPasswordPolicyOnce sync.Once
+)
+
+// DefaultPasswordPolicy returns the process wide policy, configured on first use
+// with PASSWORD_MIN_LENGTH, PASSWORD_MIN_CHAR_CLASSES, PASSWORD_HISTORY and
+// PASSWORD_BREACH_FILE. The breach check is skipped when no file is configured.
+func DefaultPasswordPolicy() (PasswordPolicy, error) {
+	defaultPasswordPolicyOnce.Do(func() {
+		rules := []PasswordRule{
+			MinLengthRule(utils.GetEnvInt("PASSWORD_MIN_LENGTH", 10)),
+			CharacterClassesRule(utils.GetEnvInt("PASSWORD_MIN_CHAR_CLASSES", 3)),
+			PersonalInfoRule(),
+			PasswordHistoryRule(database.New(), PasswordHistorySize()),
+		}
+		if path := os.Getenv("PASSWORD_BREACH_FILE"); path != "" {
+			source, err := LoadPasswordRangeFile(path)
+			if err != nil {
+				defaultPasswordPolicyErr = fmt.Errorf("loading PASSWORD_BREACH_FILE: %w", err)
+				return
+			}
+			rules = append(rules, BreachedPasswordRule(source))
+		}
+		defaultPasswordPolicy = NewPasswordPolicy(rules...)
+	})
+	return defaultPasswordPolicy, defaultPasswordPolicyErr
+}
+
+// PasswordHistorySize is the number of previous passwords that cannot be reused
+func PasswordHistorySize() int {
+	return utils.GetEnvInt("PASSWORD_HISTORY", 5)
+}
+
+// MinLengthRule requires at least n characters
+func MinLengthRule(n int) PasswordRule {
+	return func(password string, _ *models.User) (*PasswordViolation, error) {
+		if len([]rune(password)) < n {
+			return &PasswordViolation{
+				Rule:    "min_length",
+				Message: fmt.Sprintf("password must be at least %d characters long", n),
+			}, nil
+		}
+		return nil, nil
+	}
+}
+
+// CharacterClassesRule requires characters from at least n of the classes
+// lowercase, uppercase, digit and symbol
+func CharacterClassesRule(n int) PasswordRule {
+	return func(password string, _ *models.User) (*PasswordViolation, error) {
+		var lower, upper, digit, symbol bool
+		for _, r := range password {
+			switch {
+			case unicode.IsLower(r):
+				lower = true
+			case unicode.IsUpper(r):
+				upper = true
+			case unicode.IsDigit(r):
+				digit = true
+			default:
+				symbol = true
+			}
+		}
+
+		classes := 0
+		for _, ok := range []bool{lower, upper, digit, symbol} {
+			if ok {
+				classes++
+			}
+		}
+		if classes < n {
+			return &PasswordViolation{
+				Rule:    "character_classes",
+				Message: fmt.Sprintf("password must contain at least %d of: lowercase letters, uppercase letters, digits and symbols", n),
+			}, nil
+		}
+		return nil, nil
+	}
+}
+
+// PersonalInfoRule rejects passwords containing the user's name, any part of
+// their name, or the local part of their email address
+func PersonalInfoRule() PasswordRule {
+	return func(password string, user *models.User) (*PasswordViolation, error) {
+		if user == nil {
+			return nil, nil
+		}
+
+		lowered := strings.ToLower(password)
+		terms := strings.Fields(strings.ToLower(user.Name))
+		if local, _, found := strings.Cut(strings.ToLower(user.Email), "@"); found {
+			terms = append(terms, local)
+		}
+		for _, term := range terms {
+			// Very short fragments such as initials would reject too much
+			if len([]rune(term)) >= 3 && strings.Contains(lowered, term) {
+				return &PasswordViolation{
+					Rule:    "personal_info",
+					Message: "password must not contain your name or email address",
+				}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// PasswordHistoryRule rejects the current password and the last n passwords of
+// an existing user
+func PasswordHistoryRule(db database.Service, n int) PasswordRule {
+	return func(password string, user *models.User) (*PasswordViolation, error) {
+		if user == nil || user.ID == 0 || n <= 0 {
+			return nil, nil
+		}
+
+		var hashes []string
+		err := db.GetDB().Model(&models.PasswordHistory{}).
+			Where("user_id = ?", user.ID).
+			Order("id DESC").
+			Limit(n).
+			Pluck("hash", &hashes).Error
+		if err != nil {
+			return nil, err
+		}
+		if user.Password != "" {
+			hashes = append(hashes, user.Password)
+		}
+
+		hasher, err := utils.Passwords()
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range hashes {
+			if ok, _ := hasher.Verify(password, hash); ok {
+				return &PasswordViolation{
+					Rule:    "history",
+					Message: fmt.Sprintf("password must not match any of your last %d passwords", n),
+				}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// recordPasswordHistory stores a newly set hash inside the caller's transaction
+// and drops entries beyond the configured history size
+func recordPasswordHistory(tx *gorm.DB, userID uint, hash string) error {
+	if err := tx.Create(&models.PasswordHistory{UserID: userID, Hash: hash}).Error; err != nil {
+		return err
+	}
+
+	keep := tx.Model(&models.PasswordHistory{}).
+		Select("id").
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(PasswordHistorySize())
+	return tx.Where("user_id = ? AND id NOT IN (?)", userID, keep).
+		Delete(&models.PasswordHistory{}).Error
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"Admin-gin/internal/models"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func violatedRules(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("expected a PasswordPolicyError, got %v", err)
+	}
+	rules := make([]string, 0, len(policyErr.Violations))
+	for _, violation := range policyErr.Violations {
+		rules = append(rules, violation.Rule)
+	}
+	return rules
+}
+
+func TestPasswordPolicyReportsEveryViolation(t *testing.T) {
+	policy := NewPasswordPolicy(MinLengthRule(10), CharacterClassesRule(3), PersonalInfoRule())
+	user := &models.User{Name: "Jane Doe", Email: "jdoe@example.com"}
+
+	rules := violatedRules(t, policy.Validate("janedoe", user))
+	want := []string{"min_length", "character_classes", "personal_info"}
+	if strings.Join(rules, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected violations %v, got %v", want, rules)
+	}
+
+	if err := policy.Validate("Correct-Horse-42", user); err != nil {
+		t.Fatalf("expected a strong password to pass, got %v", err)
+	}
+}
+
+func TestPersonalInfoRuleChecksEmail(t *testing.T) {
+	policy := NewPasswordPolicy(PersonalInfoRule())
+	user := &models.User{Name: "Al", Email: "alpha.user@example.com"}
+
+	if rules := violatedRules(t, policy.Validate("My-ALPHA.USER-pass1", user)); len(rules) != 1 {
+		t.Fatalf("expected the email local part to be rejected, got %v", rules)
+	}
+	if err := policy.Validate("Albatross-2024!", user); err != nil {
+		t.Fatalf("expected short name fragments to be ignored, got %v", err)
+	}
+}
+
+func TestBreachedPasswordRule(t *testing.T) {
+	sum := sha1.Sum([]byte("Password123!"))
+	breached := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	content := "# breached hashes\n" + breached + ":42\n" + strings.Repeat("A", 40) + ":1\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := LoadPasswordRangeFile(path)
+	if err != nil {
+		t.Fatalf("LoadPasswordRangeFile returned error: %v", err)
+	}
+	policy := NewPasswordPolicy(BreachedPasswordRule(source))
+
+	if rules := violatedRules(t, policy.Validate("Password123!", nil)); len(rules) != 1 || rules[0] != "breached" {
+		t.Fatalf("expected breached password to be rejected, got %v", rules)
+	}
+	if err := policy.Validate("Correct-Horse-42", nil); err != nil {
+		t.Fatalf("expected unknown password to pass, got %v", err)
+	}
+}
+
+func TestLoadPasswordRangeFileRejectsMalformedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte("not-a-hash:1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPasswordRangeFile(path); err == nil {
+		t.Fatal("expected malformed file to be rejected")
+	}
+}
@@ -3,12 +3,43 @@ package services
 import (
 	"Admin-gin/internal/database"
 	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
 )
 
+var ErrInvalidPermissionName = errors.New("permission names must look like resource.action, with lowercase letters, digits and underscores, or be a pattern like resource.*, *.action or *")
+
 type PermissionService interface {
 	AddPermission(permission *models.Permission) error
 	GetPermissions() ([]models.Permission, error)
+	GetPermissionsByIDs(ids []uint) ([]models.Permission, error)
 	DeletePermission(id uint) error
+	// Explain evaluates the user's grants for a permission, on every record or
+	// on the user targetID when set, and reports the grant that decided it.
+	// Conditions see request as the attributes of the request.
+	Explain(userID uint, permission string, targetID *uint, request utils.RequestInfo) (*PermissionExplanation, error)
+	// DryRunCondition evaluates a grant condition as if the user made request
+	// on the user targetID, without saving anything
+	DryRunCondition(condition string, userID uint, targetID *uint, request utils.RequestInfo) (*ConditionResult, error)
+}
+
+type PermissionExplanation struct {
+	utils.Decision
+	// Grants are the allows and denies of the user matching the permission,
+	// whether or not they apply to the target
+	Grants []utils.Grant `json:"grants"`
+}
+
+type ConditionResult struct {
+	Result bool `json:"result"`
+	// Error is why the condition could not be evaluated, e.g. it read an
+	// attribute of a missing resource. An allow with such a condition does not
+	// apply and a deny does.
+	Error string `json:"error,omitempty"`
 }
 
 type permissionService struct {
@@ -22,6 +53,9 @@ func NewPermissionService() PermissionService {
 }
 
 func (s *permissionService) AddPermission(permission *models.Permission) error {
+	if !utils.ValidPermissionName(permission.Name) {
+		return ErrInvalidPermissionName
+	}
 	if err := s.db.GetDB().Create(permission).Error; err != nil {
 		return err
 	}
@@ -36,9 +70,101 @@ func (s *permissionService) GetPermissions() ([]models.Permission, error) {
 	return permissions, nil
 }
 
+func (s *permissionService) GetPermissionsByIDs(ids []uint) ([]models.Permission, error) {
+	permissions := []models.Permission{}
+	if len(ids) == 0 {
+		return permissions, nil
+	}
+	if err := s.db.GetDB().Where("id IN ?", ids).Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
 func (s *permissionService) DeletePermission(id uint) error {
 	if err := s.db.GetDB().Delete(&models.Permission{}, id).Error; err != nil {
 		return err
 	}
-	return nil
+	return s.db.GetDB().Model(&models.User{}).
+		Where("id IN (?)", s.db.GetDB().Table("user_has_roles").
+			Select("user_id").
+			Where("role_id IN (?)", roleDescendants(s.db.GetDB(),
+				s.db.GetDB().Table("role_has_permissions").Select("role_id").Where("permission_id = ?", id)))).
+		Update("authz_version", gorm.Expr("authz_version + 1")).Error
+}
+
+func (s *permissionService) Explain(userID uint, permission string, targetID *uint, request utils.RequestInfo) (*PermissionExplanation, error) {
+	if !utils.ValidPermissionName(permission) || strings.Contains(permission, utils.PermissionWildcard) {
+		return nil, ErrInvalidPermissionName
+	}
+
+	ctx, err := s.accessContext(userID, targetID, request)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := utils.GetUserPermissions(s.db.GetDB(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	matching := []utils.Grant{}
+	for _, grant := range grants {
+		if grant.Matches(permission) {
+			matching = append(matching, grant)
+		}
+	}
+	return &PermissionExplanation{Decision: utils.Evaluate(grants, permission, ctx), Grants: matching}, nil
+}
+
+func (s *permissionService) DryRunCondition(condition string, userID uint, targetID *uint, request utils.RequestInfo) (*ConditionResult, error) {
+	// Not cached: dry runs are not grants
+	compiled, err := utils.ParseCondition(condition)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCondition, err)
+	}
+	ctx, err := s.accessContext(userID, targetID, request)
+	if err != nil {
+		return nil, err
+	}
+	result, err := compiled.Eval(ctx)
+	if err != nil {
+		return &ConditionResult{Error: err.Error()}, nil
+	}
+	return &ConditionResult{Result: result}, nil
+}
+
+// accessContext describes a request of the user on the user targetID, or on
+// no record in particular when targetID is nil
+func (s *permissionService) accessContext(userID uint, targetID *uint, request utils.RequestInfo) (*utils.AccessContext, error) {
+	caller, err := s.userResource(userID)
+	if err != nil {
+		return nil, err
+	}
+	roles := []string{}
+	err = s.db.GetDB().Model(&models.Role{}).
+		Where("id IN (?)", s.db.GetDB().Table("user_has_roles").Select("role_id").Where("user_id = ?", userID)).
+		Order("name").
+		Pluck("name", &roles).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := &utils.AccessContext{Caller: caller, CallerType: models.ActorUser, Roles: roles, Request: request}
+	if targetID != nil {
+		if ctx.Resource, err = s.userResource(*targetID); err != nil {
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+func (s *permissionService) userResource(id uint) (*utils.Resource, error) {
+	var user models.User
+	err := s.db.GetDB().Select("id", "department", "status").First(&user, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &utils.Resource{ID: user.ID, OwnerID: user.ID, Department: user.Department, Status: user.Status}, nil
 }
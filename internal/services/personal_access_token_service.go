@@ -0,0 +1,190 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PersonalAccessTokenPrefix starts every personal access token so they can be
+// told apart from JWTs and picked up by secret scanners
+const PersonalAccessTokenPrefix = "agpat_"
+
+// credentialTouchInterval limits how often last-used details of API credentials are written
+const credentialTouchInterval = time.Minute
+
+var (
+	ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+	ErrInvalidPersonalAccessToken  = errors.New("invalid or expired personal access token")
+	ErrPersonalAccessTokenScope    = errors.New("scopes must be permissions you currently hold")
+	ErrPersonalAccessTokenExpiry   = errors.New("expires_at must be in the future and within the maximum token lifetime")
+)
+
+// PersonalAccessTokenMaxTTL is the longest lifetime a token can be created
+// with, configured with PAT_MAX_TTL
+func PersonalAccessTokenMaxTTL() time.Duration {
+	return utils.GetEnvDuration("PAT_MAX_TTL", 365*24*time.Hour)
+}
+
+// IsPersonalAccessToken reports whether the credential looks like a personal access token
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
+
+// PersonalAccessTokenInput creates a token
+type PersonalAccessTokenInput struct {
+	Name      string    `json:"name" binding:"required,max=100"`
+	Scopes    []string  `json:"scopes" binding:"required,min=1"`
+	ExpiresAt time.Time `json:"expires_at" binding:"required"`
+}
+
+// PersonalAccessTokenResponse is a token as shown to its owner
+type PersonalAccessTokenResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type PersonalAccessTokenService interface {
+	// CreateToken returns the new token and its plaintext value, which is only shown once
+	CreateToken(userID uint, input *PersonalAccessTokenInput) (*PersonalAccessTokenResponse, string, error)
+	GetTokens(userID uint) ([]PersonalAccessTokenResponse, error)
+	RevokeToken(userID, id uint) error
+	// Authenticate resolves a plaintext token to its active token, with the
+	// owner and their roles loaded, and records where it was used from
+	Authenticate(token, ipAddress string) (*models.PersonalAccessToken, error)
+}
+
+type personalAccessTokenService struct {
+	db database.Service
+}
+
+func NewPersonalAccessTokenService() PersonalAccessTokenService {
+	return &personalAccessTokenService{
+		db: database.New(),
+	}
+}
+
+func newPersonalAccessTokenResponse(token *models.PersonalAccessToken) PersonalAccessTokenResponse {
+	return PersonalAccessTokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     token.ScopeList(),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		LastUsedIP: token.LastUsedIP,
+		CreatedAt:  token.CreatedAt,
+	}
+}
+
+func (s *personalAccessTokenService) CreateToken(userID uint, input *PersonalAccessTokenInput) (*PersonalAccessTokenResponse, string, error) {
+	now := time.Now()
+	if !input.ExpiresAt.After(now) || input.ExpiresAt.After(now.Add(PersonalAccessTokenMaxTTL())) {
+		return nil, "", ErrPersonalAccessTokenExpiry
+	}
+
+	// Scoped grants count: the token is still checked against them on use
+	grants, err := utils.GetUserPermissions(s.db.GetDB(), userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !containsAllScopes(utils.GrantNames(grants), input.Scopes) {
+		return nil, "", ErrPersonalAccessTokenScope
+	}
+
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext := PersonalAccessTokenPrefix + secret
+
+	token := models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      input.Name,
+		Prefix:    plaintext[:len(PersonalAccessTokenPrefix)+6],
+		TokenHash: utils.HashToken(plaintext),
+		Scopes:    strings.Join(mergeScopes(nil, input.Scopes), " "),
+		ExpiresAt: input.ExpiresAt,
+	}
+	if err := s.db.GetDB().Create(&token).Error; err != nil {
+		return nil, "", err
+	}
+	response := newPersonalAccessTokenResponse(&token)
+	return &response, plaintext, nil
+}
+
+// GetTokens returns the tokens of the user that are neither revoked nor expired
+func (s *personalAccessTokenService) GetTokens(userID uint) ([]PersonalAccessTokenResponse, error) {
+	var tokens []models.PersonalAccessToken
+	err := s.db.GetDB().
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").
+		Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	responses := make([]PersonalAccessTokenResponse, len(tokens))
+	for i := range tokens {
+		responses[i] = newPersonalAccessTokenResponse(&tokens[i])
+	}
+	return responses, nil
+}
+
+func (s *personalAccessTokenService) RevokeToken(userID, id uint) error {
+	result := s.db.GetDB().Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPersonalAccessTokenNotFound
+	}
+	return nil
+}
+
+func (s *personalAccessTokenService) Authenticate(plaintext, ipAddress string) (*models.PersonalAccessToken, error) {
+	if !IsPersonalAccessToken(plaintext) {
+		return nil, ErrInvalidPersonalAccessToken
+	}
+
+	now := time.Now()
+	var token models.PersonalAccessToken
+	err := s.db.GetDB().Preload("User.Roles").
+		Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", utils.HashToken(plaintext), now).
+		First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidPersonalAccessToken
+	} else if err != nil {
+		return nil, err
+	}
+	if token.User.ID == 0 || token.User.Status != "active" {
+		return nil, ErrInvalidPersonalAccessToken
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= credentialTouchInterval || token.LastUsedIP != ipAddress {
+		err := s.db.GetDB().Model(&models.PersonalAccessToken{}).
+			Where("id = ?", token.ID).
+			Updates(map[string]interface{}{
+				"last_used_at": now,
+				"last_used_ip": ipAddress,
+			}).Error
+		if err != nil {
+			return nil, err
+		}
+		token.LastUsedAt = &now
+		token.LastUsedIP = ipAddress
+	}
+	return &token, nil
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevocationStore keeps track of access tokens that must no longer be accepted
+// even though their signature and expiry are still valid.
+type RevocationStore interface {
+	// RevokeToken denylists a single token until expiresAt
+	RevokeToken(jti string, expiresAt time.Time) error
+	// RevokeUser invalidates every token issued to the user up to now
+	RevokeUser(userID uint) error
+	// IsRevoked reports whether the token or all of the user's tokens were revoked
+	IsRevoked(jti string, userID uint, issuedAt time.Time) (bool, error)
+	// RevokeSession invalidates every token issued for the session
+	RevokeSession(sessionID string) error
+	// IsSessionRevoked reports whether the session was terminated, including
+	// by refresh token reuse detection
+	IsSessionRevoked(sessionID string) (bool, error)
+	// IsStale reports whether the user's roles or their permissions changed
+	// since a token with the authz version was issued
+	IsStale(userID uint, authzVersion int64) (bool, error)
+}
+
+// issuedBefore compares at second precision since iat has no sub-second part
+func issuedBefore(issuedAt, revokedAt time.Time) bool {
+	return !issuedAt.After(revokedAt.Truncate(time.Second))
+}
+
+type postgresRevocationStore struct {
+	db database.Service
+}
+
+func NewPostgresRevocationStore(db database.Service) RevocationStore {
+	return &postgresRevocationStore{db: db}
+}
+
+func (s *postgresRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	// Entries are only useful until the token expires, prune them as we go
+	if err := s.db.GetDB().Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+
+	return s.db.GetDB().
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+func (s *postgresRevocationStore) RevokeUser(userID uint) error {
+	return s.db.GetDB().
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&models.UserRevocation{UserID: userID, RevokedAt: time.Now()}).Error
+}
+
+func (s *postgresRevocationStore) IsRevoked(jti string, userID uint, issuedAt time.Time) (bool, error) {
+	var count int64
+	if err := s.db.GetDB().Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	var revocations []models.UserRevocation
+	if err := s.db.GetDB().Where("user_id = ?", userID).Limit(1).Find(&revocations).Error; err != nil {
+		return false, err
+	}
+	return len(revocations) > 0 && issuedBefore(issuedAt, revocations[0].RevokedAt), nil
+}
+
+// RevokeSession marks the session revoked, which the session service has
+// usually done already
+func (s *postgresRevocationStore) RevokeSession(sessionID string) error {
+	return s.db.GetDB().Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (s *postgresRevocationStore) IsSessionRevoked(sessionID string) (bool, error) {
+	var count int64
+	err := s.db.GetDB().Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NOT NULL", sessionID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (s *postgresRevocationStore) IsStale(userID uint, authzVersion int64) (bool, error) {
+	var user models.User
+	err := s.db.GetDB().Select("authz_version").First(&user, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return authzVersion != user.AuthzVersion, nil
+}
+
+type memoryRevocationStore struct {
+	mu       sync.RWMutex
+	tokens   map[string]time.Time
+	users    map[uint]time.Time
+	sessions map[string]bool
+}
+
+// NewMemoryRevocationStore returns a process local store, intended for tests
+// and single instance deployments.
+func NewMemoryRevocationStore() RevocationStore {
+	return &memoryRevocationStore{
+		tokens:   make(map[string]time.Time),
+		users:    make(map[uint]time.Time),
+		sessions: make(map[string]bool),
+	}
+}
+
+func (s *memoryRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range s.tokens {
+		if exp.Before(now) {
+			delete(s.tokens, id)
+		}
+	}
+	s.tokens[jti] = expiresAt
+	return nil
+}
+
+func (s *memoryRevocationStore) RevokeUser(userID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[userID] = time.Now()
+	return nil
+}
+
+func (s *memoryRevocationStore) IsRevoked(jti string, userID uint, issuedAt time.Time) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.tokens[jti]; ok {
+		return true, nil
+	}
+	revokedAt, ok := s.users[userID]
+	return ok && issuedBefore(issuedAt, revokedAt), nil
+}
+
+func (s *memoryRevocationStore) RevokeSession(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[sessionID] = true
+	return nil
+}
+
+func (s *memoryRevocationStore) IsSessionRevoked(sessionID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sessions[sessionID], nil
+}
+
+// IsStale never reports tokens as stale: the memory store does not see the
+// users table, so tokens keep their roles snapshot until they expire
+func (s *memoryRevocationStore) IsStale(userID uint, authzVersion int64) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRevocationStoreRevokeToken(t *testing.T) {
+	store := NewMemoryRevocationStore()
+	issuedAt := time.Now()
+
+	if err := store.RevokeToken("revoked", time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("RevokeToken returned error: %v", err)
+	}
+
+	revoked, err := store.IsRevoked("revoked", 1, issuedAt)
+	if err != nil || !revoked {
+		t.Fatalf("expected revoked token to be reported, got %v (%v)", revoked, err)
+	}
+
+	revoked, err = store.IsRevoked("other", 1, issuedAt)
+	if err != nil || revoked {
+		t.Fatalf("expected other token to be valid, got %v (%v)", revoked, err)
+	}
+}
+
+func TestMemoryRevocationStoreRevokeUser(t *testing.T) {
+	store := NewMemoryRevocationStore()
+	issuedAt := time.Now().Add(-time.Minute)
+
+	if err := store.RevokeUser(1); err != nil {
+		t.Fatalf("RevokeUser returned error: %v", err)
+	}
+
+	if revoked, _ := store.IsRevoked("a", 1, issuedAt); !revoked {
+		t.Fatal("expected token issued before revocation to be revoked")
+	}
+	if revoked, _ := store.IsRevoked("b", 2, issuedAt); revoked {
+		t.Fatal("expected tokens of other users to stay valid")
+	}
+	if revoked, _ := store.IsRevoked("c", 1, time.Now().Add(2*time.Second)); revoked {
+		t.Fatal("expected token issued after revocation to be valid")
+	}
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var ErrRoleCycle = errors.New("a role cannot inherit from itself or from a role that inherits from it")
+
+// roleDescendantsSQL selects the given roles and every role inheriting from
+// them, directly or not
+const roleDescendantsSQL = `WITH RECURSIVE role_descendants(id) AS (
+	SELECT roles.id FROM roles WHERE roles.id IN (?)
+	UNION
+	SELECT role_has_parents.role_id FROM role_has_parents
+	JOIN role_descendants ON role_has_parents.parent_id = role_descendants.id
+)
+SELECT id FROM role_descendants`
+
+// roleDescendants returns a subquery selecting the roles whose effective
+// permissions change with those of roleIDs
+func roleDescendants(db *gorm.DB, roleIDs interface{}) *gorm.DB {
+	return db.Raw(roleDescendantsSQL, roleIDs)
+}
+
+// createsRoleCycle reports whether roleID would inherit from itself if its
+// parents were replaced by parentIDs. parents holds the current edges of the
+// graph, from a role to its parents.
+func createsRoleCycle(parents map[uint][]uint, roleID uint, parentIDs []uint) bool {
+	visited := make(map[uint]bool)
+	stack := append([]uint(nil), parentIDs...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == roleID {
+			return true
+		}
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		stack = append(stack, parents[id]...)
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package services
+
+import "testing"
+
+func TestCreatesRoleCycle(t *testing.T) {
+	// admin -> editor -> viewer, auditor -> viewer
+	parents := map[uint][]uint{
+		1: {2},
+		2: {3},
+		4: {3},
+	}
+
+	tests := []struct {
+		name    string
+		roleID  uint
+		parents []uint
+		cycle   bool
+	}{
+		{"no parents", 3, nil, false},
+		{"self", 3, []uint{3}, true},
+		{"direct child", 3, []uint{2}, true},
+		{"indirect descendant", 3, []uint{1}, true},
+		{"sibling", 4, []uint{2}, false},
+		{"diamond", 1, []uint{2, 4}, false},
+		{"replacing the edge that would close the cycle", 2, []uint{4}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := createsRoleCycle(parents, tt.roleID, tt.parents); got != tt.cycle {
+				t.Fatalf("expected %v, got %v", tt.cycle, got)
+			}
+		})
+	}
+}
@@ -3,14 +3,57 @@ package services
 import (
 	"Admin-gin/internal/database"
 	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrInvalidGrantScope  = errors.New("scope must be global, own, group or resource, and resource_id is required with resource only")
+	ErrInvalidGrantEffect = errors.New("effect must be allow or deny")
+	ErrInvalidCondition   = errors.New("invalid condition")
 )
 
 type RoleService interface {
 	AddRole(role *models.Role) error
 	GetRoles() ([]models.Role, error)
+	GetRole(id uint) (*models.Role, error)
 	AssignRoleToUser(userRole *models.UserHasRole) error
-	AssignPermissionsToRole(roleID uint, permIDs []uint) error
+	// AssignPermissionsToRole allows or denies the permissions to a role
+	AssignPermissionsToRole(roleID uint, permIDs []uint, options GrantOptions) error
 	DeleteRole(id uint) error
+	SetRequireMFA(id uint, required bool) error
+	SetAllowMagicLink(id uint, allowed bool) error
+	// SetParents replaces the roles a role inherits permissions from
+	SetParents(id uint, parentIDs []uint) error
+	GetParents(id uint) ([]models.Role, error)
+	// GetRolePermissions returns the grants made to a role directly and the
+	// effective ones, including those inherited from its ancestors
+	GetRolePermissions(id uint) (*RolePermissions, error)
+	// GetEffectiveGrants returns the grants of the roles including the
+	// inherited ones
+	GetEffectiveGrants(roleIDs []uint) ([]utils.Grant, error)
+}
+
+// GrantOptions describes a grant made by AssignPermissionsToRole. Effect is a
+// models.GrantEffect value and Scope a models.GrantScope value, defaulting to
+// allow and global; ResourceID is the record of a resource grant. Condition is
+// an optional CEL expression, see utils.CompileCondition.
+type GrantOptions struct {
+	Effect     string
+	Scope      string
+	ResourceID *uint
+	Condition  string
+}
+
+type RolePermissions struct {
+	Role      models.Role   `json:"role"`
+	Parents   []models.Role `json:"parents"`
+	Direct    []utils.Grant `json:"direct"`
+	Effective []utils.Grant `json:"effective"`
 }
 
 type roleService struct {
@@ -38,21 +81,67 @@ func (s *roleService) GetRoles() ([]models.Role, error) {
 	return roles, nil
 }
 
+func (s *roleService) GetRole(id uint) (*models.Role, error) {
+	var role models.Role
+	err := s.db.GetDB().First(&role, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRoleNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
 func (s *roleService) AssignRoleToUser(userRole *models.UserHasRole) error {
 	if err := s.db.GetDB().Create(userRole).Error; err != nil {
 		return err
 	}
-	return nil
+	return bumpUserAuthzVersion(s.db.GetDB(), userRole.UserID)
 }
 
-func (s *roleService) AssignPermissionsToRole(roleID uint, permIDs []uint) error {
+func (s *roleService) AssignPermissionsToRole(roleID uint, permIDs []uint, options GrantOptions) error {
+	if options.Effect == "" {
+		options.Effect = models.GrantEffectAllow
+	}
+	if options.Effect != models.GrantEffectAllow && options.Effect != models.GrantEffectDeny {
+		return ErrInvalidGrantEffect
+	}
+	if options.Scope == "" {
+		options.Scope = models.GrantScopeGlobal
+	}
+	switch options.Scope {
+	case models.GrantScopeGlobal, models.GrantScopeOwn, models.GrantScopeGroup:
+		if options.ResourceID != nil {
+			return ErrInvalidGrantScope
+		}
+	case models.GrantScopeResource:
+		if options.ResourceID == nil {
+			return ErrInvalidGrantScope
+		}
+	default:
+		return ErrInvalidGrantScope
+	}
+	// Compiling also caches the program for the permission checks
+	if options.Condition != "" {
+		if _, err := utils.CompileCondition(options.Condition); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCondition, err)
+		}
+	}
+
 	for _, pid := range permIDs {
-		rp := models.RoleHasPermission{RoleID: roleID, PermissionID: pid}
+		rp := models.RoleHasPermission{
+			RoleID:       roleID,
+			PermissionID: pid,
+			Effect:       options.Effect,
+			Scope:        options.Scope,
+			ResourceID:   options.ResourceID,
+			Condition:    options.Condition,
+		}
 		if err := s.db.GetDB().Create(&rp).Error; err != nil {
 			return err
 		}
 	}
-	return nil
+	return bumpRoleAuthzVersion(s.db.GetDB(), roleID)
 }
 
 func (s *roleService) DeleteRole(id uint) error {
@@ -64,5 +153,148 @@ func (s *roleService) DeleteRole(id uint) error {
 	if err := s.db.GetDB().Delete(&role).Error; err != nil {
 		return err
 	}
+	return bumpRoleAuthzVersion(s.db.GetDB(), id)
+}
+
+func (s *roleService) SetRequireMFA(id uint, required bool) error {
+	result := s.db.GetDB().Model(&models.Role{}).Where("id = ?", id).Update("require_mfa", required)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRoleNotFound
+	}
 	return nil
 }
+
+func (s *roleService) SetAllowMagicLink(id uint, allowed bool) error {
+	result := s.db.GetDB().Model(&models.Role{}).Where("id = ?", id).Update("allow_magic_link", allowed)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+func (s *roleService) SetParents(id uint, parentIDs []uint) error {
+	parentIDs = uniqueIDs(parentIDs)
+	return s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		// Serializes changes to the graph so two concurrent updates cannot
+		// close a cycle that neither of them sees
+		if err := tx.Exec("LOCK TABLE role_has_parents IN SHARE ROW EXCLUSIVE MODE").Error; err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&models.Role{}).Where("id = ?", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrRoleNotFound
+		}
+		if len(parentIDs) > 0 {
+			if err := tx.Model(&models.Role{}).Where("id IN ?", parentIDs).Count(&count).Error; err != nil {
+				return err
+			}
+			if int(count) != len(parentIDs) {
+				return ErrRoleNotFound
+			}
+		}
+
+		var edges []models.RoleHasParent
+		if err := tx.Find(&edges).Error; err != nil {
+			return err
+		}
+		parents := make(map[uint][]uint)
+		for _, edge := range edges {
+			parents[edge.RoleID] = append(parents[edge.RoleID], edge.ParentID)
+		}
+		if createsRoleCycle(parents, id, parentIDs) {
+			return ErrRoleCycle
+		}
+
+		if err := tx.Where("role_id = ?", id).Delete(&models.RoleHasParent{}).Error; err != nil {
+			return err
+		}
+		for _, parentID := range parentIDs {
+			if err := tx.Create(&models.RoleHasParent{RoleID: id, ParentID: parentID}).Error; err != nil {
+				return err
+			}
+		}
+		return bumpRoleAuthzVersion(tx, id)
+	})
+}
+
+func (s *roleService) GetParents(id uint) ([]models.Role, error) {
+	roles := []models.Role{}
+	err := s.db.GetDB().
+		Where("id IN (?)", s.db.GetDB().Model(&models.RoleHasParent{}).Select("parent_id").Where("role_id = ?", id)).
+		Order("name").
+		Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (s *roleService) GetRolePermissions(id uint) (*RolePermissions, error) {
+	role, err := s.GetRole(id)
+	if err != nil {
+		return nil, err
+	}
+	parents, err := s.GetParents(id)
+	if err != nil {
+		return nil, err
+	}
+
+	direct := []utils.Grant{}
+	err = s.db.GetDB().Table("role_has_permissions").
+		Select("roles.id AS role_id, roles.name AS role, permissions.id AS permission_id, permissions.name AS permission, "+
+			"role_has_permissions.effect, role_has_permissions.scope, role_has_permissions.resource_id, role_has_permissions.condition").
+		Joins("JOIN permissions ON permissions.id = role_has_permissions.permission_id AND permissions.deleted_at IS NULL").
+		Joins("JOIN roles ON roles.id = role_has_permissions.role_id").
+		Where("role_has_permissions.role_id = ? AND role_has_permissions.deleted_at IS NULL", id).
+		Order("permissions.name, role_has_permissions.effect, role_has_permissions.scope").
+		Scan(&direct).Error
+	if err != nil {
+		return nil, err
+	}
+	effective, err := utils.GetRoleGrants(s.db.GetDB(), id)
+	if err != nil {
+		return nil, err
+	}
+	return &RolePermissions{Role: *role, Parents: parents, Direct: direct, Effective: effective}, nil
+}
+
+func (s *roleService) GetEffectiveGrants(roleIDs []uint) ([]utils.Grant, error) {
+	return utils.GetRoleGrants(s.db.GetDB(), roleIDs...)
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// bumpUserAuthzVersion marks the roles snapshot in the user's tokens as stale
+func bumpUserAuthzVersion(db *gorm.DB, userID uint) error {
+	return db.Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("authz_version", gorm.Expr("authz_version + 1")).Error
+}
+
+// bumpRoleAuthzVersion marks the snapshot of every holder of the role or of a
+// role inheriting from it as stale
+func bumpRoleAuthzVersion(db *gorm.DB, roleID uint) error {
+	return db.Model(&models.User{}).
+		Where("id IN (?)", db.Table("user_has_roles").Select("user_id").Where("role_id IN (?)", roleDescendants(db, []uint{roleID}))).
+		Update("authz_version", gorm.Expr("authz_version + 1")).Error
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"Admin-gin/internal/utils"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+var ErrSAMLLoginFailed = errors.New("SAML login failed")
+
+// SAMLConfig configures this service as a SAML 2.0 service provider for a
+// single identity provider
+type SAMLConfig struct {
+	EntityID    string
+	ACSURL      string
+	MetadataURL string
+	Certificate *x509.Certificate
+	Key         crypto.Signer
+	IDPMetadata *saml.EntityDescriptor
+	// NameIDFormat is requested in AuthnRequests; the NameID is the stable
+	// subject the account is linked to, so it should be persistent
+	NameIDFormat string
+	// EmailAttribute, NameAttribute and GroupAttribute are matched against the
+	// Name or FriendlyName of the assertion's attributes
+	EmailAttribute string
+	NameAttribute  string
+	GroupAttribute string
+	GroupRoles     GroupRoles
+	DefaultRole    string
+}
+
+// SAMLAssertion is the identity asserted by the identity provider
+type SAMLAssertion struct {
+	Subject string
+	Email   string
+	Name    string
+	Groups  []string
+}
+
+// SAMLProvider builds AuthnRequests and verifies the responses to them
+type SAMLProvider struct {
+	config *SAMLConfig
+	sp     *saml.ServiceProvider
+}
+
+func NewSAMLProvider(config *SAMLConfig) (*SAMLProvider, error) {
+	metadataURL, err := neturl.Parse(config.MetadataURL)
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := neturl.Parse(config.ACSURL)
+	if err != nil {
+		return nil, err
+	}
+	sp := &saml.ServiceProvider{
+		EntityID:          config.EntityID,
+		Key:               config.Key,
+		Certificate:       config.Certificate,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       config.IDPMetadata,
+		AuthnNameIDFormat: saml.NameIDFormat(config.NameIDFormat),
+		// Only responses to our own AuthnRequests are accepted
+		AllowIDPInitiated: false,
+	}
+	if sp.GetSSOBindingLocation(saml.HTTPRedirectBinding) == "" {
+		return nil, errors.New("the SAML identity provider metadata has no HTTP-Redirect single sign-on service")
+	}
+	return &SAMLProvider{config: config, sp: sp}, nil
+}
+
+// Metadata returns the XML metadata to register this service provider with
+func (p *SAMLProvider) Metadata() ([]byte, error) {
+	body, err := xml.MarshalIndent(p.sp.Metadata(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// AuthnRequestURL returns the identity provider URL to redirect the browser to
+// and the ID of the request, which the response must be in reply to
+func (p *SAMLProvider) AuthnRequestURL(relayState string) (string, string, error) {
+	request, err := p.sp.MakeAuthenticationRequest(
+		p.sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", "", err
+	}
+	redirect, err := request.Redirect(relayState, p.sp)
+	if err != nil {
+		return "", "", err
+	}
+	return redirect.String(), request.ID, nil
+}
+
+// ParseResponse verifies the base64 encoded SAMLResponse posted to the ACS:
+// the signature against the identity provider's certificates, the issuer,
+// destination, audience, validity window and that it answers requestID.
+func (p *SAMLProvider) ParseResponse(encoded, requestID string) (*SAMLAssertion, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrSAMLLoginFailed
+	}
+	assertion, err := p.sp.ParseXMLResponse(raw, []string{requestID}, p.sp.AcsURL)
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		log.Printf("SAML response rejected: %v", err)
+		return nil, ErrSAMLLoginFailed
+	}
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		log.Printf("SAML response rejected: assertion has no NameID")
+		return nil, ErrSAMLLoginFailed
+	}
+
+	result := &SAMLAssertion{Subject: assertion.Subject.NameID.Value}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			values := make([]string, 0, len(attribute.Values))
+			for _, value := range attribute.Values {
+				if v := strings.TrimSpace(value.Value); v != "" {
+					values = append(values, v)
+				}
+			}
+			if len(values) == 0 {
+				continue
+			}
+			switch {
+			case samlAttributeIs(attribute, p.config.EmailAttribute):
+				result.Email = values[0]
+			case samlAttributeIs(attribute, p.config.NameAttribute):
+				result.Name = values[0]
+			case samlAttributeIs(attribute, p.config.GroupAttribute):
+				result.Groups = append(result.Groups, values...)
+			}
+		}
+	}
+	if result.Email == "" && assertion.Subject.NameID.Format == string(saml.EmailAddressNameIDFormat) {
+		result.Email = result.Subject
+	}
+	return result, nil
+}
+
+func samlAttributeIs(attribute saml.Attribute, name string) bool {
+	return name != "" && (strings.EqualFold(attribute.Name, name) || strings.EqualFold(attribute.FriendlyName, name))
+}
+
+var (
+	defaultSAMLProvider     *SAMLProvider
+	defaultSAMLProviderErr  error
+	defaultSAMLProviderOnce sync.Once
+)
+
+// DefaultSAMLProvider returns the process wide service provider, or nil when
+// SAML is not configured
+func DefaultSAMLProvider() (*SAMLProvider, error) {
+	defaultSAMLProviderOnce.Do(func() {
+		config, err := LoadSAMLConfigFromEnv()
+		if err != nil || config == nil {
+			defaultSAMLProviderErr = err
+			return
+		}
+		defaultSAMLProvider, defaultSAMLProviderErr = NewSAMLProvider(config)
+	})
+	return defaultSAMLProvider, defaultSAMLProviderErr
+}
+
+// LoadSAMLConfigFromEnv reads the identity provider metadata from
+// SAML_IDP_METADATA_FILE or SAML_IDP_METADATA_URL and the service provider's
+// PEM certificate and key from SAML_SP_CERT_FILE and SAML_SP_KEY_FILE. The
+// entity ID defaults to the metadata URL, <URL>/api/saml/metadata, and
+// assertions are posted to <URL>/api/saml/acs. Returns nil when no identity
+// provider metadata is configured.
+func LoadSAMLConfigFromEnv() (*SAMLConfig, error) {
+	metadataFile, metadataURL := os.Getenv("SAML_IDP_METADATA_FILE"), os.Getenv("SAML_IDP_METADATA_URL")
+	if metadataFile == "" && metadataURL == "" {
+		return nil, nil
+	}
+
+	var data []byte
+	var err error
+	if metadataFile != "" {
+		data, err = os.ReadFile(metadataFile)
+	} else {
+		data, err = fetchSAMLMetadata(metadataURL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SAML identity provider metadata: %w", err)
+	}
+	idpMetadata := &saml.EntityDescriptor{}
+	if err := xml.Unmarshal(data, idpMetadata); err != nil {
+		return nil, fmt.Errorf("invalid SAML identity provider metadata: %w", err)
+	}
+
+	certFile, keyFile := os.Getenv("SAML_SP_CERT_FILE"), os.Getenv("SAML_SP_KEY_FILE")
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("SAML needs SAML_SP_CERT_FILE and SAML_SP_KEY_FILE")
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the SAML service provider key pair: %w", err)
+	}
+	certificate, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("the SAML service provider key cannot sign")
+	}
+
+	groupRoles, err := ParseGroupRoles("SAML_GROUP_ROLES", os.Getenv("SAML_GROUP_ROLES"))
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := strings.TrimSuffix(os.Getenv("URL"), "/") + "/api/saml"
+	return &SAMLConfig{
+		EntityID:       utils.GetEnv("SAML_ENTITY_ID", baseURL+"/metadata"),
+		ACSURL:         baseURL + "/acs",
+		MetadataURL:    baseURL + "/metadata",
+		Certificate:    certificate,
+		Key:            key,
+		IDPMetadata:    idpMetadata,
+		NameIDFormat:   utils.GetEnv("SAML_NAMEID_FORMAT", string(saml.PersistentNameIDFormat)),
+		EmailAttribute: utils.GetEnv("SAML_EMAIL_ATTRIBUTE", "email"),
+		NameAttribute:  utils.GetEnv("SAML_NAME_ATTRIBUTE", "name"),
+		GroupAttribute: utils.GetEnv("SAML_GROUP_ATTRIBUTE", "groups"),
+		GroupRoles:     groupRoles,
+		DefaultRole:    utils.GetEnv("SAML_DEFAULT_ROLE", "user"),
+	}, nil
+}
+
+func fetchSAMLMetadata(metadataURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
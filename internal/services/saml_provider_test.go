@@ -0,0 +1,243 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/logger"
+)
+
+// mockSAMLIdP is an in-process identity provider that answers AuthnRequests
+// with a signed response for a fixed session
+type mockSAMLIdP struct {
+	t       *testing.T
+	idp     *saml.IdentityProvider
+	sp      *SAMLProvider
+	session *saml.Session
+
+	// plaintext leaves the SP's encryption key out of its metadata so the
+	// assertion is only signed, not encrypted
+	plaintext bool
+}
+
+func newTestCertificate(t *testing.T, commonName string) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, certificate
+}
+
+func newMockSAMLIdP(t *testing.T) *mockSAMLIdP {
+	idpKey, idpCertificate := newTestCertificate(t, "idp.example.com")
+	metadataURL, _ := neturl.Parse("https://idp.example.com/metadata")
+	ssoURL, _ := neturl.Parse("https://idp.example.com/sso")
+
+	m := &mockSAMLIdP{
+		t: t,
+		session: &saml.Session{
+			ID:           "session-1",
+			NameID:       "alice-persistent-id",
+			NameIDFormat: string(saml.PersistentNameIDFormat),
+			CustomAttributes: []saml.Attribute{
+				{Name: "email", Values: []saml.AttributeValue{{Type: "xs:string", Value: "alice@example.com"}}},
+				{Name: "name", Values: []saml.AttributeValue{{Type: "xs:string", Value: "Alice Example"}}},
+				{Name: "groups", Values: []saml.AttributeValue{
+					{Type: "xs:string", Value: "Engineering"},
+					{Type: "xs:string", Value: "Admins"},
+				}},
+			},
+		},
+	}
+	m.idp = &saml.IdentityProvider{
+		Key:                     idpKey,
+		Certificate:             idpCertificate,
+		Logger:                  logger.DefaultLogger,
+		MetadataURL:             *metadataURL,
+		SSOURL:                  *ssoURL,
+		ServiceProviderProvider: m,
+		SessionProvider:         m,
+	}
+
+	spKey, spCertificate := newTestCertificate(t, "sp.example.com")
+	groupRoles, err := ParseGroupRoles("SAML_GROUP_ROLES", "admins=>admin;engineering=>editor;finance=>accountant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.sp, err = NewSAMLProvider(&SAMLConfig{
+		EntityID:       "https://sp.example.com/api/saml/metadata",
+		ACSURL:         "https://sp.example.com/api/saml/acs",
+		MetadataURL:    "https://sp.example.com/api/saml/metadata",
+		Certificate:    spCertificate,
+		Key:            spKey,
+		IDPMetadata:    m.idp.Metadata(),
+		NameIDFormat:   string(saml.PersistentNameIDFormat),
+		EmailAttribute: "email",
+		NameAttribute:  "name",
+		GroupAttribute: "groups",
+		GroupRoles:     groupRoles,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func (m *mockSAMLIdP) GetServiceProvider(r *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	metadata := m.sp.sp.Metadata()
+	if m.plaintext {
+		for i := range metadata.SPSSODescriptors {
+			var keys []saml.KeyDescriptor
+			for _, key := range metadata.SPSSODescriptors[i].KeyDescriptors {
+				if key.Use != "encryption" {
+					keys = append(keys, key)
+				}
+			}
+			metadata.SPSSODescriptors[i].KeyDescriptors = keys
+		}
+	}
+	return metadata, nil
+}
+
+func (m *mockSAMLIdP) GetSession(w http.ResponseWriter, r *http.Request, req *saml.IdpAuthnRequest) *saml.Session {
+	return m.session
+}
+
+// respond follows the redirect of an AuthnRequest and returns the SAMLResponse
+// the identity provider would post to the ACS
+func (m *mockSAMLIdP) respond(redirectURL string) string {
+	req, err := saml.NewIdpAuthnRequest(m.idp, httptest.NewRequest(http.MethodGet, redirectURL, nil))
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	if err := req.Validate(); err != nil {
+		m.t.Fatal(err)
+	}
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, m.session); err != nil {
+		m.t.Fatal(err)
+	}
+	form, err := req.PostBinding()
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return form.SAMLResponse
+}
+
+func TestSAMLProviderParsesSignedResponse(t *testing.T) {
+	idp := newMockSAMLIdP(t)
+	redirectURL, requestID, err := idp.sp.AuthnRequestURL("relay-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertion, err := idp.sp.ParseResponse(idp.respond(redirectURL), requestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion.Subject != "alice-persistent-id" || assertion.Email != "alice@example.com" || assertion.Name != "Alice Example" {
+		t.Fatalf("unexpected assertion %+v", assertion)
+	}
+	roles := idp.sp.config.GroupRoles.Mapped(assertion.Groups)
+	if !reflect.DeepEqual(roles, []string{"admin", "editor"}) {
+		t.Fatalf("expected the groups to map to admin and editor, got %v", roles)
+	}
+}
+
+func TestSAMLProviderRejectsResponseToOtherRequest(t *testing.T) {
+	idp := newMockSAMLIdP(t)
+	redirectURL, _, err := idp.sp.AuthnRequestURL("relay-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherID, err := idp.sp.AuthnRequestURL("other-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := idp.sp.ParseResponse(idp.respond(redirectURL), otherID); !errors.Is(err, ErrSAMLLoginFailed) {
+		t.Fatalf("expected ErrSAMLLoginFailed, got %v", err)
+	}
+}
+
+func TestSAMLProviderRejectsTamperedResponse(t *testing.T) {
+	idp := newMockSAMLIdP(t)
+	idp.plaintext = true
+	redirectURL, requestID, err := idp.sp.AuthnRequestURL("relay-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := idp.respond(redirectURL)
+	if _, err := idp.sp.ParseResponse(response, requestID); err != nil {
+		t.Fatalf("expected the unmodified response to be accepted, got %v", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.ReplaceAll(raw, []byte("alice@example.com"), []byte("admin@example.com"))
+	if bytes.Equal(raw, tampered) {
+		t.Fatal("response does not contain the email")
+	}
+	_, err = idp.sp.ParseResponse(base64.StdEncoding.EncodeToString(tampered), requestID)
+	if !errors.Is(err, ErrSAMLLoginFailed) {
+		t.Fatalf("expected ErrSAMLLoginFailed, got %v", err)
+	}
+}
+
+func TestSAMLProviderRejectsUntrustedSigner(t *testing.T) {
+	idp := newMockSAMLIdP(t)
+	redirectURL, requestID, err := idp.sp.AuthnRequestURL("relay-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same identity provider metadata, but the response is signed with another key
+	idp.idp.Key, idp.idp.Certificate = newTestCertificate(t, "idp.example.com")
+	if _, err := idp.sp.ParseResponse(idp.respond(redirectURL), requestID); !errors.Is(err, ErrSAMLLoginFailed) {
+		t.Fatalf("expected ErrSAMLLoginFailed, got %v", err)
+	}
+}
+
+func TestSAMLProviderMetadata(t *testing.T) {
+	idp := newMockSAMLIdP(t)
+	metadata, err := idp.sp.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`entityID="https://sp.example.com/api/saml/metadata"`,
+		`Location="https://sp.example.com/api/saml/acs"`,
+	} {
+		if !bytes.Contains(metadata, []byte(want)) {
+			t.Fatalf("metadata does not contain %s:\n%s", want, metadata)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SAMLLoginStateTTL bounds the time a user may spend at the identity provider
+const SAMLLoginStateTTL = 10 * time.Minute
+
+// samlProviderName is the ExternalIdentity provider of SAML logins
+const samlProviderName = "saml"
+
+var (
+	ErrSAMLNotConfigured      = errors.New("SAML single sign-on is not configured")
+	ErrInvalidSAMLState       = errors.New("invalid or expired login state")
+	ErrSAMLEmailMissing       = errors.New("the identity provider did not send an email address")
+	ErrSAMLAccountNotVerified = errors.New("an account with this email exists but is not verified, please verify it first")
+)
+
+// SAMLLogin is a started SAML login. State is sent as the RelayState and must
+// come back from the same browser.
+type SAMLLogin struct {
+	RedirectURL string
+	State       string
+}
+
+type SAMLService interface {
+	Metadata() ([]byte, error)
+	// Start returns the identity provider URL to redirect the browser to
+	Start() (*SAMLLogin, error)
+	// ACS verifies the SAMLResponse answering the request identified by
+	// relayState and returns the linked or provisioned user with Roles preloaded
+	ACS(samlResponse, relayState string) (*models.User, error)
+}
+
+type samlService struct {
+	db       database.Service
+	provider *SAMLProvider
+}
+
+func NewSAMLService() SAMLService {
+	// Provider configuration is validated when the server starts
+	provider, _ := DefaultSAMLProvider()
+	return &samlService{
+		db:       database.New(),
+		provider: provider,
+	}
+}
+
+func (s *samlService) Metadata() ([]byte, error) {
+	if s.provider == nil {
+		return nil, ErrSAMLNotConfigured
+	}
+	return s.provider.Metadata()
+}
+
+func (s *samlService) Start() (*SAMLLogin, error) {
+	if s.provider == nil {
+		return nil, ErrSAMLNotConfigured
+	}
+
+	state, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	redirectURL, requestID, err := s.provider.AuthnRequestURL(state)
+	if err != nil {
+		return nil, err
+	}
+
+	loginState := models.SAMLLoginState{
+		StateHash: utils.HashToken(state),
+		RequestID: requestID,
+		ExpiresAt: time.Now().Add(SAMLLoginStateTTL),
+	}
+	if err := s.db.GetDB().Create(&loginState).Error; err != nil {
+		return nil, err
+	}
+	return &SAMLLogin{RedirectURL: redirectURL, State: state}, nil
+}
+
+func (s *samlService) ACS(samlResponse, relayState string) (*models.User, error) {
+	if s.provider == nil {
+		return nil, ErrSAMLNotConfigured
+	}
+
+	loginState, err := s.consumeState(relayState)
+	if err != nil {
+		return nil, err
+	}
+	assertion, err := s.provider.ParseResponse(samlResponse, loginState.RequestID)
+	if err != nil {
+		return nil, err
+	}
+	return s.resolveUser(assertion)
+}
+
+// consumeState deletes the login state so each AuthnRequest is only answered once
+func (s *samlService) consumeState(relayState string) (*models.SAMLLoginState, error) {
+	var loginState models.SAMLLoginState
+	err := s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("state_hash = ?", utils.HashToken(relayState)).
+			First(&loginState).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidSAMLState
+		} else if err != nil {
+			return err
+		}
+		return tx.Delete(&loginState).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(loginState.ExpiresAt) {
+		return nil, ErrInvalidSAMLState
+	}
+	return &loginState, nil
+}
+
+// resolveUser finds the user linked to the asserted NameID, or links the
+// account with the asserted email, or provisions one with SAML_DEFAULT_ROLE.
+// The name follows the identity provider and mapped roles are re-synced on
+// every login.
+func (s *samlService) resolveUser(assertion *SAMLAssertion) (*models.User, error) {
+	config := s.provider.config
+	var user models.User
+	err := s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var identity models.ExternalIdentity
+		err := tx.Where("provider = ? AND subject = ?", samlProviderName, assertion.Subject).First(&identity).Error
+		if err == nil {
+			err := tx.Where("id = ? AND status = ?", identity.UserID, "active").First(&user).Error
+			if err := linkedUserError(err, ErrSAMLLoginFailed); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		} else {
+			if err := s.linkUser(tx, &user, assertion); err != nil {
+				return err
+			}
+		}
+
+		if name := strings.TrimSpace(assertion.Name); name != "" && name != user.Name && len([]rune(name)) <= 100 {
+			if err := tx.Model(&user).Update("name", name).Error; err != nil {
+				return err
+			}
+		}
+
+		changed, err := syncManagedRoles(tx, user.ID, config.GroupRoles.Managed(), config.GroupRoles.Mapped(assertion.Groups))
+		if err != nil {
+			return err
+		}
+		if changed {
+			return bumpUserAuthzVersion(tx, user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.GetDB().Preload("Roles").First(&user, user.ID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// linkUser links a NameID seen for the first time to the account with the
+// asserted email, provisioning it if needed
+func (s *samlService) linkUser(tx *gorm.DB, user *models.User, assertion *SAMLAssertion) error {
+	email := strings.TrimSpace(assertion.Email)
+	if email == "" {
+		return ErrSAMLEmailMissing
+	}
+
+	err := tx.Where("LOWER(email) = LOWER(?)", email).First(user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if err := provisionUser(tx, user, email, assertion.Name, s.provider.config.DefaultRole); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if user.Status != "active" {
+		// The local password was never proven to belong to the email owner
+		return ErrSAMLAccountNotVerified
+	}
+
+	return tx.Create(&models.ExternalIdentity{
+		UserID:   user.ID,
+		Provider: samlProviderName,
+		Subject:  assertion.Subject,
+		Email:    email,
+	}).Error
+}
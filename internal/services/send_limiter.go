@@ -0,0 +1,89 @@
+package services
+
+import (
+	"Admin-gin/internal/utils"
+	"errors"
+	"time"
+)
+
+var ErrTooManyRequests = errors.New("too many requests, please try again later")
+
+// SendLimit caps the emails unauthenticated requests can trigger
+type SendLimit struct {
+	// Window after which the counters start over
+	Window time.Duration
+	// PerEmail sends to one address within the window
+	PerEmail int
+	// PerIP sends requested by one client IP within the window
+	PerIP int
+}
+
+// DefaultMagicLinkSendLimit reads the limit on sign-in links from
+// MAGIC_LINK_* environment variables
+func DefaultMagicLinkSendLimit() SendLimit {
+	return SendLimit{
+		Window:   utils.GetEnvDuration("MAGIC_LINK_SEND_WINDOW", time.Hour),
+		PerEmail: utils.GetEnvInt("MAGIC_LINK_MAX_PER_EMAIL", 5),
+		PerIP:    utils.GetEnvInt("MAGIC_LINK_MAX_PER_IP", 20),
+	}
+}
+
+// SendLimiter counts emails sent per address and per client IP
+type SendLimiter interface {
+	// Allow records a send to email requested from ip, or returns
+	// ErrTooManyRequests and the remaining wait once either limit is reached
+	Allow(email, ip string) (time.Duration, error)
+}
+
+type sendLimiter struct {
+	store  LoginAttemptStore
+	prefix string
+	limit  SendLimit
+}
+
+// NewSendLimiter keeps its counters in the login attempt store under prefix,
+// apart from failed logins
+func NewSendLimiter(store LoginAttemptStore, prefix string, limit SendLimit) SendLimiter {
+	return &sendLimiter{
+		store:  store,
+		prefix: prefix,
+		limit:  limit,
+	}
+}
+
+func (l *sendLimiter) Allow(email, ip string) (time.Duration, error) {
+	keys := []string{l.prefix + accountKey(email), l.prefix + ipKey(ip)}
+	limits := []int{l.limit.PerEmail, l.limit.PerIP}
+
+	var wait time.Duration
+	for _, key := range keys {
+		attempt, err := l.store.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		if attempt == nil {
+			continue
+		}
+		if remaining := time.Until(attempt.BlockedUntil); remaining > wait {
+			wait = remaining
+		}
+	}
+	if wait > 0 {
+		return wait, ErrTooManyRequests
+	}
+
+	// The send that reaches a limit blocks the key for the rest of the window
+	for i, key := range keys {
+		limit := limits[i]
+		_, err := l.store.RecordFailure(key, l.limit.Window, func(sends int) time.Duration {
+			if limit > 0 && sends >= limit {
+				return l.limit.Window
+			}
+			return 0
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
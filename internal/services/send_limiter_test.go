@@ -0,0 +1,44 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSendLimiterLimitsEmailAndIP(t *testing.T) {
+	limiter := NewSendLimiter(NewMemoryLoginAttemptStore(), "magic:", SendLimit{Window: time.Hour, PerEmail: 3, PerIP: 5})
+
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.Allow("user@example.com", "10.0.0.1"); err != nil {
+			t.Fatalf("send %d: expected to be allowed, got %v", i+1, err)
+		}
+	}
+	wait, err := limiter.Allow("USER@example.com", "10.0.0.2")
+	if !errors.Is(err, ErrTooManyRequests) || wait < 59*time.Minute {
+		t.Fatalf("expected the 4th send to the address to be refused for the window, got %s %v", wait, err)
+	}
+
+	// Two more addresses from the same IP reach its limit
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		if _, err := limiter.Allow(email, "10.0.0.1"); err != nil {
+			t.Fatalf("expected %s to be allowed, got %v", email, err)
+		}
+	}
+	if _, err := limiter.Allow("c@example.com", "10.0.0.1"); !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("expected the IP to be limited, got %v", err)
+	}
+}
+
+func TestSendLimiterIsSeparateFromLoginThrottle(t *testing.T) {
+	store := NewMemoryLoginAttemptStore()
+	limiter := NewSendLimiter(store, "magic:", SendLimit{Window: time.Hour, PerEmail: 1, PerIP: 1})
+	throttle := NewLoginThrottle(store, testLockoutPolicy(), nil)
+
+	if _, err := limiter.Allow("user@example.com", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := throttle.Check("user@example.com", "10.0.0.1"); err != nil {
+		t.Fatalf("expected sign-in links not to lock password logins, got %v", err)
+	}
+}
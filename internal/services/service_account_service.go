@@ -0,0 +1,383 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ServiceAccountKeyPrefix starts every service account API key
+const ServiceAccountKeyPrefix = "agsa_"
+
+var (
+	ErrServiceAccountNotFound   = errors.New("service account not found")
+	ErrServiceAccountExists     = errors.New("a service account with this name already exists")
+	ErrInvalidServiceAccountKey = errors.New("invalid service account key")
+	ErrUnknownRole              = errors.New("one or more roles do not exist")
+	ErrRolesNotHeld             = errors.New("you cannot give a service account permissions you do not hold")
+)
+
+// IsServiceAccountKey reports whether the credential looks like a service account API key
+func IsServiceAccountKey(key string) bool {
+	return strings.HasPrefix(key, ServiceAccountKeyPrefix)
+}
+
+// ServiceAccountInput creates a service account
+type ServiceAccountInput struct {
+	Name        string `json:"name" binding:"required,max=100"`
+	Description string `json:"description" binding:"max=255"`
+	RoleIDs     []uint `json:"role_ids"`
+}
+
+// ServiceAccountRolesInput replaces the roles of a service account
+type ServiceAccountRolesInput struct {
+	RoleIDs []uint `json:"role_ids" binding:"required"`
+}
+
+// ServiceAccountCredentials are returned once when a service account is
+// created or its credentials are rotated
+type ServiceAccountCredentials struct {
+	APIKey       string `json:"api_key"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// ServiceAccountResponse is a service account as shown to administrators
+type ServiceAccountResponse struct {
+	ID            uint       `json:"id"`
+	Name          string     `json:"name"`
+	Description   string     `json:"description"`
+	Status        string     `json:"status"`
+	Roles         []string   `json:"roles"`
+	ClientID      string     `json:"client_id"`
+	KeyPrefix     string     `json:"key_prefix"`
+	KeyLastUsedAt *time.Time `json:"key_last_used_at"`
+	KeyLastUsedIP string     `json:"key_last_used_ip"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+type ServiceAccountService interface {
+	// CreateAccount and SetRoles refuse roles with permissions actorGrants do
+	// not allow on every record, so nobody can mint credentials more
+	// privileged than themselves
+	CreateAccount(input *ServiceAccountInput, actorGrants []utils.Grant) (*ServiceAccountResponse, *ServiceAccountCredentials, error)
+	GetAccounts() ([]ServiceAccountResponse, error)
+	GetAccount(id uint) (*ServiceAccountResponse, error)
+	SetDisabled(id uint, disabled bool) error
+	SetRoles(id uint, roleIDs []uint, actorGrants []utils.Grant) error
+	// RotateCredentials issues a new API key and client secret and revokes the previous ones
+	RotateCredentials(id uint) (*ServiceAccountCredentials, error)
+	// AuthenticateKey resolves an API key to its active service account, with
+	// roles loaded, and records where the key was used from
+	AuthenticateKey(key, ipAddress string) (*models.ServiceAccount, error)
+	// GetActiveAccount returns the service account with its roles unless it is disabled
+	GetActiveAccount(id uint) (*models.ServiceAccount, error)
+}
+
+type serviceAccountService struct {
+	db database.Service
+}
+
+func NewServiceAccountService() ServiceAccountService {
+	return &serviceAccountService{
+		db: database.New(),
+	}
+}
+
+func findRoles(db *gorm.DB, roleIDs []uint) ([]models.Role, error) {
+	if len(roleIDs) == 0 {
+		return []models.Role{}, nil
+	}
+	var roles []models.Role
+	if err := db.Where("id IN ?", roleIDs).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	unique := make(map[uint]bool, len(roleIDs))
+	for _, id := range roleIDs {
+		unique[id] = true
+	}
+	if len(roles) != len(unique) {
+		return nil, ErrUnknownRole
+	}
+	return roles, nil
+}
+
+// checkRolesHeld returns ErrRolesNotHeld unless actorGrants cover the
+// effective grants of the roles
+func checkRolesHeld(db *gorm.DB, roleIDs []uint, actorGrants []utils.Grant) error {
+	grants, err := utils.GetRoleGrants(db, roleIDs...)
+	if err != nil {
+		return err
+	}
+	if !utils.CoversGrants(actorGrants, grants) {
+		return ErrRolesNotHeld
+	}
+	return nil
+}
+
+// issueServiceAccountKey revokes the current keys of the account and stores a new one
+func issueServiceAccountKey(tx *gorm.DB, accountID uint) (string, error) {
+	err := tx.Model(&models.ServiceAccountKey{}).
+		Where("service_account_id = ? AND revoked_at IS NULL", accountID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	key := ServiceAccountKeyPrefix + secret
+	err = tx.Create(&models.ServiceAccountKey{
+		ServiceAccountID: accountID,
+		Prefix:           key[:len(ServiceAccountKeyPrefix)+6],
+		KeyHash:          utils.HashToken(key),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (s *serviceAccountService) CreateAccount(input *ServiceAccountInput, actorGrants []utils.Grant) (*ServiceAccountResponse, *ServiceAccountCredentials, error) {
+	var account models.ServiceAccount
+	credentials := &ServiceAccountCredentials{}
+	err := s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.ServiceAccount{}).Where("name = ?", input.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrServiceAccountExists
+		}
+
+		roles, err := findRoles(tx, input.RoleIDs)
+		if err != nil {
+			return err
+		}
+		if err := checkRolesHeld(tx, input.RoleIDs, actorGrants); err != nil {
+			return err
+		}
+		account = models.ServiceAccount{
+			Name:        input.Name,
+			Description: input.Description,
+			Status:      models.ServiceAccountActive,
+			Roles:       roles,
+		}
+		if err := tx.Create(&account).Error; err != nil {
+			return err
+		}
+
+		if credentials.APIKey, err = issueServiceAccountKey(tx, account.ID); err != nil {
+			return err
+		}
+		if credentials.ClientID, err = utils.GenerateRandomToken(16); err != nil {
+			return err
+		}
+		if credentials.ClientSecret, err = utils.GenerateRandomToken(32); err != nil {
+			return err
+		}
+		return tx.Create(&models.OAuthClient{
+			ClientID:         credentials.ClientID,
+			SecretHash:       utils.HashToken(credentials.ClientSecret),
+			Name:             "Service account " + account.Name,
+			Type:             models.OAuthClientConfidential,
+			GrantTypes:       models.GrantClientCredentials,
+			ServiceAccountID: &account.ID,
+		}).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := s.GetAccount(account.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return response, credentials, nil
+}
+
+func (s *serviceAccountService) loadAccounts(query *gorm.DB) ([]ServiceAccountResponse, error) {
+	var accounts []models.ServiceAccount
+	if err := query.Preload("Roles").Order("id").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return []ServiceAccountResponse{}, nil
+	}
+
+	ids := make([]uint, len(accounts))
+	for i := range accounts {
+		ids[i] = accounts[i].ID
+	}
+	var clients []models.OAuthClient
+	if err := s.db.GetDB().Where("service_account_id IN ?", ids).Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	var keys []models.ServiceAccountKey
+	if err := s.db.GetDB().Where("service_account_id IN ? AND revoked_at IS NULL", ids).Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	responses := make([]ServiceAccountResponse, len(accounts))
+	for i, account := range accounts {
+		roles := make([]string, len(account.Roles))
+		for j, role := range account.Roles {
+			roles[j] = role.Name
+		}
+		responses[i] = ServiceAccountResponse{
+			ID:          account.ID,
+			Name:        account.Name,
+			Description: account.Description,
+			Status:      account.Status,
+			Roles:       roles,
+			CreatedAt:   account.CreatedAt,
+			UpdatedAt:   account.UpdatedAt,
+		}
+		for _, client := range clients {
+			if *client.ServiceAccountID == account.ID {
+				responses[i].ClientID = client.ClientID
+			}
+		}
+		for _, key := range keys {
+			if key.ServiceAccountID == account.ID {
+				responses[i].KeyPrefix = key.Prefix
+				responses[i].KeyLastUsedAt = key.LastUsedAt
+				responses[i].KeyLastUsedIP = key.LastUsedIP
+			}
+		}
+	}
+	return responses, nil
+}
+
+func (s *serviceAccountService) GetAccounts() ([]ServiceAccountResponse, error) {
+	return s.loadAccounts(s.db.GetDB())
+}
+
+func (s *serviceAccountService) GetAccount(id uint) (*ServiceAccountResponse, error) {
+	accounts, err := s.loadAccounts(s.db.GetDB().Where("id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return nil, ErrServiceAccountNotFound
+	}
+	return &accounts[0], nil
+}
+
+func (s *serviceAccountService) SetDisabled(id uint, disabled bool) error {
+	status := models.ServiceAccountActive
+	if disabled {
+		status = models.ServiceAccountDisabled
+	}
+	result := s.db.GetDB().Model(&models.ServiceAccount{}).Where("id = ?", id).Update("status", status)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrServiceAccountNotFound
+	}
+	return nil
+}
+
+func (s *serviceAccountService) SetRoles(id uint, roleIDs []uint, actorGrants []utils.Grant) error {
+	return s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var account models.ServiceAccount
+		err := tx.First(&account, id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrServiceAccountNotFound
+		} else if err != nil {
+			return err
+		}
+
+		roles, err := findRoles(tx, roleIDs)
+		if err != nil {
+			return err
+		}
+		if err := checkRolesHeld(tx, roleIDs, actorGrants); err != nil {
+			return err
+		}
+		return tx.Model(&account).Association("Roles").Replace(roles)
+	})
+}
+
+func (s *serviceAccountService) RotateCredentials(id uint) (*ServiceAccountCredentials, error) {
+	credentials := &ServiceAccountCredentials{}
+	err := s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var client models.OAuthClient
+		err := tx.Where("service_account_id = ?", id).First(&client).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrServiceAccountNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if credentials.APIKey, err = issueServiceAccountKey(tx, id); err != nil {
+			return err
+		}
+		if credentials.ClientSecret, err = utils.GenerateRandomToken(32); err != nil {
+			return err
+		}
+		credentials.ClientID = client.ClientID
+		return tx.Model(&client).Update("secret_hash", utils.HashToken(credentials.ClientSecret)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (s *serviceAccountService) AuthenticateKey(key, ipAddress string) (*models.ServiceAccount, error) {
+	if !IsServiceAccountKey(key) {
+		return nil, ErrInvalidServiceAccountKey
+	}
+
+	var stored models.ServiceAccountKey
+	err := s.db.GetDB().Where("key_hash = ? AND revoked_at IS NULL", utils.HashToken(key)).First(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidServiceAccountKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	account, err := s.GetActiveAccount(stored.ServiceAccountID)
+	if errors.Is(err, ErrServiceAccountNotFound) {
+		return nil, ErrInvalidServiceAccountKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= credentialTouchInterval || stored.LastUsedIP != ipAddress {
+		err := s.db.GetDB().Model(&models.ServiceAccountKey{}).
+			Where("id = ?", stored.ID).
+			Updates(map[string]interface{}{
+				"last_used_at": now,
+				"last_used_ip": ipAddress,
+			}).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return account, nil
+}
+
+func (s *serviceAccountService) GetActiveAccount(id uint) (*models.ServiceAccount, error) {
+	var account models.ServiceAccount
+	err := s.db.GetDB().Preload("Roles").
+		Where("id = ? AND status = ?", id, models.ServiceAccountActive).
+		First(&account).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrServiceAccountNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
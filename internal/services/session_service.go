@@ -0,0 +1,151 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+type SessionService interface {
+	// CreateSession starts a session for a user who just signed in with the
+	// given amr methods
+	CreateSession(userID uint, ipAddress, userAgent string, methods []string) (*models.Session, error)
+	GetSession(id string) (*models.Session, error)
+	TouchSession(id, tokenID string, expiresAt time.Time) error
+	// Reauthenticate records that the user proved their identity again
+	Reauthenticate(id string, methods []string) (*models.Session, error)
+	GetUserSessions(userID uint) ([]models.Session, error)
+	RevokeSession(userID uint, id string) (*models.Session, error)
+	RevokeUserSessions(userID uint) error
+}
+
+type sessionService struct {
+	db database.Service
+}
+
+func NewSessionService() SessionService {
+	return &sessionService{
+		db: database.New(),
+	}
+}
+
+func (s *sessionService) CreateSession(userID uint, ipAddress, userAgent string, methods []string) (*models.Session, error) {
+	now := time.Now()
+	session := models.Session{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		IPAddress:   ipAddress,
+		UserAgent:   userAgent,
+		AuthTime:    now,
+		AuthMethods: strings.Join(methods, " "),
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(RefreshTokenTTL()),
+	}
+	if err := s.db.GetDB().Create(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetSession returns a session that has not been revoked
+func (s *sessionService) GetSession(id string) (*models.Session, error) {
+	var session models.Session
+	err := s.db.GetDB().Where("id = ? AND revoked_at IS NULL", id).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *sessionService) Reauthenticate(id string, methods []string) (*models.Session, error) {
+	session, err := s.GetSession(id)
+	if err != nil {
+		return nil, err
+	}
+	session.AuthTime = time.Now()
+	session.AuthMethods = strings.Join(methods, " ")
+	err = s.db.GetDB().Model(session).Updates(map[string]interface{}{
+		"auth_time":    session.AuthTime,
+		"auth_methods": session.AuthMethods,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// TouchSession records the access token most recently issued for the session
+func (s *sessionService) TouchSession(id, tokenID string, expiresAt time.Time) error {
+	return s.db.GetDB().Model(&models.Session{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"token_id":     tokenID,
+			"last_seen_at": time.Now(),
+			"expires_at":   expiresAt,
+		}).Error
+}
+
+// GetUserSessions returns the sessions of the user that are neither revoked nor expired
+func (s *sessionService) GetUserSessions(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := s.db.GetDB().
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession terminates a single session so its refresh tokens stop working.
+// Callers must also revoke the session in the RevocationStore.
+func (s *sessionService) RevokeSession(userID uint, id string) (*models.Session, error) {
+	var session models.Session
+	err := s.db.GetDB().Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&session).Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.RefreshToken{}).
+			Where("session_id = ? AND revoked_at IS NULL", session.ID).
+			Update("revoked_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// RevokeUserSessions terminates every session of the user. Callers must also
+// revoke the user in the RevocationStore to invalidate outstanding access tokens.
+func (s *sessionService) RevokeUserSessions(userID uint) error {
+	now := time.Now()
+	return s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error
+	})
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"Admin-gin/internal/database"
+	"Admin-gin/internal/models"
+	"Admin-gin/internal/utils"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, please login again")
+)
+
+type TokenService interface {
+	IssueRefreshToken(userID uint, sessionID string) (string, error)
+	RotateRefreshToken(token string) (*models.RefreshToken, string, error)
+	RevokeRefreshToken(token string) error
+}
+
+type tokenService struct {
+	db database.Service
+}
+
+func NewTokenService() TokenService {
+	return &tokenService{
+		db: database.New(),
+	}
+}
+
+// RefreshTokenTTL is the lifetime of refresh tokens, configured with REFRESH_TOKEN_TTL
+func RefreshTokenTTL() time.Duration {
+	return utils.GetEnvDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour)
+}
+
+// IssueRefreshToken starts a new token family for the session, typically at login
+func (s *tokenService) IssueRefreshToken(userID uint, sessionID string) (string, error) {
+	familyID, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	token, _, err := s.createRefreshToken(s.db.GetDB(), userID, sessionID, familyID)
+	return token, err
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same family.
+// The returned record has its User preloaded. Presenting a token that was already
+// rotated or revoked revokes the whole family and the session it belongs to.
+func (s *tokenService) RotateRefreshToken(token string) (*models.RefreshToken, string, error) {
+	var (
+		rotated  *models.RefreshToken
+		newToken string
+		reused   bool
+	)
+
+	err := s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var current models.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", utils.HashToken(token)).
+			First(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidRefreshToken
+		} else if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if current.UsedAt != nil || current.RevokedAt != nil {
+			reused = true
+			if err := tx.Model(&models.RefreshToken{}).
+				Where("family_id = ? AND revoked_at IS NULL", current.FamilyID).
+				Update("revoked_at", now).Error; err != nil {
+				return err
+			}
+			return tx.Model(&models.Session{}).
+				Where("id = ? AND revoked_at IS NULL", current.SessionID).
+				Update("revoked_at", now).Error
+		}
+		if now.After(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		var user models.User
+		if err := tx.Preload("Roles").Where("id = ? AND status = ?", current.UserID, "active").First(&user).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if err := tx.Model(&current).Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		newToken, rotated, err = s.createRefreshToken(tx, current.UserID, current.SessionID, current.FamilyID)
+		if err != nil {
+			return err
+		}
+		rotated.User = user
+		return nil
+	})
+
+	if reused {
+		return nil, "", ErrRefreshTokenReused
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return rotated, newToken, nil
+}
+
+// RevokeRefreshToken revokes the family the given token belongs to
+func (s *tokenService) RevokeRefreshToken(token string) error {
+	var current models.RefreshToken
+	err := s.db.GetDB().Where("token_hash = ?", utils.HashToken(token)).First(&current).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidRefreshToken
+	} else if err != nil {
+		return err
+	}
+
+	return s.db.GetDB().Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", current.FamilyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (s *tokenService) createRefreshToken(tx *gorm.DB, userID uint, sessionID, familyID string) (string, *models.RefreshToken, error) {
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	refreshToken := models.RefreshToken{
+		UserID:    userID,
+		SessionID: sessionID,
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(RefreshTokenTTL()),
+	}
+	if err := tx.Create(&refreshToken).Error; err != nil {
+		return "", nil, err
+	}
+
+	return token, &refreshToken, nil
+}
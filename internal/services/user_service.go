@@ -5,67 +5,111 @@ import (
 	"Admin-gin/internal/models"
 	"Admin-gin/internal/utils"
 	"errors"
+	"log"
+	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrEmailNotVerified   = errors.New("please verify your email to login")
+)
+
 type UserService interface {
 	AddUser(user *models.User) (*models.User, error)
-	UpdateUser(user *models.User) error
+	UpdateUser(id uint, update UserUpdate) error
 	DeleteUser(id uint) error
 	GetUserByID(id uint) (*UserResponse, error)
 	GetUserByEmail(email string) (*models.User, error)
+	GetActiveUser(id uint) (*models.User, error)
 	GetAllUsers() ([]UserResponse, error)
 	UserLogin(email, password string) (*models.User, error)
+	// VerifyPassword checks the password of a signed in user, e.g. to re-authenticate
+	VerifyPassword(id uint, password string) error
 	ChangePassword(id uint, oldPwd, newPwd string) error
-	ResetPassword(email, password string) error
+	VerifyEmail(token string) error
+	ForgotPassword(email string) error
+	ResetPassword(token, password string) error
 }
 
 type UserResponse struct {
-	ID        uint          `json:"id"`
-	Name      string        `json:"name"`
-	Email     string        `json:"email"`
-	Status    string        `json:"status"`
-	Roles     []models.Role `json:"roles"`
-	CreatedAt time.Time     `json:"created_at"`
+	ID         uint          `json:"id"`
+	Name       string        `json:"name"`
+	Email      string        `json:"email"`
+	Status     string        `json:"status"`
+	Department string        `json:"department"`
+	Roles      []models.Role `json:"roles"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// UserUpdate holds the profile fields changed by UpdateUser. A nil
+// Department is left unchanged.
+type UserUpdate struct {
+	Name       string
+	Department *string
 }
 
 type userService struct {
-	db database.Service
+	db             database.Service
+	authenticators []Authenticator
 }
 
 func NewUserService() UserService {
+	// Backend configuration is validated when the server starts
+	authenticators, _ := Authenticators()
 	return &userService{
-		db: database.New(),
+		db:             database.New(),
+		authenticators: authenticators,
 	}
 }
 
 func (s *userService) AddUser(user *models.User) (*models.User, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-	if err != nil {
-		return nil, err
-	}
-	user.Password = string(hashedPassword)
-	token, err := utils.Encrypt(user.Email)
-	if err != nil {
+	if err := validatePassword(user.Password, user); err != nil {
 		return nil, err
 	}
 
-	if err = utils.SendVerificationEmail(user.Email, token); err != nil {
+	hashedPassword, err := hashPassword(user.Password)
+	if err != nil {
 		return nil, err
 	}
+	user.Password = hashedPassword
 
-	if err = s.db.GetDB().Create(user).Error; err != nil {
+	// The user is only kept when the verification email could be sent
+	err = s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		if err := recordPasswordHistory(tx, user.ID, user.Password); err != nil {
+			return err
+		}
+		token, err := issueActionToken(tx, user.ID, models.PurposeEmailVerification)
+		if err != nil {
+			return err
+		}
+		return utils.SendVerificationEmail(user.Email, token)
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return user, nil
 }
 
-func (s *userService) UpdateUser(user *models.User) error {
-	return s.db.GetDB().Save(user).Error
+func (s *userService) UpdateUser(id uint, update UserUpdate) error {
+	fields := map[string]interface{}{"name": update.Name}
+	if update.Department != nil {
+		fields["department"] = strings.TrimSpace(*update.Department)
+	}
+	result := s.db.GetDB().Model(&models.User{}).Where("id = ?", id).Updates(fields)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
 }
 
 func (s *userService) DeleteUser(id uint) error {
@@ -80,10 +124,12 @@ func (s *userService) GetUserByID(id uint) (*UserResponse, error) {
 	var user UserResponse
 	result := s.db.GetDB().
 		Model(&models.User{}).
-		Select("id", "name", "email", "status", "created_at").
+		Select("id", "name", "email", "status", "department", "created_at").
 		First(&user, id)
 
-	if result.Error != nil {
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	} else if result.Error != nil {
 		return nil, result.Error
 	}
 	return &user, nil
@@ -103,12 +149,13 @@ func (s *userService) GetAllUsers() ([]UserResponse, error) {
 	userResponses := make([]UserResponse, len(users))
 	for i, user := range users {
 		userResponses[i] = UserResponse{
-			ID:        user.ID,
-			Name:      user.Name,
-			Email:     user.Email,
-			Status:    user.Status,
-			Roles:     user.Roles,
-			CreatedAt: user.CreatedAt,
+			ID:         user.ID,
+			Name:       user.Name,
+			Email:      user.Email,
+			Status:     user.Status,
+			Department: user.Department,
+			Roles:      user.Roles,
+			CreatedAt:  user.CreatedAt,
 		}
 	}
 
@@ -126,22 +173,36 @@ func (s *userService) GetUserByEmail(email string) (*models.User, error) {
 	return &user, nil
 }
 
-func (s *userService) UserLogin(email, password string) (*models.User, error) {
+// GetActiveUser loads a user allowed to sign in, used to finish multi-step logins
+func (s *userService) GetActiveUser(id uint) (*models.User, error) {
 	var user models.User
-	result := s.db.GetDB().Where("email = ? AND deleted_at IS NULL", email).First(&user)
+	result := s.db.GetDB().Preload("Roles").Where("id = ? AND status = ?", id, "active").First(&user)
 	if result.Error != nil {
-		return nil, errors.New("invalid email or password")
+		return nil, result.Error
 	}
+	return &user, nil
+}
 
-	if user.Status != "active" {
-		return nil, errors.New("please verify your email to login")
-	}
+// UserLogin checks the credentials against the backends in AUTH_BACKENDS
+func (s *userService) UserLogin(email, password string) (*models.User, error) {
+	return authenticate(s.authenticators, email, password)
+}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return nil, errors.New("invalid email or password")
+func (s *userService) VerifyPassword(id uint, password string) error {
+	user, err := s.GetActiveUser(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidCredentials
+	} else if err != nil {
+		return err
 	}
-
-	return &user, nil
+	authenticated, err := authenticate(s.authenticators, user.Email, password)
+	if err != nil {
+		return err
+	}
+	if authenticated.ID != id {
+		return ErrInvalidCredentials
+	}
+	return nil
 }
 
 func (s *userService) ChangePassword(id uint, oldPwd, newPwd string) error {
@@ -150,28 +211,119 @@ func (s *userService) ChangePassword(id uint, oldPwd, newPwd string) error {
 		return err
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(oldPwd)); err != nil {
+	hasher, err := utils.Passwords()
+	if err != nil {
+		return err
+	}
+	if ok, err := hasher.Verify(oldPwd, user.Password); err != nil || !ok {
 		return errors.New("old password is incorrect")
 	}
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(newPwd), bcrypt.DefaultCost)
+	if err := validatePassword(newPwd, &user); err != nil {
+		return err
+	}
+
+	hashed, err := hashPassword(newPwd)
 	if err != nil {
 		return err
 	}
 
-	return s.db.GetDB().Model(&user).Update("password", hashed).Error
+	return s.updatePassword(user.ID, hashed)
 }
 
-func (s *userService) ResetPassword(email, password string) error {
+// VerifyEmail activates the account an email verification token was issued for
+func (s *userService) VerifyEmail(token string) error {
+	userID, err := NewActionTokenService().Consume(token, models.PurposeEmailVerification)
+	if err != nil {
+		return err
+	}
+
+	return s.db.GetDB().Model(&models.User{}).
+		Where("id = ? AND status = ?", userID, "in_active").
+		Update("status", "active").Error
+}
+
+// ForgotPassword emails a password reset link. Unknown addresses are silently
+// ignored and the email is sent in the background so callers cannot tell
+// whether an account exists.
+func (s *userService) ForgotPassword(email string) error {
 	var user models.User
-	if err := s.db.GetDB().Where("email = ?", email).First(&user).Error; err != nil {
+	err := s.db.GetDB().Where("email = ?", email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	token, err := NewActionTokenService().Issue(user.ID, models.PurposePasswordReset)
+	if err != nil {
 		return err
 	}
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	go func() {
+		if err := utils.SendPasswordResetEmail(user.Email, token); err != nil {
+			log.Printf("failed to send password reset email to user %d: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+// ResetPassword sets a new password using a password reset token. The token is
+// only spent once the new password passes the policy.
+func (s *userService) ResetPassword(token, password string) error {
+	actionTokens := NewActionTokenService()
+	userID, err := actionTokens.Peek(token, models.PurposePasswordReset)
 	if err != nil {
 		return err
 	}
 
-	return s.db.GetDB().Model(&user).Update("password", hashed).Error
+	var user models.User
+	if err := s.db.GetDB().First(&user, userID).Error; err != nil {
+		return err
+	}
+	if err := validatePassword(password, &user); err != nil {
+		return err
+	}
+
+	if _, err := actionTokens.Consume(token, models.PurposePasswordReset); err != nil {
+		return err
+	}
+
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.updatePassword(userID, hashed)
+}
+
+// updatePassword stores a new hash, records it in the password history and
+// invalidates reset links issued for the old password
+func (s *userService) updatePassword(userID uint, hashed string) error {
+	return s.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Update("password", hashed).Error; err != nil {
+			return err
+		}
+		if err := recordPasswordHistory(tx, userID, hashed); err != nil {
+			return err
+		}
+		return invalidateActionTokens(tx, userID, models.PurposePasswordReset)
+	})
+}
+
+func hashPassword(password string) (string, error) {
+	hasher, err := utils.Passwords()
+	if err != nil {
+		return "", err
+	}
+	return hasher.Hash(password)
+}
+
+// validatePassword applies the default password policy
+func validatePassword(password string, user *models.User) error {
+	policy, err := DefaultPasswordPolicy()
+	if err != nil {
+		return err
+	}
+	return policy.Validate(password, user)
 }
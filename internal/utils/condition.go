@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+const (
+	// MaxConditionLength bounds the size of a grant condition
+	MaxConditionLength = 1000
+	// conditionCostLimit stops conditions iterating over large lists
+	conditionCostLimit = 10000
+)
+
+// conditionEnv declares what grant conditions can use: principal, resource
+// and request maps and inCIDR(ip, cidr) for IP ranges
+var conditionEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("principal", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("resource", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Function("inCIDR",
+			cel.Overload("inCIDR_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(inCIDR))),
+	)
+})
+
+// Condition is a compiled grant condition
+type Condition struct {
+	program cel.Program
+}
+
+// conditions caches compiled conditions by expression. Conditions are compiled
+// when a grant is saved and on first use after a restart.
+var conditions sync.Map
+
+// ParseCondition checks the syntax and types of a grant condition, which must
+// evaluate to a bool, and compiles it
+func ParseCondition(expression string) (*Condition, error) {
+	if len(expression) > MaxConditionLength {
+		return nil, fmt.Errorf("condition is longer than %d characters", MaxConditionLength)
+	}
+
+	env, err := conditionEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expression)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if !ast.OutputType().IsExactType(cel.BoolType) && !ast.OutputType().IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("condition must evaluate to a bool, not %s", ast.OutputType())
+	}
+	program, err := env.Program(ast, cel.CostLimit(conditionCostLimit))
+	if err != nil {
+		return nil, err
+	}
+	return &Condition{program: program}, nil
+}
+
+// CompileCondition is ParseCondition for the conditions of grants, caching
+// the result
+func CompileCondition(expression string) (*Condition, error) {
+	if condition, ok := conditions.Load(expression); ok {
+		return condition.(*Condition), nil
+	}
+	condition, err := ParseCondition(expression)
+	if err != nil {
+		return nil, err
+	}
+	conditions.Store(expression, condition)
+	return condition, nil
+}
+
+// Eval evaluates the condition in ctx
+func (c *Condition) Eval(ctx *AccessContext) (bool, error) {
+	out, _, err := c.program.Eval(ctx.activation())
+	if err != nil {
+		return false, err
+	}
+	result, ok := out.Value().(bool)
+	if !ok {
+		return false, errors.New("condition did not evaluate to a bool")
+	}
+	return result, nil
+}
+
+// EvalCondition evaluates the condition of a grant in ctx
+func EvalCondition(expression string, ctx *AccessContext) (bool, error) {
+	condition, err := CompileCondition(expression)
+	if err != nil {
+		return false, err
+	}
+	return condition.Eval(ctx)
+}
+
+// activation exposes ctx to conditions. Attributes of an unknown resource are
+// missing, so conditions reading them fail instead of comparing empty values.
+func (ctx *AccessContext) activation() map[string]any {
+	principal := map[string]any{"type": ctx.CallerType, "roles": ctx.Roles}
+	if ctx.Roles == nil {
+		principal["roles"] = []string{}
+	}
+	if ctx.Caller != nil {
+		principal["id"] = int64(ctx.Caller.ID)
+		principal["department"] = ctx.Caller.Department
+		principal["status"] = ctx.Caller.Status
+	}
+	resource := map[string]any{}
+	if ctx.Resource != nil {
+		resource["id"] = int64(ctx.Resource.ID)
+		resource["owner_id"] = int64(ctx.Resource.OwnerID)
+		resource["department"] = ctx.Resource.Department
+		resource["status"] = ctx.Resource.Status
+	}
+	return map[string]any{
+		"principal": principal,
+		"resource":  resource,
+		"request": map[string]any{
+			"ip":     ctx.Request.IP,
+			"method": ctx.Request.Method,
+			"path":   ctx.Request.Path,
+			"time":   ctx.Request.Time,
+		},
+	}
+}
+
+func inCIDR(ip, cidr ref.Val) ref.Val {
+	ipString, ok := ip.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(ip)
+	}
+	cidrString, ok := cidr.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(cidr)
+	}
+	addr, err := netip.ParseAddr(string(ipString))
+	if err != nil {
+		return types.NewErr("invalid IP address %q", string(ipString))
+	}
+	prefix, err := netip.ParsePrefix(string(cidrString))
+	if err != nil {
+		return types.NewErr("invalid CIDR %q", string(cidrString))
+	}
+	return types.Bool(prefix.Contains(addr.Unmap()))
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"Admin-gin/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileCondition(t *testing.T) {
+	valid := []string{
+		`resource.status == "in_active"`,
+		`request.time.getHours("UTC") >= 9 && request.time.getHours("UTC") < 17`,
+		`inCIDR(request.ip, "10.0.0.0/8")`,
+		`"support" in principal.roles`,
+		`principal.department`,
+	}
+	for _, expression := range valid {
+		if _, err := CompileCondition(expression); err != nil {
+			t.Errorf("expected %q to compile: %v", expression, err)
+		}
+	}
+	invalid := []string{
+		`resource.status ==`,
+		`user.status == "active"`,
+		`1 + 2`,
+		`inCIDR(request.ip)`,
+		strings.Repeat("true && ", MaxConditionLength/8) + "true",
+	}
+	for _, expression := range invalid {
+		if _, err := CompileCondition(expression); err == nil {
+			t.Errorf("expected %q to be rejected", expression)
+		}
+	}
+}
+
+func TestEvalCondition(t *testing.T) {
+	ctx := &AccessContext{
+		Caller:     &Resource{ID: 7, OwnerID: 7, Department: "support"},
+		CallerType: models.ActorUser,
+		Roles:      []string{"support"},
+		Resource:   &Resource{ID: 8, OwnerID: 8, Status: "in_active"},
+		Request: RequestInfo{
+			IP:   "10.1.2.3",
+			Time: time.Date(2026, 3, 2, 10, 30, 0, 0, time.UTC),
+		},
+	}
+
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		{`resource.status == "in_active"`, true},
+		{`resource.owner_id == principal.id`, false},
+		{`principal.id == 7 && principal.type == "user"`, true},
+		{`"support" in principal.roles`, true},
+		{`request.time.getHours("UTC") >= 9 && request.time.getHours("UTC") < 17`, true},
+		{`request.time.getDayOfWeek("UTC") in [0, 6]`, false},
+		{`inCIDR(request.ip, "10.0.0.0/8")`, true},
+		{`inCIDR(request.ip, "192.168.0.0/16")`, false},
+	}
+	for _, tt := range tests {
+		got, err := EvalCondition(tt.expression, ctx)
+		if err != nil {
+			t.Errorf("%q: %v", tt.expression, err)
+		} else if got != tt.want {
+			t.Errorf("%q: expected %v, got %v", tt.expression, tt.want, got)
+		}
+	}
+
+	if _, err := EvalCondition(`resource.status == "in_active"`, &AccessContext{}); err == nil {
+		t.Error("expected reading an unknown resource to fail")
+	}
+	if _, err := EvalCondition(`principal.department`, ctx); err == nil {
+		t.Error("expected a string result to fail")
+	}
+}
+
+func TestEvaluateConditionalGrants(t *testing.T) {
+	allow := Grant{Role: "support", Permission: "user.update", Condition: `resource.status == "in_active"`}
+	deny := Grant{Role: "support", Permission: "user.update", Effect: models.GrantEffectDeny, Condition: `!inCIDR(request.ip, "10.0.0.0/8")`}
+	grants := []Grant{allow, deny}
+	ctx := func(status, ip string) *AccessContext {
+		return &AccessContext{Caller: &Resource{ID: 7, OwnerID: 7}, Resource: &Resource{ID: 8, OwnerID: 8, Status: status}, Request: RequestInfo{IP: ip}}
+	}
+
+	if !Evaluate(grants, "user.update", ctx("in_active", "10.0.0.1")).Allowed {
+		t.Fatal("expected the condition to allow an inactive user from the office")
+	}
+	if Evaluate(grants, "user.update", ctx("active", "10.0.0.1")).Allowed {
+		t.Fatal("expected the condition to refuse an active user")
+	}
+	if decision := Evaluate(grants, "user.update", ctx("in_active", "203.0.113.5")); decision.Allowed || decision.DeniedBy == nil {
+		t.Fatalf("expected the deny to block requests from outside the office, got %+v", decision)
+	}
+	// Errors count against the caller: no IP makes the deny apply
+	if Evaluate(grants, "user.update", ctx("in_active", "")).Allowed {
+		t.Fatal("expected a failing deny condition to apply")
+	}
+	if Evaluate(grants, "user.update", nil).Allowed {
+		t.Fatal("expected conditional grants to be ignored without a context")
+	}
+}
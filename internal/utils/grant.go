@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"Admin-gin/internal/models"
+	"time"
+)
+
+// Grant is a permission allowed or denied through a role, limited to the
+// records of its scope unless the scope is global, and to the requests its
+// condition holds for when it has one
+type Grant struct {
+	RoleID       uint   `json:"role_id"`
+	Role         string `json:"role"`
+	PermissionID uint   `json:"permission_id"`
+	Permission   string `json:"permission"`
+	Effect       string `json:"effect"`
+	Scope        string `json:"scope"`
+	ResourceID   *uint  `json:"resource_id,omitempty"`
+	Condition    string `json:"condition,omitempty"`
+}
+
+// IsGlobal reports whether the grant applies to every record
+func (g Grant) IsGlobal() bool {
+	return g.Scope == "" || g.Scope == models.GrantScopeGlobal
+}
+
+// IsDeny reports whether the grant forbids the permission
+func (g Grant) IsDeny() bool {
+	return g.Effect == models.GrantEffectDeny
+}
+
+// Matches reports whether the grant's permission, possibly a pattern, covers
+// permission
+func (g Grant) Matches(permission string) bool {
+	return NewPermissionMatcher([]string{g.Permission}).Allows(permission)
+}
+
+// Resource is a record permissions are checked on: its ID, the user it belongs
+// to and that user's department and status. The caller is described the same
+// way.
+type Resource struct {
+	ID         uint
+	OwnerID    uint
+	Department string
+	Status     string
+}
+
+// RequestInfo holds the attributes of the HTTP request conditions can use
+type RequestInfo struct {
+	IP     string
+	Method string
+	Path   string
+	Time   time.Time
+}
+
+// AccessContext is what scoped grants and conditions are evaluated against
+type AccessContext struct {
+	// Caller describes the calling user, or a service account owning nothing
+	Caller     *Resource
+	CallerType string
+	Roles      []string
+	// Resource is the record acted on, nil when unknown
+	Resource *Resource
+	Request  RequestInfo
+}
+
+// Applies reports whether the grant applies in ctx: a scoped grant must cover
+// the record acted on and a condition must hold. A condition that fails to
+// evaluate, e.g. on a missing attribute, counts as holding for a deny and not
+// for an allow.
+func (g Grant) Applies(ctx *AccessContext) bool {
+	if !g.IsGlobal() && (ctx.Resource == nil || ctx.Caller == nil || !g.Covers(ctx.Caller, ctx.Resource)) {
+		return false
+	}
+	if g.Condition == "" {
+		return true
+	}
+	ok, err := EvalCondition(g.Condition, ctx)
+	if err != nil {
+		return g.IsDeny()
+	}
+	return ok
+}
+
+// Covers reports whether a scoped grant of caller applies to resource
+func (g Grant) Covers(caller, resource *Resource) bool {
+	switch g.Scope {
+	case models.GrantScopeResource:
+		return g.ResourceID != nil && *g.ResourceID == resource.ID
+	case models.GrantScopeOwn:
+		return caller.OwnerID != 0 && caller.OwnerID == resource.OwnerID
+	case models.GrantScopeGroup:
+		return caller.Department != "" && caller.Department == resource.Department
+	}
+	return g.IsGlobal()
+}
+
+// Decision is the outcome of evaluating grants for a permission
+type Decision struct {
+	Permission string `json:"permission"`
+	Allowed    bool   `json:"allowed"`
+	// Grant is the allow that applied, DeniedBy the deny overriding all allows
+	Grant    *Grant `json:"grant,omitempty"`
+	DeniedBy *Grant `json:"denied_by,omitempty"`
+}
+
+// grantScopeOrder ranks grants of the same specificity: a global grant is
+// reported before one naming the resource, the caller's own record or their
+// department
+var grantScopeOrder = []string{models.GrantScopeGlobal, models.GrantScopeResource, models.GrantScopeOwn, models.GrantScopeGroup}
+
+// Evaluate decides whether grants allow permission. A deny that applies always
+// wins over allows. Scoped and conditional grants are checked against ctx;
+// when it is nil, only unconditional global grants are considered.
+func Evaluate(grants []Grant, permission string, ctx *AccessContext) Decision {
+	decision := Decision{Permission: permission}
+	if deny := bestGrant(grants, permission, true, ctx); deny != nil {
+		decision.DeniedBy = deny
+		return decision
+	}
+	if allow := bestGrant(grants, permission, false, ctx); allow != nil {
+		decision.Allowed = true
+		decision.Grant = allow
+	}
+	return decision
+}
+
+// bestGrant returns the most specific applicable grant with the effect
+func bestGrant(grants []Grant, permission string, deny bool, ctx *AccessContext) *Grant {
+	for _, scope := range grantScopeOrder {
+		var names []string
+		var candidates []int
+		for i, grant := range grants {
+			if grant.IsDeny() != deny || (grant.Scope != scope && !(scope == models.GrantScopeGlobal && grant.IsGlobal())) {
+				continue
+			}
+			if (!grant.IsGlobal() || grant.Condition != "") && (ctx == nil || !grant.Applies(ctx)) {
+				continue
+			}
+			names = append(names, grant.Permission)
+			candidates = append(candidates, i)
+		}
+		if name, ok := NewPermissionMatcher(names).Match(permission); ok {
+			for _, i := range candidates {
+				if grants[i].Permission == name {
+					return &grants[i]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// HasScopedGrant reports whether the outcome for permission may depend on the
+// record acted on, through a scoped grant or a condition
+func HasScopedGrant(grants []Grant, permission string) bool {
+	for _, grant := range grants {
+		if (!grant.IsGlobal() || grant.Condition != "") && grant.Matches(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// CoversGrants reports whether grants allow, on every record, everything the
+// other grants allow. A global deny overlapping a permission counts against
+// it, so a user.* allow with a user.delete deny does not cover user.*, even
+// when the deny has a condition. Conditional allows do not count.
+func CoversGrants(grants, other []Grant) bool {
+	for _, grant := range other {
+		if !grant.IsDeny() && !allowsEverywhere(grants, grant.Permission) {
+			return false
+		}
+	}
+	return true
+}
+
+func allowsEverywhere(grants []Grant, permission string) bool {
+	allowed := false
+	for _, grant := range grants {
+		if !grant.IsGlobal() {
+			continue
+		}
+		if grant.IsDeny() {
+			if grant.Matches(permission) || NewPermissionMatcher([]string{permission}).Allows(grant.Permission) {
+				return false
+			}
+		} else if grant.Condition == "" && grant.Matches(permission) {
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// AllowsWildcard reports whether the grants allow *, in any scope or under
+// any condition, making their holder all but a super administrator
+func AllowsWildcard(grants []Grant) bool {
+	for _, grant := range grants {
+		if !grant.IsDeny() && grant.Permission == PermissionWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// GrantNames returns the distinct permission names the grants allow, whatever
+// their scope
+func GrantNames(grants []Grant) []string {
+	seen := make(map[string]bool, len(grants))
+	names := make([]string, 0, len(grants))
+	for _, grant := range grants {
+		if !grant.IsDeny() && !seen[grant.Permission] {
+			seen[grant.Permission] = true
+			names = append(names, grant.Permission)
+		}
+	}
+	return names
+}
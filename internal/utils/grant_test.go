@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"Admin-gin/internal/models"
+	"testing"
+)
+
+func TestEvaluatePrefersGlobalGrants(t *testing.T) {
+	resourceID := uint(9)
+	grants := []Grant{
+		{Permission: "user.update", Scope: models.GrantScopeOwn},
+		{Permission: "user.*", Scope: models.GrantScopeGroup},
+		{Permission: "user.update", Scope: models.GrantScopeResource, ResourceID: &resourceID},
+		{Permission: "role.read", Scope: models.GrantScopeGlobal},
+	}
+
+	if decision := Evaluate(grants, "user.update", nil); decision.Allowed {
+		t.Fatalf("expected scoped grants to be ignored without a record, got %+v", decision.Grant)
+	}
+	if !HasScopedGrant(grants, "user.update") || HasScopedGrant(grants, "role.read") {
+		t.Fatal("expected only user.update to have scoped grants")
+	}
+
+	ctx := &AccessContext{Caller: &Resource{ID: 9, OwnerID: 9}, Resource: &Resource{ID: 9, OwnerID: 9}}
+	decision := Evaluate(append(grants, Grant{Permission: "*", Scope: models.GrantScopeGlobal}), "user.update", ctx)
+	if !decision.Allowed || decision.Grant.Permission != "*" {
+		t.Fatalf("expected the global * grant to apply, got %+v", decision.Grant)
+	}
+}
+
+func TestEvaluateScopedGrants(t *testing.T) {
+	resourceID := uint(9)
+	own := Grant{Permission: "user.update", Scope: models.GrantScopeOwn}
+	group := Grant{Permission: "user.update", Scope: models.GrantScopeGroup}
+	explicit := Grant{Permission: "user.update", Scope: models.GrantScopeResource, ResourceID: &resourceID}
+	caller := &Resource{ID: 7, OwnerID: 7, Department: "sales"}
+
+	tests := []struct {
+		name     string
+		grants   []Grant
+		caller   *Resource
+		resource *Resource
+		want     *Grant
+	}{
+		{"own record", []Grant{own}, caller, &Resource{ID: 7, OwnerID: 7}, &own},
+		{"someone else", []Grant{own}, caller, &Resource{ID: 8, OwnerID: 8, Department: "sales"}, nil},
+		{"same department", []Grant{own, group}, caller, &Resource{ID: 8, OwnerID: 8, Department: "sales"}, &group},
+		{"other department", []Grant{group}, caller, &Resource{ID: 8, OwnerID: 8, Department: "support"}, nil},
+		{"no department", []Grant{group}, &Resource{ID: 7, OwnerID: 7}, &Resource{ID: 8, OwnerID: 8}, nil},
+		{"named resource first", []Grant{group, own, explicit}, caller, &Resource{ID: 9, OwnerID: 9, Department: "sales"}, &explicit},
+		{"other resource", []Grant{explicit}, caller, &Resource{ID: 10, OwnerID: 10}, nil},
+		{"service account", []Grant{own, group}, &Resource{}, &Resource{ID: 8, OwnerID: 8}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Evaluate(tt.grants, "user.update", &AccessContext{Caller: tt.caller, Resource: tt.resource}).Grant
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEvaluateDenyWins(t *testing.T) {
+	editor := Grant{Role: "editor", Permission: "user.*"}
+	deny := Grant{Role: "auditor", Permission: "user.delete", Effect: models.GrantEffectDeny}
+	admin := Grant{Role: "admin", Permission: "*"}
+	grants := []Grant{editor, deny, admin}
+
+	decision := Evaluate(grants, "user.delete", nil)
+	if decision.Allowed || decision.DeniedBy == nil || *decision.DeniedBy != deny {
+		t.Fatalf("expected the user.delete deny to win, got %+v", decision)
+	}
+	decision = Evaluate(grants, "user.update", nil)
+	if !decision.Allowed || *decision.Grant != editor {
+		t.Fatalf("expected user.* to allow user.update, got %+v", decision)
+	}
+
+	// A scoped deny only blocks the records it covers
+	own := Grant{Permission: "user.update", Effect: models.GrantEffectDeny, Scope: models.GrantScopeOwn}
+	caller := &Resource{ID: 7, OwnerID: 7}
+	grants = []Grant{editor, own}
+	if Evaluate(grants, "user.update", &AccessContext{Caller: caller, Resource: &Resource{ID: 7, OwnerID: 7}}).Allowed {
+		t.Fatal("expected the own deny to block the caller's record")
+	}
+	if !Evaluate(grants, "user.update", &AccessContext{Caller: caller, Resource: &Resource{ID: 8, OwnerID: 8}}).Allowed {
+		t.Fatal("expected the own deny to leave other records alone")
+	}
+}
+
+func TestCoversGrants(t *testing.T) {
+	all := []Grant{{Permission: "*"}}
+	users := []Grant{{Permission: "user.*"}, {Permission: "user.delete", Effect: models.GrantEffectDeny}}
+
+	tests := []struct {
+		name   string
+		grants []Grant
+		other  []Grant
+		want   bool
+	}{
+		{"wildcard covers pattern", all, []Grant{{Permission: "user.*"}}, true},
+		{"pattern covers name", users, []Grant{{Permission: "user.read"}}, true},
+		{"deny blocks name", users, []Grant{{Permission: "user.delete"}}, false},
+		{"deny blocks pattern", users, []Grant{{Permission: "user.*"}}, false},
+		{"denies of other ignored", all, users, true},
+		{"scoped grant", []Grant{{Permission: "user.read", Scope: models.GrantScopeOwn}}, []Grant{{Permission: "user.read"}}, false},
+		{"conditional grant", []Grant{{Permission: "user.read", Condition: "true"}}, []Grant{{Permission: "user.read"}}, false},
+		{"conditional deny", []Grant{{Permission: "*"}, {Permission: "user.delete", Effect: models.GrantEffectDeny, Condition: "false"}}, []Grant{{Permission: "user.*"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CoversGrants(tt.grants, tt.other); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAllowsWildcard(t *testing.T) {
+	if AllowsWildcard([]Grant{{Permission: "user.*"}, {Permission: "*", Effect: models.GrantEffectDeny}}) {
+		t.Fatal("expected a deny of * not to count")
+	}
+	if !AllowsWildcard([]Grant{{Permission: "user.read"}, {Permission: "*", Scope: models.GrantScopeOwn}}) {
+		t.Fatal("expected a scoped * to count")
+	}
+}
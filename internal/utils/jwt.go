@@ -2,25 +2,269 @@ package utils
 
 import (
 	"Admin-gin/internal/models"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-var SecretKey = []byte("secret-key")
+// MFAChallengeTTL bounds the time between the password step and the second factor
+const MFAChallengeTTL = 5 * time.Minute
 
-func CreateToken(user *models.User) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256,
-		jwt.MapClaims{
-			"user": user,
-			"exp":  time.Now().Add(time.Hour * 24).Unix(),
-		},
-	)
+// Values of the typ claim, so one kind of token cannot be used as another
+const (
+	AccessTokenType = "access"
+	mfaPendingType  = "mfa_pending"
+)
+
+// Authentication method references of the amr claim (RFC 8176)
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRHardwareKey = "hwk"
+	AMRMultiFactor = "mfa"
+	// AMRFederated marks a login at an external identity provider
+	AMRFederated = "fed"
+	// AMREmail marks a login with a link sent by email
+	AMREmail = "email"
+)
+
+var (
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired MFA challenge")
+)
+
+// Claims is the payload of every token signed by this service. Only identifiers
+// and an authorization snapshot are embedded, never the user record itself.
+type Claims struct {
+	jwt.RegisteredClaims
+	Type         string       `json:"typ"`
+	SessionID    string       `json:"sid,omitempty"`
+	Roles        []string     `json:"roles,omitempty"`
+	AuthzVersion int64        `json:"authz_ver,omitempty"`
+	Actor        *ActorClaims `json:"act,omitempty"`
+	// AuthTime and AMR tell when and how the user last proved their identity,
+	// at login or with POST /api/reauth. They carry over refreshed tokens.
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR      []string         `json:"amr,omitempty"`
+}
+
+// Authentication is the time and methods of the user's last sign in or
+// re-authentication
+type Authentication struct {
+	Time    time.Time
+	Methods []string
+}
+
+// AuthenticatedWithin reports whether the user signed in or re-authenticated
+// less than maxAge ago
+func (c *Claims) AuthenticatedWithin(maxAge time.Duration) bool {
+	return c.AuthTime != nil && time.Since(c.AuthTime.Time) < maxAge
+}
+
+// ActorClaims is the act claim of RFC 8693. It identifies the user acting on
+// behalf of the subject while impersonating them.
+type ActorClaims struct {
+	Subject string `json:"sub"`
+}
+
+// ActorID returns the impersonating user, if the token was issued for impersonation
+func (c *Claims) ActorID() (uint, bool) {
+	if c.Actor == nil {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(c.Actor.Subject, 10, 32)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// UserID returns the numeric user ID carried in sub
+func (c *Claims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 32)
+	if err != nil || id == 0 {
+		return 0, ErrInvalidToken
+	}
+	return uint(id), nil
+}
+
+// AccessTokenTTL is the lifetime of access tokens, configured with ACCESS_TOKEN_TTL
+func AccessTokenTTL() time.Duration {
+	return GetEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+// ReauthWindow is how long after signing in or re-authenticating sensitive
+// operations are allowed, configured with REAUTH_WINDOW
+func ReauthWindow() time.Duration {
+	return GetEnvDuration("REAUTH_WINDOW", 10*time.Minute)
+}
+
+// TokenIssuer is the iss claim, configured with JWT_ISSUER
+func TokenIssuer() string {
+	return GetEnv("JWT_ISSUER", "admin-gin")
+}
+
+// TokenAudience is the aud claim, configured with JWT_AUDIENCE as a comma separated list
+func TokenAudience() []string {
+	var audience []string
+	for _, aud := range strings.Split(GetEnv("JWT_AUDIENCE", "admin-gin"), ",") {
+		if aud = strings.TrimSpace(aud); aud != "" {
+			audience = append(audience, aud)
+		}
+	}
+	return audience
+}
 
-	tokenString, err := token.SignedString(SecretKey)
+func newRegisteredClaims(userID uint, ttl time.Duration) jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Subject:   strconv.FormatUint(uint64(userID), 10),
+		Issuer:    TokenIssuer(),
+		Audience:  TokenAudience(),
+		ID:        uuid.NewString(),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+}
+
+// CreateToken signs an access token for the user's session and returns it with
+// its jti. The user's Roles must be preloaded for the roles snapshot.
+func CreateToken(user *models.User, sessionID string, auth Authentication) (string, string, error) {
+	keys, err := Keys()
+	if err != nil {
+		return "", "", err
+	}
+
+	roles := make([]string, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		roles = append(roles, role.Name)
+	}
+
+	claims := Claims{
+		RegisteredClaims: newRegisteredClaims(user.ID, AccessTokenTTL()),
+		Type:             AccessTokenType,
+		SessionID:        sessionID,
+		Roles:            roles,
+		AuthzVersion:     user.AuthzVersion,
+		AMR:              auth.Methods,
+	}
+	if !auth.Time.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(auth.Time)
+	}
+	tokenString, err := keys.Sign(claims)
+	if err != nil {
+		return "", "", err
+	}
+
+	return tokenString, claims.ID, nil
+}
+
+// ImpersonationTTL is the lifetime of impersonation tokens, configured with IMPERSONATION_TTL.
+// They cannot be refreshed.
+func ImpersonationTTL() time.Duration {
+	return GetEnvDuration("IMPERSONATION_TTL", 15*time.Minute)
+}
+
+// CreateImpersonationToken signs an access token for the user with the
+// impersonating actor in the act claim. It has no session.
+func CreateImpersonationToken(user *models.User, actorID uint) (string, *Claims, error) {
+	keys, err := Keys()
+	if err != nil {
+		return "", nil, err
+	}
+
+	roles := make([]string, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		roles = append(roles, role.Name)
+	}
+
+	claims := &Claims{
+		RegisteredClaims: newRegisteredClaims(user.ID, ImpersonationTTL()),
+		Type:             AccessTokenType,
+		Roles:            roles,
+		AuthzVersion:     user.AuthzVersion,
+		Actor:            &ActorClaims{Subject: strconv.FormatUint(uint64(actorID), 10)},
+	}
+	tokenString, err := keys.Sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return tokenString, claims, nil
+}
+
+// ParseToken verifies the signature of a token issued by this service with the
+// key named by its kid header, checks exp, nbf, iss and aud, and decodes it into claims
+func ParseToken(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return parseSignedToken(tokenString, claims, TokenIssuer(), TokenAudience())
+}
+
+func parseSignedToken(tokenString string, claims jwt.Claims, issuer string, audience []string) (*jwt.Token, error) {
+	keys, err := Keys()
+	if err != nil {
+		return nil, err
+	}
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(keys.Algorithms()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(issuer),
+		jwt.WithLeeway(5 * time.Second),
+	}
+	for _, aud := range audience {
+		options = append(options, jwt.WithAudience(aud))
+	}
+	return jwt.ParseWithClaims(tokenString, claims, keys.Keyfunc, options...)
+}
+
+// ParseAccessToken validates an access token and returns its claims
+func ParseAccessToken(tokenString string) (*Claims, error) {
+	var claims Claims
+	token, err := ParseToken(tokenString, &claims)
+	if err != nil || !token.Valid || claims.Type != AccessTokenType || claims.ID == "" {
+		return nil, ErrInvalidToken
+	}
+	if _, err := claims.UserID(); err != nil {
+		return nil, err
+	}
+	if _, ok := claims.ActorID(); claims.Actor != nil && !ok {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// CreateMFAChallengeToken signs the short-lived "mfa_pending" token returned by
+// login in place of an access token until a second factor is verified. methods
+// are the amr values of the first factor.
+func CreateMFAChallengeToken(userID uint, methods []string) (string, error) {
+	keys, err := Keys()
 	if err != nil {
 		return "", err
 	}
 
-	return tokenString, nil
+	return keys.Sign(Claims{
+		RegisteredClaims: newRegisteredClaims(userID, MFAChallengeTTL),
+		Type:             mfaPendingType,
+		AMR:              methods,
+	})
+}
+
+// ParseMFAChallengeToken validates a challenge token and returns the user ID it
+// was issued for and the amr values of the first factor
+func ParseMFAChallengeToken(tokenString string) (uint, []string, error) {
+	var claims Claims
+	token, err := ParseToken(tokenString, &claims)
+	if err != nil || !token.Valid || claims.Type != mfaPendingType {
+		return 0, nil, ErrInvalidMFAChallenge
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		return 0, nil, ErrInvalidMFAChallenge
+	}
+	return userID, claims.AMR, nil
 }
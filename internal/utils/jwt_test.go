@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"Admin-gin/internal/models"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestCreateTokenClaims(t *testing.T) {
+	user := &models.User{ID: 42, Password: "hash", Roles: []models.Role{{Name: "editor"}}, AuthzVersion: 3}
+	authTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	token, jti, err := CreateToken(user, "session-1", Authentication{Time: authTime, Methods: []string{AMRPassword, AMROTP, AMRMultiFactor}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseAccessToken(token)
+	if err != nil {
+		t.Fatalf("ParseAccessToken returned error: %v", err)
+	}
+	if userID, _ := claims.UserID(); userID != 42 {
+		t.Errorf("expected sub 42, got %s", claims.Subject)
+	}
+	if claims.ID != jti || claims.SessionID != "session-1" || claims.AuthzVersion != 3 {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != "editor" {
+		t.Errorf("expected roles snapshot, got %v", claims.Roles)
+	}
+	if claims.AuthTime == nil || !claims.AuthTime.Time.Equal(authTime) || !reflect.DeepEqual(claims.AMR, []string{"pwd", "otp", "mfa"}) {
+		t.Errorf("expected auth_time %v and amr of the login, got %v %v", authTime, claims.AuthTime, claims.AMR)
+	}
+	if claims.AuthenticatedWithin(10*time.Minute) || !claims.AuthenticatedWithin(2*time.Hour) {
+		t.Error("expected the token to be authenticated an hour ago")
+	}
+
+	var raw jwt.MapClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := raw["user"]; ok {
+		t.Error("expected the user record to not be embedded in the token")
+	}
+}
+
+func TestParseAccessTokenRejectsForeignTokens(t *testing.T) {
+	keys, err := Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]jwt.Claims{
+		"legacy user claim": jwt.MapClaims{"user": map[string]any{"id": 1}, "exp": 9999999999},
+		"wrong audience": Claims{
+			RegisteredClaims: func() jwt.RegisteredClaims {
+				rc := newRegisteredClaims(1, AccessTokenTTL())
+				rc.Audience = jwt.ClaimStrings{"another-service"}
+				return rc
+			}(),
+			Type: AccessTokenType,
+		},
+		"mfa challenge": Claims{RegisteredClaims: newRegisteredClaims(1, MFAChallengeTTL), Type: mfaPendingType},
+	}
+
+	for name, claims := range cases {
+		token, err := keys.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseAccessToken(token); err == nil {
+			t.Errorf("%s: expected token to be rejected", name)
+		}
+	}
+}
+
+func TestImpersonationTokenCarriesActor(t *testing.T) {
+	user := &models.User{ID: 42, Roles: []models.Role{{Name: "user"}}}
+	token, issued, err := CreateImpersonationToken(user, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseAccessToken(token)
+	if err != nil {
+		t.Fatalf("ParseAccessToken returned error: %v", err)
+	}
+	if claims.ID != issued.ID || claims.Subject != "42" || claims.SessionID != "" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+	if actorID, ok := claims.ActorID(); !ok || actorID != 7 {
+		t.Fatalf("expected actor 7, got %d %v", actorID, ok)
+	}
+
+	plain, _, err := CreateToken(user, "session-1", Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = ParseAccessToken(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := claims.ActorID(); ok {
+		t.Fatal("expected a regular token to have no actor")
+	}
+}
+
+func TestMFAChallengeCarriesFirstFactor(t *testing.T) {
+	challenge, err := CreateMFAChallengeToken(42, []string{AMRPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, methods, err := ParseMFAChallengeToken(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != 42 || !reflect.DeepEqual(methods, []string{"pwd"}) {
+		t.Fatalf("unexpected challenge for user %d with amr %v", userID, methods)
+	}
+
+	plain, _, err := CreateToken(&models.User{ID: 42}, "session-1", Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseAccessToken(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.AuthTime != nil || claims.AuthenticatedWithin(time.Hour) {
+		t.Fatal("expected a token without auth_time to never count as recently authenticated")
+	}
+}
@@ -0,0 +1,382 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrNoSigningKey = errors.New("no active signing key")
+	ErrUnknownKey   = errors.New("unknown or retired signing key")
+	// ErrSymmetricKey is returned for ID tokens when the signing key is an
+	// HS256 secret, which clients cannot verify
+	ErrSymmetricKey = errors.New("ID tokens need an RS256 or EdDSA signing key")
+)
+
+// KeyConfig describes one signing key as found in JWT_KEYS_FILE
+type KeyConfig struct {
+	ID             string    `json:"kid"`
+	Algorithm      string    `json:"alg"`
+	Secret         string    `json:"secret,omitempty"`
+	SecretEnv      string    `json:"secret_env,omitempty"`
+	PrivateKey     string    `json:"private_key,omitempty"`
+	PrivateKeyFile string    `json:"private_key_file,omitempty"`
+	ActiveFrom     time.Time `json:"active_from,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at,omitempty"`
+}
+
+// SigningKey is a loaded key. A key signs from ActiveFrom until its successor
+// becomes active, and keeps verifying for the grace window after that.
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	ActiveFrom time.Time
+	ExpiresAt  time.Time
+
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeyManager selects the key used to sign new tokens and resolves keys by kid
+// when verifying them
+type KeyManager struct {
+	mu    sync.RWMutex
+	keys  []*SigningKey
+	grace time.Duration
+	now   func() time.Time
+}
+
+var (
+	defaultKeys     *KeyManager
+	defaultKeysErr  error
+	defaultKeysOnce sync.Once
+)
+
+// Keys returns the process wide KeyManager, loaded from the environment on first use
+func Keys() (*KeyManager, error) {
+	defaultKeysOnce.Do(func() {
+		defaultKeys, defaultKeysErr = LoadKeyManagerFromEnv()
+	})
+	return defaultKeys, defaultKeysErr
+}
+
+// SetKeys replaces the process wide KeyManager, used by tests and tools
+func SetKeys(km *KeyManager) {
+	defaultKeysOnce.Do(func() {})
+	defaultKeys, defaultKeysErr = km, nil
+}
+
+// LoadKeyManagerFromEnv reads keys from JWT_KEYS_FILE when set, otherwise builds a
+// single key from JWT_SIGNING_ALG, JWT_KEY_ID and JWT_SECRET / JWT_PRIVATE_KEY(_FILE).
+// JWT_KEY_GRACE controls how long retired keys keep verifying.
+func LoadKeyManagerFromEnv() (*KeyManager, error) {
+	grace := GetEnvDuration("JWT_KEY_GRACE", time.Hour)
+
+	var configs []KeyConfig
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWT_KEYS_FILE: %w", err)
+		}
+		if err := json.Unmarshal(data, &configs); err != nil {
+			return nil, fmt.Errorf("parsing JWT_KEYS_FILE: %w", err)
+		}
+	} else {
+		config := KeyConfig{
+			ID:             GetEnv("JWT_KEY_ID", "default"),
+			Algorithm:      GetEnv("JWT_SIGNING_ALG", "HS256"),
+			Secret:         os.Getenv("JWT_SECRET"),
+			PrivateKey:     strings.ReplaceAll(os.Getenv("JWT_PRIVATE_KEY"), `\n`, "\n"),
+			PrivateKeyFile: os.Getenv("JWT_PRIVATE_KEY_FILE"),
+		}
+		if config.Algorithm == "HS256" && config.Secret == "" {
+			// Only local development runs without a secret, on a random key
+			// that does not survive a restart
+			if GetEnv("APP_ENV", "local") != "local" {
+				return nil, errors.New("JWT_SECRET is not set")
+			}
+			secret, err := GenerateRandomToken(32)
+			if err != nil {
+				return nil, err
+			}
+			log.Println("JWT_SECRET is not set, signing tokens with a random key until restart")
+			config.Secret = secret
+		}
+		configs = append(configs, config)
+	}
+
+	return NewKeyManager(configs, grace)
+}
+
+// NewKeyManager loads every configured key
+func NewKeyManager(configs []KeyConfig, grace time.Duration) (*KeyManager, error) {
+	if len(configs) == 0 {
+		return nil, ErrNoSigningKey
+	}
+
+	km := &KeyManager{grace: grace, now: time.Now}
+	seen := make(map[string]bool)
+	for _, config := range configs {
+		if config.ID == "" {
+			return nil, errors.New("every signing key needs a kid")
+		}
+		if seen[config.ID] {
+			return nil, fmt.Errorf("duplicate kid %q", config.ID)
+		}
+		seen[config.ID] = true
+
+		key, err := loadSigningKey(config)
+		if err != nil {
+			return nil, fmt.Errorf("loading key %q: %w", config.ID, err)
+		}
+		km.keys = append(km.keys, key)
+	}
+
+	sort.SliceStable(km.keys, func(i, j int) bool {
+		return km.keys[i].ActiveFrom.Before(km.keys[j].ActiveFrom)
+	})
+	return km, nil
+}
+
+func loadSigningKey(config KeyConfig) (*SigningKey, error) {
+	key := &SigningKey{
+		ID:         config.ID,
+		Algorithm:  config.Algorithm,
+		ActiveFrom: config.ActiveFrom,
+		ExpiresAt:  config.ExpiresAt,
+	}
+
+	switch config.Algorithm {
+	case "HS256":
+		secret := config.Secret
+		if config.SecretEnv != "" {
+			secret = os.Getenv(config.SecretEnv)
+		}
+		if secret == "" {
+			return nil, errors.New("HS256 keys need a secret")
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey = []byte(secret)
+		key.verifyKey = []byte(secret)
+		return key, nil
+	case "RS256", "EdDSA":
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", config.Algorithm)
+	}
+
+	pemData := []byte(config.PrivateKey)
+	if config.PrivateKeyFile != "" {
+		data, err := os.ReadFile(config.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		pemData = data
+	}
+	private, err := parsePrivateKey(pemData)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		if config.Algorithm != "RS256" {
+			return nil, errors.New("RSA key configured for " + config.Algorithm)
+		}
+		key.method = jwt.SigningMethodRS256
+		key.signKey = k
+		key.verifyKey = &k.PublicKey
+	case ed25519.PrivateKey:
+		if config.Algorithm != "EdDSA" {
+			return nil, errors.New("Ed25519 key configured for " + config.Algorithm)
+		}
+		key.method = jwt.SigningMethodEdDSA
+		key.signKey = k
+		key.verifyKey = k.Public()
+	default:
+		return nil, errors.New("unsupported private key type")
+	}
+	return key, nil
+}
+
+func parsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// retiresAt is the end of the verification window of the key at index i
+func (km *KeyManager) retiresAt(i int) time.Time {
+	key := km.keys[i]
+	retire := key.ExpiresAt
+	if i+1 < len(km.keys) {
+		successor := km.keys[i+1].ActiveFrom.Add(km.grace)
+		if retire.IsZero() || successor.Before(retire) {
+			retire = successor
+		}
+	}
+	return retire
+}
+
+// SigningKey returns the most recently activated key that has not expired
+func (km *KeyManager) SigningKey() (*SigningKey, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	now := km.now()
+	for i := len(km.keys) - 1; i >= 0; i-- {
+		key := km.keys[i]
+		if key.ActiveFrom.After(now) {
+			continue
+		}
+		if !key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt) {
+			continue
+		}
+		return key, nil
+	}
+	return nil, ErrNoSigningKey
+}
+
+// Sign signs the claims with the current key and sets the kid header
+func (km *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	key, err := km.SigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+// Keyfunc resolves the verification key by kid and rejects tokens whose alg
+// does not match the key, or whose key was retired
+func (km *KeyManager) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	now := km.now()
+	for i, key := range km.keys {
+		if key.ID != kid {
+			continue
+		}
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s for key %q", t.Method.Alg(), kid)
+		}
+		if retire := km.retiresAt(i); !retire.IsZero() && !now.Before(retire) {
+			return nil, ErrUnknownKey
+		}
+		return key.verifyKey, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// Algorithms lists the algorithms of the configured keys, for jwt.WithValidMethods
+func (km *KeyManager) Algorithms() []string {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var algs []string
+	for _, key := range km.keys {
+		if !seen[key.method.Alg()] {
+			seen[key.method.Alg()] = true
+			algs = append(algs, key.method.Alg())
+		}
+	}
+	return algs
+}
+
+// PublicAlgorithms lists the algorithms of the asymmetric keys, the ones
+// clients can verify with the JWKS
+func (km *KeyManager) PublicAlgorithms() []string {
+	algs := []string{}
+	for _, alg := range km.Algorithms() {
+		if alg != jwt.SigningMethodHS256.Alg() {
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// CanSignIDTokens reports whether the current signing key is asymmetric
+func (km *KeyManager) CanSignIDTokens() bool {
+	key, err := km.SigningKey()
+	return err == nil && key.method != jwt.SigningMethodHS256
+}
+
+// JWK is a public key in RFC 7517 format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public asymmetric keys that are, or are about to be, used.
+// Scheduled keys are published ahead of activation so verifiers can cache them.
+// HS256 keys are shared secrets and are never published.
+func (km *KeyManager) JWKS() JWKSet {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	now := km.now()
+	set := JWKSet{Keys: []JWK{}}
+	for i, key := range km.keys {
+		if retire := km.retiresAt(i); !retire.IsZero() && !now.Before(retire) {
+			continue
+		}
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Algorithm,
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Algorithm,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return set
+}
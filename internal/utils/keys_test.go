@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func pemKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestKeyManagerRotation(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	km, err := NewKeyManager([]KeyConfig{
+		{ID: "old", Algorithm: "RS256", PrivateKey: pemKey(t, rsaKey), ActiveFrom: start},
+		{ID: "new", Algorithm: "EdDSA", PrivateKey: pemKey(t, edKey), ActiveFrom: start.Add(24 * time.Hour)},
+	}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	km.now = func() time.Time { return start.Add(time.Hour) }
+	oldToken, err := km.Sign(jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kids := len(km.JWKS().Keys); kids != 2 {
+		t.Fatalf("expected scheduled key to be published ahead of time, got %d keys", kids)
+	}
+
+	// Successor is active, old key is within the grace window
+	km.now = func() time.Time { return start.Add(24*time.Hour + 30*time.Minute) }
+	if key, _ := km.SigningKey(); key.ID != "new" {
+		t.Fatalf("expected new key to sign, got %s", key.ID)
+	}
+	if _, err := jwt.Parse(oldToken, km.Keyfunc, jwt.WithoutClaimsValidation()); err != nil {
+		t.Fatalf("expected old token to verify during grace window: %v", err)
+	}
+
+	// Grace window is over
+	km.now = func() time.Time { return start.Add(26 * time.Hour) }
+	if _, err := jwt.Parse(oldToken, km.Keyfunc, jwt.WithoutClaimsValidation()); err == nil {
+		t.Fatal("expected old token to be rejected after the grace window")
+	}
+	jwks := km.JWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].KeyID != "new" || jwks.Keys[0].Curve != "Ed25519" {
+		t.Fatalf("unexpected JWKS after rotation: %+v", jwks)
+	}
+}
+
+func TestKeyManagerRejectsAlgorithmMismatch(t *testing.T) {
+	km, err := NewKeyManager([]KeyConfig{{ID: "hmac", Algorithm: "HS256", Secret: "test-secret"}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{"sub": "1"})
+	token.Header["kid"] = "hmac"
+	signed, err := token.SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := jwt.Parse(signed, km.Keyfunc); err == nil {
+		t.Fatal("expected token signed with another algorithm to be rejected")
+	}
+	if len(km.JWKS().Keys) != 0 {
+		t.Fatal("expected HMAC secrets to never be published")
+	}
+	if km.CanSignIDTokens() || len(km.PublicAlgorithms()) != 0 {
+		t.Fatal("expected HMAC secrets not to sign ID tokens")
+	}
+}
+
+func TestLoadKeyManagerFromEnvRequiresSecret(t *testing.T) {
+	t.Setenv("JWT_KEYS_FILE", "")
+	t.Setenv("JWT_SIGNING_ALG", "HS256")
+	t.Setenv("JWT_SECRET", "")
+
+	t.Setenv("APP_ENV", "production")
+	if _, err := LoadKeyManagerFromEnv(); err == nil {
+		t.Fatal("expected a missing JWT_SECRET to be an error outside local development")
+	}
+
+	t.Setenv("APP_ENV", "local")
+	km, err := LoadKeyManagerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := LoadKeyManagerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := km.Sign(jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(token, other.Keyfunc); err == nil {
+		t.Fatal("expected every local key to be random")
+	}
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// OAuthAccessTokenType is the typ claim of access tokens issued to OAuth
+// clients. They are never accepted in place of first-party access tokens.
+const OAuthAccessTokenType = "oauth_access"
+
+// OAuthIssuer is the issuer of tokens issued by the authorization server,
+// configured with OAUTH_ISSUER and defaulting to URL
+func OAuthIssuer() string {
+	return strings.TrimSuffix(GetEnv("OAUTH_ISSUER", GetEnv("URL", "http://localhost:5000")), "/")
+}
+
+// serviceAccountSubjectPrefix marks the subject of tokens issued to service accounts
+const serviceAccountSubjectPrefix = "service_account:"
+
+// OAuthClaims are the claims of an access token issued to an OAuth client. The
+// subject is the user ID, the service account subject for clients of a service
+// account, or the client ID for other client credentials grants.
+type OAuthClaims struct {
+	jwt.RegisteredClaims
+	Type     string `json:"typ"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// Scopes returns the space separated scope claim as a list
+func (c *OAuthClaims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// ServiceAccountID returns the service account the token was issued to, if any
+func (c *OAuthClaims) ServiceAccountID() (uint, bool) {
+	if !strings.HasPrefix(c.Subject, serviceAccountSubjectPrefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(c.Subject, serviceAccountSubjectPrefix), 10, 32)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// ServiceAccountSubject is the subject of tokens issued to a service account
+func ServiceAccountSubject(serviceAccountID uint) string {
+	return serviceAccountSubjectPrefix + strconv.FormatUint(uint64(serviceAccountID), 10)
+}
+
+// IDTokenClaims are the claims of an OpenID Connect ID token
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string `json:"nonce,omitempty"`
+	AuthTime        int64  `json:"auth_time,omitempty"`
+	AuthorizedParty string `json:"azp,omitempty"`
+	Name            string `json:"name,omitempty"`
+	Email           string `json:"email,omitempty"`
+	EmailVerified   *bool  `json:"email_verified,omitempty"`
+}
+
+func newOAuthRegisteredClaims(subject string, audience []string, ttl time.Duration) jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Subject:   subject,
+		Issuer:    OAuthIssuer(),
+		Audience:  audience,
+		ID:        uuid.NewString(),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+}
+
+// CreateOAuthAccessToken signs an access token for the client, valid at this API
+func CreateOAuthAccessToken(subject, clientID string, scopes []string) (string, *OAuthClaims, error) {
+	keys, err := Keys()
+	if err != nil {
+		return "", nil, err
+	}
+
+	claims := &OAuthClaims{
+		RegisteredClaims: newOAuthRegisteredClaims(subject, TokenAudience(), AccessTokenTTL()),
+		Type:             OAuthAccessTokenType,
+		ClientID:         clientID,
+		Scope:            strings.Join(scopes, " "),
+	}
+	token, err := keys.Sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// ParseOAuthAccessToken validates an access token issued to an OAuth client
+func ParseOAuthAccessToken(tokenString string) (*OAuthClaims, error) {
+	var claims OAuthClaims
+	token, err := parseSignedToken(tokenString, &claims, OAuthIssuer(), TokenAudience())
+	if err != nil || !token.Valid || claims.Type != OAuthAccessTokenType || claims.ID == "" || claims.ClientID == "" {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// CreateIDToken signs an ID token for the client. The ID token is meant for
+// the client only, so its audience is the client ID. Clients cannot verify
+// HS256 secrets, so ID tokens are only signed with asymmetric keys.
+func CreateIDToken(claims IDTokenClaims, userID, clientID string) (string, error) {
+	keys, err := Keys()
+	if err != nil {
+		return "", err
+	}
+	if !keys.CanSignIDTokens() {
+		return "", ErrSymmetricKey
+	}
+
+	claims.RegisteredClaims = newOAuthRegisteredClaims(userID, []string{clientID}, AccessTokenTTL())
+	claims.AuthorizedParty = clientID
+	return keys.Sign(claims)
+}
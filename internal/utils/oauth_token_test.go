@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"Admin-gin/internal/models"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOAuthAccessTokenRoundTrip(t *testing.T) {
+	token, issued, err := CreateOAuthAccessToken("42", "client-1", []string{"openid", "user.read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseOAuthAccessToken(token)
+	if err != nil {
+		t.Fatalf("ParseOAuthAccessToken returned error: %v", err)
+	}
+	if claims.ID != issued.ID || claims.Subject != "42" || claims.ClientID != "client-1" || claims.Issuer != OAuthIssuer() {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+	if scopes := claims.Scopes(); len(scopes) != 2 || scopes[1] != "user.read" {
+		t.Fatalf("unexpected scopes %v", scopes)
+	}
+}
+
+func TestOAuthAndFirstPartyTokensAreNotInterchangeable(t *testing.T) {
+	oauthToken, _, err := CreateOAuthAccessToken("42", "client-1", []string{"user.read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseAccessToken(oauthToken); err == nil {
+		t.Error("expected an OAuth access token to be rejected as a first-party token")
+	}
+
+	firstParty, _, err := CreateToken(&models.User{ID: 42}, "session-1", Authentication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseOAuthAccessToken(firstParty); err == nil {
+		t.Error("expected a first-party token to be rejected as an OAuth access token")
+	}
+}
+
+func TestCreateIDToken(t *testing.T) {
+	hmac, err := NewKeyManager([]KeyConfig{{ID: "hmac", Algorithm: "HS256", Secret: "test-secret"}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ed, err := NewKeyManager([]KeyConfig{{ID: "ed", Algorithm: "EdDSA", PrivateKey: pemKey(t, edKey)}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, _ := Keys()
+	t.Cleanup(func() { SetKeys(previous) })
+
+	SetKeys(hmac)
+	if _, err := CreateIDToken(IDTokenClaims{}, "42", "client-1"); !errors.Is(err, ErrSymmetricKey) {
+		t.Fatalf("expected HS256 to be refused for ID tokens, got %v", err)
+	}
+
+	SetKeys(ed)
+	verified := true
+	token, err := CreateIDToken(IDTokenClaims{Nonce: "n-1", Email: "jane@example.com", EmailVerified: &verified}, "42", "client-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims IDTokenClaims
+	parsed, err := parseSignedToken(token, &claims, OAuthIssuer(), []string{"client-1"})
+	if err != nil || !parsed.Valid {
+		t.Fatalf("expected a valid ID token for the client, got %v", err)
+	}
+	if claims.Nonce != "n-1" || claims.AuthorizedParty != "client-1" || claims.Subject != "42" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if _, err := parseSignedToken(token, &IDTokenClaims{}, OAuthIssuer(), []string{"client-2"}); err == nil {
+		t.Error("expected the ID token to be rejected for another client")
+	}
+}
+
+func TestServiceAccountSubject(t *testing.T) {
+	claims := &OAuthClaims{}
+	claims.Subject = ServiceAccountSubject(9)
+	if id, ok := claims.ServiceAccountID(); !ok || id != 9 {
+		t.Fatalf("expected service account 9, got %d %v", id, ok)
+	}
+
+	for _, subject := range []string{"42", "client-1", "service_account:", "service_account:x"} {
+		claims.Subject = subject
+		if _, ok := claims.ServiceAccountID(); ok {
+			t.Errorf("expected subject %q not to be a service account", subject)
+		}
+	}
+}
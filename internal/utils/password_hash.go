@@ -0,0 +1,244 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUnsupportedHash = errors.New("unsupported password hash format")
+	ErrMalformedHash   = errors.New("malformed password hash")
+)
+
+// PasswordHasher hashes passwords into self-describing encoded strings: PHC
+// strings for argon2id and modular crypt strings for bcrypt
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches. It returns ErrUnsupportedHash
+	// when the encoded hash was produced by another algorithm.
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether the hash was produced by another algorithm or
+	// with parameters other than the configured ones
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2idHasher produces $argon2id$v=19$m=<KiB>,t=<iterations>,p=<threads>$<salt>$<key>
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idHasher follows the OWASP recommendation for argon2id
+func DefaultArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.memory != h.Memory ||
+		params.iterations != h.Iterations ||
+		params.parallelism != h.Parallelism ||
+		uint32(len(params.salt)) != h.SaltLength ||
+		uint32(len(params.key)) != h.KeyLength
+}
+
+func parseArgon2id(encoded string) (*argon2idParams, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, ErrUnsupportedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrMalformedHash
+	}
+
+	var params argon2idParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return nil, ErrMalformedHash
+	}
+	if params.iterations == 0 || params.parallelism == 0 {
+		return nil, ErrMalformedHash
+	}
+
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrMalformedHash
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 {
+		return nil, ErrMalformedHash
+	}
+	return &params, nil
+}
+
+// BcryptHasher produces $2a$<cost>$... hashes
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	if !isBcryptHash(encoded) {
+		return false, ErrUnsupportedHash
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	} else if err != nil {
+		return false, ErrMalformedHash
+	}
+	return true, nil
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	if !isBcryptHash(encoded) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+func isBcryptHash(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+// multiHasher hashes with the preferred algorithm and verifies hashes of any
+// known algorithm, so existing hashes keep working after a switch
+type multiHasher struct {
+	preferred PasswordHasher
+	legacy    []PasswordHasher
+}
+
+// NewPasswordHasher hashes new passwords with preferred and still verifies
+// hashes produced by the legacy hashers
+func NewPasswordHasher(preferred PasswordHasher, legacy ...PasswordHasher) PasswordHasher {
+	return &multiHasher{preferred: preferred, legacy: legacy}
+}
+
+func (m *multiHasher) Hash(password string) (string, error) {
+	return m.preferred.Hash(password)
+}
+
+func (m *multiHasher) Verify(password, encoded string) (bool, error) {
+	for _, hasher := range append([]PasswordHasher{m.preferred}, m.legacy...) {
+		ok, err := hasher.Verify(password, encoded)
+		if errors.Is(err, ErrUnsupportedHash) {
+			continue
+		}
+		return ok, err
+	}
+	return false, ErrUnsupportedHash
+}
+
+func (m *multiHasher) NeedsRehash(encoded string) bool {
+	return m.preferred.NeedsRehash(encoded)
+}
+
+var (
+	defaultHasher     PasswordHasher
+	defaultHasherErr  error
+	defaultHasherOnce sync.Once
+)
+
+// Passwords returns the process wide PasswordHasher, configured on first use
+// from the environment
+func Passwords() (PasswordHasher, error) {
+	defaultHasherOnce.Do(func() {
+		defaultHasher, defaultHasherErr = LoadPasswordHasherFromEnv()
+	})
+	return defaultHasher, defaultHasherErr
+}
+
+// LoadPasswordHasherFromEnv hashes with PASSWORD_HASH_ALG (argon2id or bcrypt)
+// using ARGON2_MEMORY (KiB), ARGON2_ITERATIONS, ARGON2_PARALLELISM and
+// BCRYPT_COST. Hashes of the other algorithm are still verified and upgraded.
+func LoadPasswordHasherFromEnv() (PasswordHasher, error) {
+	defaults := DefaultArgon2idHasher()
+	memory := GetEnvInt("ARGON2_MEMORY", int(defaults.Memory))
+	iterations := GetEnvInt("ARGON2_ITERATIONS", int(defaults.Iterations))
+	parallelism := GetEnvInt("ARGON2_PARALLELISM", int(defaults.Parallelism))
+	if iterations < 1 || parallelism < 1 || parallelism > 255 || memory < 8*parallelism {
+		return nil, errors.New("invalid argon2id parameters: ARGON2_MEMORY must be at least 8 KiB per thread and ARGON2_PARALLELISM between 1 and 255")
+	}
+	argon := &Argon2idHasher{
+		Memory:      uint32(memory),
+		Iterations:  uint32(iterations),
+		Parallelism: uint8(parallelism),
+		SaltLength:  defaults.SaltLength,
+		KeyLength:   defaults.KeyLength,
+	}
+	bcryptHasher := &BcryptHasher{Cost: GetEnvInt("BCRYPT_COST", bcrypt.DefaultCost)}
+	if bcryptHasher.Cost < bcrypt.MinCost || bcryptHasher.Cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	switch alg := GetEnv("PASSWORD_HASH_ALG", "argon2id"); alg {
+	case "argon2id":
+		return NewPasswordHasher(argon, bcryptHasher), nil
+	case "bcrypt":
+		return NewPasswordHasher(bcryptHasher, argon), nil
+	default:
+		return nil, fmt.Errorf("unsupported PASSWORD_HASH_ALG %q", alg)
+	}
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+func TestArgon2idHasherPHCFormat(t *testing.T) {
+	hasher := testArgon2idHasher()
+	encoded, err := hasher.Hash("s3cret-Password")
+	if err != nil {
+		t.Fatalf("Hash returned error: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("unexpected encoding %q", encoded)
+	}
+
+	if ok, err := hasher.Verify("s3cret-Password", encoded); err != nil || !ok {
+		t.Fatalf("expected password to verify, got %v (%v)", ok, err)
+	}
+	if ok, _ := hasher.Verify("wrong", encoded); ok {
+		t.Fatal("expected wrong password to be rejected")
+	}
+	if hasher.NeedsRehash(encoded) {
+		t.Fatal("expected hash with current parameters to be kept")
+	}
+
+	stronger := testArgon2idHasher()
+	stronger.Iterations = 2
+	if !stronger.NeedsRehash(encoded) {
+		t.Fatal("expected hash with outdated parameters to need a rehash")
+	}
+	if ok, err := stronger.Verify("s3cret-Password", encoded); err != nil || !ok {
+		t.Fatalf("expected hash to verify with its own parameters, got %v (%v)", ok, err)
+	}
+}
+
+func TestArgon2idHasherRejectsMalformedHash(t *testing.T) {
+	hasher := testArgon2idHasher()
+	if _, err := hasher.Verify("x", "$argon2id$v=19$m=64,t=1$abc$def"); !errors.Is(err, ErrMalformedHash) {
+		t.Fatalf("expected ErrMalformedHash, got %v", err)
+	}
+	if _, err := hasher.Verify("x", "$2a$10$abcdefghijklmnopqrstuv"); !errors.Is(err, ErrUnsupportedHash) {
+		t.Fatalf("expected ErrUnsupportedHash, got %v", err)
+	}
+}
+
+func TestPasswordHasherUpgradesBcrypt(t *testing.T) {
+	legacy := &BcryptHasher{Cost: bcrypt.MinCost}
+	encoded, err := legacy.Hash("s3cret-Password")
+	if err != nil {
+		t.Fatalf("Hash returned error: %v", err)
+	}
+
+	hasher := NewPasswordHasher(testArgon2idHasher(), legacy)
+	if ok, err := hasher.Verify("s3cret-Password", encoded); err != nil || !ok {
+		t.Fatalf("expected bcrypt hash to verify, got %v (%v)", ok, err)
+	}
+	if !hasher.NeedsRehash(encoded) {
+		t.Fatal("expected bcrypt hash to need a rehash to argon2id")
+	}
+
+	upgraded, err := hasher.Hash("s3cret-Password")
+	if err != nil {
+		t.Fatalf("Hash returned error: %v", err)
+	}
+	if !strings.HasPrefix(upgraded, "$argon2id$") || hasher.NeedsRehash(upgraded) {
+		t.Fatalf("expected an up to date argon2id hash, got %q", upgraded)
+	}
+}
+
+func TestBcryptHasherNeedsRehashOnCostChange(t *testing.T) {
+	encoded, err := (&BcryptHasher{Cost: bcrypt.MinCost}).Hash("s3cret-Password")
+	if err != nil {
+		t.Fatalf("Hash returned error: %v", err)
+	}
+	if (&BcryptHasher{Cost: bcrypt.MinCost}).NeedsRehash(encoded) {
+		t.Fatal("expected hash with current cost to be kept")
+	}
+	if !(&BcryptHasher{Cost: bcrypt.MinCost + 1}).NeedsRehash(encoded) {
+		t.Fatal("expected hash with a lower cost to need a rehash")
+	}
+}
+
+func TestLoadPasswordHasherFromEnv(t *testing.T) {
+	t.Setenv("PASSWORD_HASH_ALG", "md5")
+	if _, err := LoadPasswordHasherFromEnv(); err == nil {
+		t.Fatal("expected unsupported algorithm to be rejected")
+	}
+
+	t.Setenv("PASSWORD_HASH_ALG", "argon2id")
+	t.Setenv("ARGON2_PARALLELISM", "0")
+	if _, err := LoadPasswordHasherFromEnv(); err == nil {
+		t.Fatal("expected invalid argon2id parameters to be rejected")
+	}
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// PermissionWildcard matches any segment of a permission name
+const PermissionWildcard = "*"
+
+var permissionSegment = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// ValidPermissionName reports whether name follows the resource.action
+// convention: lowercase segments separated by dots, like user.read or
+// service_account.update. A grant may also be a pattern: user.* grants every
+// permission of a resource, including user.mfa.reset, *.read one action on
+// every resource with a two segment name and * every permission.
+func ValidPermissionName(name string) bool {
+	if name == PermissionWildcard {
+		return true
+	}
+	if len(name) > 100 {
+		return false
+	}
+	segments := strings.Split(name, ".")
+	if len(segments) < 2 {
+		return false
+	}
+	for i, segment := range segments {
+		if segment == PermissionWildcard {
+			// Only the last segment, or the first one of resource.action
+			last := i == len(segments)-1
+			if !last && !(i == 0 && len(segments) == 2) {
+				return false
+			}
+			if last && i == 1 && segments[0] == PermissionWildcard {
+				return false
+			}
+			continue
+		}
+		if !permissionSegment.MatchString(segment) {
+			return false
+		}
+	}
+	return true
+}
+
+// PermissionMatcher decides which of a set of granted permissions, exact names
+// or patterns, allows a permission. Lookups cost a few map accesses whatever
+// the number of grants.
+type PermissionMatcher struct {
+	exact    map[string]bool
+	prefixes map[string]bool // "user.mfa" for user.mfa.*
+	actions  map[string]bool // "read" for *.read
+	all      bool
+}
+
+func NewPermissionMatcher(grants []string) *PermissionMatcher {
+	m := &PermissionMatcher{
+		exact:    make(map[string]bool, len(grants)),
+		prefixes: make(map[string]bool),
+		actions:  make(map[string]bool),
+	}
+	for _, grant := range grants {
+		switch {
+		case grant == PermissionWildcard:
+			m.all = true
+		case strings.HasSuffix(grant, "."+PermissionWildcard):
+			m.prefixes[strings.TrimSuffix(grant, "."+PermissionWildcard)] = true
+		case strings.HasPrefix(grant, PermissionWildcard+"."):
+			m.actions[strings.TrimPrefix(grant, PermissionWildcard+".")] = true
+		default:
+			m.exact[grant] = true
+		}
+	}
+	return m
+}
+
+// Match returns the grant allowing permission, which may itself be a pattern
+// when asking whether one grant covers another. When several grants match, the
+// most specific one wins: the exact name, then the longest resource pattern,
+// then the action pattern and finally *.
+func (m *PermissionMatcher) Match(permission string) (string, bool) {
+	if m.exact[permission] {
+		return permission, true
+	}
+
+	name := strings.TrimSuffix(permission, "."+PermissionWildcard)
+	// Walk up the resource hierarchy: user.mfa.reset is covered by user.mfa.*
+	// and user.*, user.* only by user.*
+	prefix := name
+	if name == permission {
+		prefix = parentPermission(name)
+	}
+	for ; prefix != "" && prefix != PermissionWildcard; prefix = parentPermission(prefix) {
+		if m.prefixes[prefix] {
+			return prefix + "." + PermissionWildcard, true
+		}
+	}
+
+	if resource, action, ok := strings.Cut(permission, "."); ok && resource != "" &&
+		!strings.Contains(action, ".") && action != PermissionWildcard && m.actions[action] {
+		return PermissionWildcard + "." + action, true
+	}
+
+	if m.all {
+		return PermissionWildcard, true
+	}
+	return "", false
+}
+
+// Allows reports whether any grant allows permission
+func (m *PermissionMatcher) Allows(permission string) bool {
+	_, ok := m.Match(permission)
+	return ok
+}
+
+// parentPermission strips the last segment: user.mfa for user.mfa.reset
+func parentPermission(name string) string {
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		return ""
+	}
+	return name[:i]
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestValidPermissionName(t *testing.T) {
+	valid := []string{"user.read", "service_account.update", "user.mfa.reset", "*", "user.*", "user.mfa.*", "*.read"}
+	for _, name := range valid {
+		if !ValidPermissionName(name) {
+			t.Errorf("expected %q to be valid", name)
+		}
+	}
+	invalid := []string{"", "user", "User.read", "user..read", "user.read.", ".read", "user read", "*.*", "*.mfa.reset", "user.*.read", "user*.read", "**"}
+	for _, name := range invalid {
+		if ValidPermissionName(name) {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
+
+func TestPermissionMatcher(t *testing.T) {
+	tests := []struct {
+		grants     []string
+		permission string
+		match      string
+	}{
+		{[]string{"user.read"}, "user.read", "user.read"},
+		{[]string{"user.read"}, "user.update", ""},
+		{[]string{"user.*"}, "user.read", "user.*"},
+		{[]string{"user.*"}, "user.mfa.reset", "user.*"},
+		{[]string{"user.*"}, "users.read", ""},
+		{[]string{"user.*"}, "user", ""},
+		{[]string{"*.read"}, "role.read", "*.read"},
+		{[]string{"*.read"}, "role.update", ""},
+		{[]string{"*.read"}, "user.mfa.read", ""},
+		{[]string{"*"}, "system.admin", "*"},
+		// The most specific grant wins
+		{[]string{"*", "*.read", "user.*", "user.read"}, "user.read", "user.read"},
+		{[]string{"*", "*.read", "user.*", "user.mfa.*"}, "user.mfa.reset", "user.mfa.*"},
+		{[]string{"*", "*.read", "user.*"}, "user.read", "user.*"},
+		{[]string{"*", "*.read"}, "user.read", "*.read"},
+		// Grants covering other grants
+		{[]string{"user.*"}, "user.*", "user.*"},
+		{[]string{"user.*"}, "user.mfa.*", "user.*"},
+		{[]string{"user.mfa.*"}, "user.*", ""},
+		{[]string{"*.read"}, "*.read", "*.read"},
+		{[]string{"user.*"}, "*.read", ""},
+		{[]string{"user.*", "*.read"}, "*", ""},
+		{[]string{"*"}, "*", "*"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v %s", tt.grants, tt.permission), func(t *testing.T) {
+			match, ok := NewPermissionMatcher(tt.grants).Match(tt.permission)
+			if match != tt.match || ok != (tt.match != "") {
+				t.Fatalf("expected %q, got %q (%v)", tt.match, match, ok)
+			}
+		})
+	}
+}
+
+func benchmarkGrants(n int) []string {
+	grants := make([]string, 0, n+2)
+	for i := 0; len(grants) < n; i++ {
+		for _, action := range []string{"read", "create", "update", "delete"} {
+			grants = append(grants, fmt.Sprintf("resource_%d.%s", i, action))
+		}
+	}
+	return append(grants, "report.*", "*.export")
+}
+
+func BenchmarkPermissionMatcher(b *testing.B) {
+	for _, n := range []int{10, 100, 500} {
+		grants := benchmarkGrants(n)
+		matcher := NewPermissionMatcher(grants)
+		b.Run(fmt.Sprintf("match/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				matcher.Allows("resource_missing.delete")
+			}
+		})
+		b.Run(fmt.Sprintf("build+match/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				NewPermissionMatcher(grants).Allows("resource_missing.delete")
+			}
+		})
+	}
+}
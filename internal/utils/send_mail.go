@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/smtp"
 	"os"
+	"time"
 )
 
 var url = os.Getenv("URL")
@@ -38,3 +39,18 @@ func SendPasswordResetEmail(to, token string) error {
 	body := "Click here to reset your password: " + resetLink
 	return SendMail(to, subject, body)
 }
+
+func SendAccountLockedEmail(to string, until time.Time) error {
+	subject := "Your account has been temporarily locked"
+	body := fmt.Sprintf("We detected repeated failed sign-in attempts on your account, so it has been locked until %s.\r\n"+
+		"If this was not you, we recommend resetting your password.", until.UTC().Format(time.RFC1123))
+	return SendMail(to, subject, body)
+}
+
+func SendMagicLinkEmail(to, token string) error {
+	loginLink := fmt.Sprintf("%s/api/login/magic/verify?token=%s", url, token)
+	subject := "Your sign-in link"
+	body := "Click here to sign in: " + loginLink + "\r\n" +
+		"The link only works in the browser you requested it from. If you did not ask for it, you can ignore this email."
+	return SendMail(to, subject, body)
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238 appendix B (SHA1, 8 digits)
+func TestHOTPRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, v := range vectors {
+		if got := hotp(key, uint64(v.unix/TOTPPeriod), 8); got != v.code {
+			t.Errorf("hotp at %d = %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	code, err := TOTPCode(secret, now.Add(-TOTPPeriod*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ValidateTOTP(secret, code, now); !ok {
+		t.Fatal("expected code from previous step to be accepted")
+	}
+
+	code, _ = TOTPCode(secret, now.Add(-3*TOTPPeriod*time.Second))
+	if _, ok := ValidateTOTP(secret, code, now); ok {
+		t.Fatal("expected stale code to be rejected")
+	}
+
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Fatal("expected short code to be rejected")
+	}
+}
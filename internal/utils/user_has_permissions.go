@@ -6,24 +6,60 @@ import (
 	"gorm.io/gorm"
 )
 
-func GetUserPermissions(db *gorm.DB, userID uint) ([]models.Permission, error) {
+// roleGrantsSQL selects the grants of a set of roles, given as a subquery,
+// made directly or through any of their ancestors. UNION drops the roles
+// already visited, so the walk ends even if the graph has a cycle.
+const roleGrantsSQL = `WITH RECURSIVE role_closure(id) AS (
+	SELECT roles.id FROM roles WHERE roles.id IN (?) AND roles.deleted_at IS NULL
+	UNION
+	SELECT roles.id FROM role_has_parents
+	JOIN role_closure ON role_has_parents.role_id = role_closure.id
+	JOIN roles ON roles.id = role_has_parents.parent_id AND roles.deleted_at IS NULL
+)
+SELECT DISTINCT roles.id AS role_id, roles.name AS role,
+	permissions.id AS permission_id, permissions.name AS permission,
+	role_has_permissions.effect, role_has_permissions.scope, role_has_permissions.resource_id,
+	role_has_permissions.condition
+FROM role_has_permissions
+JOIN permissions ON permissions.id = role_has_permissions.permission_id AND permissions.deleted_at IS NULL
+JOIN roles ON roles.id = role_has_permissions.role_id
+WHERE role_has_permissions.role_id IN (SELECT id FROM role_closure)
+	AND role_has_permissions.deleted_at IS NULL
+ORDER BY permissions.name, roles.name`
+
+// GetUserPermissions returns the allows and denies of the user's roles and
+// the roles those inherit from. Evaluate them with Evaluate.
+func GetUserPermissions(db *gorm.DB, userID uint) ([]Grant, error) {
 	var user models.User
-	err := db.Preload("Roles.Permissions").First(&user, userID).Error
-	if err != nil {
+	if err := db.Select("id").First(&user, userID).Error; err != nil {
 		return nil, err
 	}
 
-	permMap := make(map[uint]models.Permission)
-	for _, role := range user.Roles {
-		for _, perm := range role.Permissions {
-			permMap[perm.ID] = perm
-		}
+	return roleGrants(db, db.Table("user_has_roles").Select("role_id").Where("user_id = ?", userID))
+}
+
+// GetServiceAccountPermissions returns the allows and denies of the roles of a service account
+func GetServiceAccountPermissions(db *gorm.DB, serviceAccountID uint) ([]Grant, error) {
+	var account models.ServiceAccount
+	if err := db.Select("id").First(&account, serviceAccountID).Error; err != nil {
+		return nil, err
 	}
 
-	perms := make([]models.Permission, 0, len(permMap))
-	for _, p := range permMap {
-		perms = append(perms, p)
+	return roleGrants(db, db.Table("service_account_has_roles").Select("role_id").Where("service_account_id = ?", serviceAccountID))
+}
+
+// GetRoleGrants returns the grants of roles including the inherited ones
+func GetRoleGrants(db *gorm.DB, roleIDs ...uint) ([]Grant, error) {
+	if len(roleIDs) == 0 {
+		return []Grant{}, nil
 	}
+	return roleGrants(db, roleIDs)
+}
 
-	return perms, nil
+func roleGrants(db *gorm.DB, roleIDs interface{}) ([]Grant, error) {
+	grants := []Grant{}
+	if err := db.Raw(roleGrantsSQL, roleIDs).Scan(&grants).Error; err != nil {
+		return nil, err
+	}
+	return grants, nil
 }